@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/config"
+	"github.com/septivank/energy-metering-ingest-api/internal/handler"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+)
+
+// startAdminServer serves /metrics, the /admin routes and, with
+// ENABLE_PPROF=true, /debug/pprof on ADMIN_PORT, so the public listener only
+// exposes the ingest, health and docs routes. Without ADMIN_PORT they stay
+// on the public listener (pprof on PPROF_PORT).
+func startAdminServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, adminHandler *handler.AdminHandler) error {
+	if cfg.AdminPort == 0 {
+		return nil
+	}
+
+	router, err := NewRouter(cfg)
+	if err != nil {
+		return err
+	}
+	if len(cfg.ResponseHeaders) > 0 {
+		router.Use(middleware.StaticHeaders(cfg.ResponseHeaders))
+	}
+	router.Use(middleware.RequestContext(logger, cfg.RequestIDFormat))
+	router.Use(middleware.ClientIPResolver(cfg.ClientIPStrategy, cfg.TrustedProxies))
+	router.Use(middleware.Recovery(logger))
+	router.Use(middleware.RequestLogger(logger, middleware.RequestLoggerConfig{
+		// Scraped every few seconds, so sampled like the health probes
+		ProbePaths:    []string{"/metrics"},
+		ProbeLogEvery: cfg.ProbeLogEvery,
+	}))
+	registerAdminRoutes(router, adminHandler, logger, cfg)
+	if cfg.EnablePprof {
+		router.Any("/debug/pprof/*path", gin.WrapH(newPprofMux()))
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.AdminHost, cfg.AdminPort),
+		Handler: router,
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			logger.Info("starting admin server", zap.String("addr", srv.Addr), zap.Bool("pprof", cfg.EnablePprof))
+			go func() {
+				if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Error("admin server error", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return srv.Shutdown(ctx)
+		},
+	})
+	return nil
+}
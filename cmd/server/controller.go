@@ -1,7 +1,13 @@
 package main
 
 import (
+	"net/http"
+	"slices"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
 	"github.com/septivank/energy-metering-ingest-api/internal/config"
@@ -10,27 +16,120 @@ import (
 )
 
 // RegisterRoutes registers HTTP routes on the provided Gin engine
-func RegisterRoutes(r *gin.Engine, meterHandler *handler.MeterHandler, healthHandler *handler.HealthHandler, logger *zap.Logger, cfg *config.Config) {
+func RegisterRoutes(r *gin.Engine, meterHandler *handler.MeterHandler, healthHandler *handler.HealthHandler, adminHandler *handler.AdminHandler, docsHandler *handler.DocsHandler, logger *zap.Logger, cfg *config.Config) {
 	// Global middleware
-	r.Use(middleware.CORS())
+	if len(cfg.ResponseHeaders) > 0 {
+		r.Use(middleware.StaticHeaders(cfg.ResponseHeaders))
+	}
+	r.Use(middleware.RequestContext(logger, cfg.RequestIDFormat))
+	if cfg.MaxUserAgentBytes > 0 {
+		r.Use(middleware.LimitUserAgent(cfg.MaxUserAgentBytes))
+	}
+	r.Use(middleware.TrackInFlight())
+	r.Use(middleware.ClientIPResolver(cfg.ClientIPStrategy, cfg.TrustedProxies))
+	r.Use(middleware.HTTPMetrics())
 	r.Use(middleware.Recovery(logger))
-	r.Use(middleware.RequestLogger(logger))
+	r.Use(middleware.RequestLogger(logger, middleware.RequestLoggerConfig{
+		ProbePaths:    []string{"/health", cfg.HealthPath, "/ready"},
+		ProbeLogEvery: cfg.ProbeLogEvery,
+	}))
 
-	// Health endpoint (without service prefix for K8s probes)
+	// Health endpoint (bare path for K8s probes, plus the configured path)
 	r.GET("/health", healthHandler.Check)
+	if cfg.HealthPath != "/health" {
+		r.GET(cfg.HealthPath, healthHandler.Check)
+	}
+	r.GET("/ready", healthHandler.Ready)
 
-	// Base path with service name
-	basePath := r.Group("/" + cfg.ServiceName)
+	// API documentation
+	r.GET("/openapi.json", docsHandler.Spec)
+	if cfg.EnableAPIDocs {
+		r.GET("/docs", docsHandler.UI)
+	}
+
+	// Metrics and admin routes, unless they have a listener of their own
+	if cfg.AdminPort == 0 {
+		registerAdminRoutes(r, adminHandler, logger, cfg)
+	}
+
+	// API routes
+	api := r.Group(cfg.APIBasePath)
 	{
-		// Health endpoint with service prefix
-		basePath.GET("/health", healthHandler.Check)
+		// Probes live outside the API group, so they are never timed out
+		if cfg.RequestTimeout > 0 {
+			api.Use(middleware.RequestTimeout(time.Duration(cfg.RequestTimeout) * time.Second))
+		}
+		if cfg.BodyReadTimeout > 0 {
+			api.Use(middleware.BodyReadTimeout(time.Duration(cfg.BodyReadTimeout)*time.Second, logger))
+		}
+		if cfg.MaxRequestBodyBytes > 0 {
+			api.Use(middleware.MaxBodySize(cfg.MaxRequestBodyBytes))
+		}
+		if len(cfg.CORSAllowedOrigins) > 0 {
+			api.Use(middleware.CORS(middleware.CORSConfig{
+				AllowedOrigins:   cfg.CORSAllowedOrigins,
+				AllowedMethods:   cfg.CORSAllowedMethods,
+				AllowedHeaders:   cfg.CORSAllowedHeaders,
+				AllowCredentials: cfg.CORSAllowCredentials,
+				MaxAge:           time.Duration(cfg.CORSMaxAge) * time.Second,
+			}))
+			// Preflight requests only reach group middleware through a matching route
+			api.OPTIONS("/*path", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		}
+
+		ingest := []gin.HandlerFunc{middleware.RequireContentType(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK)}
+		if cfg.MaxConcurrentIngests > 0 {
+			var wait time.Duration
+			if cfg.BackpressureMode == "wait" {
+				wait = time.Duration(cfg.BackpressureWait) * time.Millisecond
+			}
+			ingest = append([]gin.HandlerFunc{middleware.ConcurrencyLimit(cfg.MaxConcurrentIngests, wait, time.Second)}, ingest...)
+		}
+		if cfg.RejectWhileDraining {
+			ingest = append([]gin.HandlerFunc{middleware.RejectWhileDraining()}, ingest...)
+		}
+		if len(cfg.SignatureKeys) > 0 {
+			ingest = append(ingest, middleware.VerifySignature(middleware.SignatureConfig{
+				Keys:    cfg.SignatureKeys,
+				MaxSkew: time.Duration(cfg.SignatureMaxSkew) * time.Second,
+			}, logger))
+		}
+		echo := slices.Clone(ingest)
+		if cfg.NonceTTL > 0 {
+			cache := middleware.NewNonceCache(time.Duration(cfg.NonceTTL)*time.Second, cfg.NonceCacheSize)
+			ingest = append(ingest, middleware.RejectReplayedNonce(cache, logger))
+		}
+
+		meter := api.Group("/meter")
+		{
+			meter.POST("/readings", append(ingest, meterHandler.IngestReading)...)
+			if cfg.EnableEcho {
+				// Signed like ingest, but without the nonce check, so a
+				// request can be echoed and then sent for real unchanged
+				meter.POST("/readings/echo", append(echo, meterHandler.EchoReading)...)
+			}
+			if cfg.StatusTTL > 0 {
+				meter.GET("/readings/:request_id/status", meterHandler.GetStatus)
+			}
+		}
+	}
+}
+
+// registerAdminRoutes registers the Prometheus metrics and, when an admin
+// token is configured, the /admin routes
+func registerAdminRoutes(r *gin.Engine, adminHandler *handler.AdminHandler, logger *zap.Logger, cfg *config.Config) {
+	// Prometheus metrics
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-		// API routes
-		api := basePath.Group("/api/v1")
+	// Admin routes, only registered when an admin token is configured
+	if cfg.AdminToken != "" {
+		admin := r.Group("/admin", middleware.AdminAuth(cfg.AdminToken, logger))
 		{
-			meter := api.Group("/meter")
-			{
-				meter.POST("/readings", meterHandler.IngestReading)
+			admin.GET("/loglevel", adminHandler.GetLogLevel)
+			admin.PUT("/loglevel", adminHandler.SetLogLevel)
+			admin.POST("/selftest", adminHandler.SelfTest)
+			if cfg.MeterCountsWindow > 0 {
+				admin.GET("/meters", adminHandler.GetMeters)
 			}
 		}
 	}
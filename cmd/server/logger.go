@@ -1,16 +1,33 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"go.uber.org/zap"
 )
 
-func newLogger() (*zap.Logger, error) {
+// newLogger builds the application logger and returns its level so it can be
+// changed at runtime through the admin API
+func newLogger() (*zap.Logger, zap.AtomicLevel, error) {
 	// Use development mode if in local environment
+	var zapConfig zap.Config
 	env := os.Getenv("ENV")
 	if env == "development" || env == "dev" || env == "" {
-		return zap.NewDevelopment()
+		zapConfig = zap.NewDevelopmentConfig()
+	} else {
+		zapConfig = zap.NewProductionConfig()
 	}
-	return zap.NewProduction()
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := zapConfig.Level.UnmarshalText([]byte(level)); err != nil {
+			return nil, zap.AtomicLevel{}, fmt.Errorf("invalid LOG_LEVEL %q: %w", level, err)
+		}
+	}
+
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, zap.AtomicLevel{}, err
+	}
+	return logger, zapConfig.Level, nil
 }
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,20 +16,32 @@ import (
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
+	"github.com/septivank/energy-metering-ingest-api/internal/audit"
 	"github.com/septivank/energy-metering-ingest-api/internal/config"
 	"github.com/septivank/energy-metering-ingest-api/internal/handler"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
 	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
 	"github.com/septivank/energy-metering-ingest-api/internal/service"
 )
 
-func NewRouter(cfg *config.Config) *gin.Engine {
+func NewRouter(cfg *config.Config) (*gin.Engine, error) {
 	// Set Gin mode based on configuration
 	if cfg.GinMode == "release" || cfg.GinMode == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	} else {
 		gin.SetMode(gin.DebugMode)
 	}
-	return gin.New()
+	response.SetLegacy(cfg.ResponseFormat == "legacy")
+	handler.ConfigureBinding()
+
+	router := gin.New()
+	// X-Forwarded-For and X-Real-IP are only honored from trusted proxies;
+	// an empty list makes ClientIP always use the connection's remote address
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	return router, nil
 }
 
 // loadEnvFile tries to load .env file from multiple locations
@@ -63,25 +76,174 @@ func main() {
 	// Load .env file with flexible path handling
 	loadEnvFile()
 
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateConfig(os.Stdout, os.Stderr))
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		panic(fmt.Sprintf("failed to load config: %v", err))
+	}
+
+	// Used outside the lifecycle, between the stop signal and app.Stop
+	var (
+		logger        *zap.Logger
+		healthHandler *handler.HealthHandler
+	)
 	app := fx.New(
+		fx.Supply(cfg),
 		fx.Provide(
-			config.Load,
 			newLogger,
 			func(cfg *config.Config, logger *zap.Logger) (*mq.Publisher, error) {
-				return mq.NewPublisher(
-					cfg.RabbitMQURL,
-					cfg.RabbitMQExchange,
-					cfg.RabbitMQMaxRetries,
-					cfg.RabbitMQRetryBaseDelay,
-					cfg.PublishConfirmTimeout,
-					logger,
-				)
+				return mq.NewPublisher(mq.Options{
+					URL:                   cfg.RabbitMQURL,
+					Exchange:              cfg.RabbitMQExchange,
+					MaxRetries:            cfg.RabbitMQMaxRetries,
+					RetryBaseDelay:        time.Duration(cfg.RabbitMQRetryBaseDelay) * time.Millisecond,
+					PublishConfirmTimeout: time.Duration(cfg.PublishConfirmTimeout) * time.Second,
+					NoConfirms:            !cfg.MQPublishConfirms,
+					FailFastWhenBlocked:   cfg.MQFailFastWhenBlocked,
+					Atomic:                cfg.MQBatchAtomic,
+					Mandatory:             cfg.MQMandatory,
+					ConfirmMode:           cfg.MQConfirmMode,
+					ContentType:           messageContentType(cfg.MQMessageFormat),
+					MaxBackoff:            time.Duration(cfg.RabbitMQMaxBackoff) * time.Millisecond,
+					Heartbeat:             time.Duration(cfg.RabbitMQHeartbeat) * time.Second,
+					DialTimeout:           time.Duration(cfg.RabbitMQDialTimeout) * time.Second,
+					Vhost:                 cfg.RabbitMQVhost,
+					KeepaliveInterval:     time.Duration(cfg.MQKeepaliveInterval) * time.Second,
+					ChannelIdleTimeout:    time.Duration(cfg.MQChannelIdleTimeout) * time.Second,
+					MaxConnectionLifetime: cfg.MQMaxConnLifetime,
+					LockWatchdogThreshold: time.Duration(cfg.MQLockWatchdog) * time.Second,
+					LockWatchdogReconnect: cfg.MQWatchdogReconnect,
+					Transient:             cfg.MQDeliveryMode == "transient",
+					MessageTTL:            time.Duration(cfg.MQMessageTTL) * time.Millisecond,
+					Compress:              cfg.MQCompressPayload,
+					CompressionLevel:      cfg.MQCompressionLevel,
+					Topology: mq.Topology{
+						DeclareExchange:   cfg.MQDeclareExchange,
+						ExchangeType:      cfg.MQExchangeType,
+						DeclareQueue:      cfg.MQDeclareQueue,
+						QueueName:         cfg.MQQueueName,
+						QueueDurable:      cfg.MQQueueDurable,
+						BindingKey:        cfg.MQQueueBindingKey,
+						MaxPriority:       uint8(cfg.MQMaxPriority),
+						AlternateExchange: cfg.MQAlternateExchange,
+						AlternateQueue:    cfg.MQAlternateQueue,
+					},
+					AdditionalTargets:    publishTargets(cfg.MQAdditionalTargets),
+					Fallback:             fallbackTarget(cfg),
+					DeadLetter:           deadLetterTarget(cfg),
+					PoolSize:             cfg.PublisherPoolSize,
+					Connections:          cfg.PublisherConnections,
+					SpoolDir:             cfg.SpoolDir,
+					SpoolMaxBytes:        int64(cfg.SpoolMaxBytes),
+					SpoolReplayBatchSize: cfg.SpoolReplayBatchSize,
+					SpoolReplayInterval:  time.Duration(cfg.SpoolReplayIntervalMs) * time.Millisecond,
+
+					StartupConnectMaxWait:    time.Duration(cfg.StartupConnectMaxWait) * time.Second,
+					ConfirmTimeoutPerMessage: time.Duration(cfg.ConfirmTimeoutPerMsg) * time.Millisecond,
+					MaxConfirmTimeout:        time.Duration(cfg.ConfirmTimeoutMax) * time.Second,
+				}, logger)
+			},
+			func(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) (*audit.Logger, error) {
+				if cfg.AuditLogPath == "" {
+					return nil, nil
+				}
+				auditLogger, err := audit.NewLogger(cfg.AuditLogPath, cfg.AuditBufferSize, logger)
+				if err != nil {
+					return nil, err
+				}
+				// Appended before the server's hook, so it stops after the server
+				lc.Append(fx.Hook{
+					OnStop: func(ctx context.Context) error {
+						return auditLogger.Close()
+					},
+				})
+				return auditLogger, nil
+			},
+			func(cfg *config.Config) *service.MeterCounter {
+				if cfg.MeterCountsWindow <= 0 {
+					return nil
+				}
+				return service.NewMeterCounter(time.Duration(cfg.MeterCountsWindow)*time.Second, cfg.MeterCountsMaxNames)
+			},
+			func(publisher *mq.Publisher, auditLogger *audit.Logger, meterCounter *service.MeterCounter, logger *zap.Logger, cfg *config.Config) *service.IngestService {
+				var statuses *service.StatusStore
+				if cfg.StatusTTL > 0 {
+					statuses = service.NewStatusStore(time.Duration(cfg.StatusTTL)*time.Second, cfg.StatusMaxEntries)
+				}
+				var recent *service.RecentReadings
+				if cfg.DedupeWindow > 0 {
+					recent = service.NewRecentReadings(time.Duration(cfg.DedupeWindow)*time.Second, cfg.DedupeCacheSize)
+				}
+				asyncWorkers := 0
+				if cfg.IngestMode == "async" {
+					asyncWorkers = cfg.AsyncWorkers
+				}
+				var deadLetter service.DeadLetterPublisher
+				if cfg.MQDeadLetterExchange != "" {
+					deadLetter = publisher
+				}
+				return service.NewIngestService(publisher, logger, service.Options{
+					RoutingKey:             cfg.RabbitMQRoutingKey,
+					Source:                 cfg.ServiceName + "/" + cfg.InstanceID,
+					SelfTestRoutingKey:     cfg.SelfTestRoutingKey,
+					RequestIDFormat:        cfg.RequestIDFormat,
+					PublishDeadline:        time.Duration(cfg.PublishDeadline) * time.Second,
+					LowercaseMeterNames:    cfg.LowercaseMeterNames,
+					DateLayout:             cfg.ReadingDateLayout,
+					RequireOrderedReadings: cfg.RequireOrderedReadings,
+					MaxReadingAge:          cfg.MaxReadingAge,
+					DedupeWithinRequest:    cfg.DedupeWithinRequest,
+					DuplicateNames:         duplicateNames(cfg),
+					FieldLimits: service.FieldLimits{
+						Name: cfg.MaxNameLen,
+						Date: cfg.MaxDateLen,
+						Data: cfg.MaxDataLen,
+					},
+					AllowedMeterNames:        cfg.AllowedMeterNames,
+					MeterNamePattern:         cfg.MeterNamePattern,
+					StatusStore:              statuses,
+					MaxPriority:              uint8(cfg.MQMaxPriority),
+					MeterPriorities:          meterPriorities(cfg.MeterPriorities),
+					MeterCounter:             meterCounter,
+					RecentReadings:           recent,
+					Audit:                    auditLogger,
+					AsyncWorkers:             asyncWorkers,
+					AsyncQueueSize:           cfg.AsyncQueueSize,
+					DeadLetter:               deadLetter,
+					ReceivedAtLayout:         receivedAtLayout(cfg.ReceivedAtFormat),
+					FingerprintInputs:        cfg.FingerprintInputs,
+					MessageFormat:            cfg.MQMessageFormat,
+					MultiTenancy:             cfg.MultiTenancyEnabled,
+					AllowedTenants:           cfg.AllowedTenants,
+					TenantRoutingKeyTemplate: cfg.TenantRoutingKey,
+					ShardCount:               cfg.MQShardCount,
+					BatchConfirms:            cfg.MQConfirmMode == mq.ConfirmModeBatch,
+					WarnRules:                cfg.ValidationWarnRules,
+					PartialAccept:            cfg.PartialAccept,
+					SampleOut:                1 - cfg.PublishSampleRate,
+					BatchWindow:              time.Duration(cfg.PublishBatchWindow) * time.Millisecond,
+					BatchMaxMessages:         cfg.PublishBatchMax,
+				})
+			},
+			func(ingestService *service.IngestService, logger *zap.Logger, cfg *config.Config) *handler.MeterHandler {
+				return handler.NewMeterHandler(ingestService, handler.PublishFailureConfig{
+					Status:     cfg.PublishFailureStatus,
+					RetryAfter: time.Duration(cfg.PublishRetryAfter) * time.Second,
+				}, logger)
 			},
-			func(publisher *mq.Publisher, logger *zap.Logger, cfg *config.Config) *service.IngestService {
-				return service.NewIngestService(publisher, logger, cfg.RabbitMQRoutingKey)
+			func(publisher *mq.Publisher, cfg *config.Config) *handler.HealthHandler {
+				return handler.NewHealthHandler(publisher, publisher, publisher, handler.ReadinessConfig{
+					Timeout:  time.Duration(cfg.ReadinessTimeoutMs) * time.Millisecond,
+					CacheTTL: time.Duration(cfg.ReadinessCacheMs) * time.Millisecond,
+				})
+			},
+			handler.NewAdminHandler,
+			func(cfg *config.Config) (*handler.DocsHandler, error) {
+				return handler.NewDocsHandler(cfg.APIBasePath)
 			},
-			handler.NewMeterHandler,
-			handler.NewHealthHandler,
 			NewRouter,
 		),
 		fx.Invoke(func(logger *zap.Logger, cfg *config.Config) {
@@ -90,37 +252,134 @@ func main() {
 				zap.Int("port", cfg.ServicePort),
 				zap.String("exchange", cfg.RabbitMQExchange),
 			)
+			for _, warning := range cfg.Warnings {
+				logger.Warn("Invalid configuration value ignored", zap.String("problem", warning))
+			}
+			logBrokerNames(logger, cfg)
 		}),
+		fx.Invoke(watchPublisherStartup),
+		// Before startServer, so the admin listener stops after the public
+		// one and metrics stay scrapeable while requests drain
+		fx.Invoke(startAdminServer),
 		fx.Invoke(startServer),
+		fx.Invoke(startPprofServer),
+		fx.Populate(&logger, &healthHandler),
 	)
 
-	// Load config first to get timeout values
-	cfg, err := config.Load()
-	if err != nil {
-		panic(fmt.Sprintf("failed to load config: %v", err))
-	}
-
 	startCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ServerStartTimeout)*time.Second)
 	defer cancel()
 	if err := app.Start(startCtx); err != nil {
 		panic(err)
 	}
 
-	// graceful shutdown on interrupt
+	// graceful shutdown on interrupt or SIGTERM, or when the broker never came up
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case sig := <-quit:
+		awaitDeregistration(logger, healthHandler, sig, time.Duration(cfg.PreShutdownDelay)*time.Second, quit)
+	case sig := <-app.Wait():
+		exitCode = sig.ExitCode
+	}
 
 	stopCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ServerStopTimeout)*time.Second)
 	defer cancel()
 	if err := app.Stop(stopCtx); err != nil {
 		fmt.Println("error stopping app:", err)
 	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 }
 
-func startServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, publisher *mq.Publisher, meterHandler *handler.MeterHandler, healthHandler *handler.HealthHandler, router *gin.Engine) {
+// watchPublisherStartup shuts the app down with a non-zero exit code if the
+// publisher cannot connect within STARTUP_CONNECT_MAX_WAIT_SEC. Until then
+// the HTTP server runs and /ready reports not-ready.
+func watchPublisherStartup(lc fx.Lifecycle, shutdowner fx.Shutdowner, publisher *mq.Publisher, logger *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go func() {
+				if err := <-publisher.Started(); err != nil {
+					logger.Error("rabbitmq publisher failed to connect at startup", zap.Error(err))
+					_ = shutdowner.Shutdown(fx.ExitCode(1))
+				}
+			}()
+			return nil
+		},
+	})
+}
+
+// logBrokerNames logs the exchange and queue names in use, after {env},
+// {region} and {service} were substituted, so operators can confirm them
+func logBrokerNames(logger *zap.Logger, cfg *config.Config) {
+	fields := []zap.Field{
+		zap.String("deploy_env", cfg.DeployEnv),
+		zap.String("deploy_region", cfg.DeployRegion),
+		zap.String("exchange", cfg.RabbitMQExchange),
+	}
+	if cfg.MQDeclareQueue {
+		fields = append(fields, zap.String("queue", cfg.MQQueueName))
+	}
+	if cfg.MQAlternateExchange != "" {
+		fields = append(fields, zap.String("alternate_exchange", cfg.MQAlternateExchange))
+	}
+	if cfg.MQAlternateQueue != "" {
+		fields = append(fields, zap.String("alternate_queue", cfg.MQAlternateQueue))
+	}
+	if cfg.MQFallbackExchange != "" {
+		fields = append(fields, zap.String("fallback_exchange", cfg.MQFallbackExchange))
+	}
+	if cfg.MQDeadLetterExchange != "" {
+		fields = append(fields, zap.String("dead_letter_exchange", cfg.MQDeadLetterExchange))
+	}
+	if len(cfg.MQAdditionalTargets) > 0 {
+		exchanges := make([]string, 0, len(cfg.MQAdditionalTargets))
+		for _, t := range cfg.MQAdditionalTargets {
+			exchanges = append(exchanges, t.Exchange)
+		}
+		fields = append(fields, zap.Strings("additional_exchanges", exchanges))
+	}
+	logger.Info("Broker names resolved", fields...)
+}
+
+// publishTargets converts configured publish targets to publisher targets
+func publishTargets(targets []config.PublishTarget) []mq.Target {
+	out := make([]mq.Target, 0, len(targets))
+	for _, t := range targets {
+		out = append(out, mq.Target{Exchange: t.Exchange, RoutingKey: t.RoutingKey})
+	}
+	return out
+}
+
+// fallbackTarget returns the configured fallback target, or nil when unset
+func fallbackTarget(cfg *config.Config) *mq.Target {
+	if cfg.MQFallbackExchange == "" {
+		return nil
+	}
+	return &mq.Target{Exchange: cfg.MQFallbackExchange, RoutingKey: cfg.MQFallbackRoutingKey}
+}
+
+// duplicateNames returns the service's policy for repeated meter names, or
+// "" when names need not be unique
+func duplicateNames(cfg *config.Config) string {
+	if !cfg.UniqueMeterNames {
+		return ""
+	}
+	return cfg.DuplicateNamePolicy
+}
+
+// deadLetterTarget returns the configured dead-letter target, or nil when unset
+func deadLetterTarget(cfg *config.Config) *mq.Target {
+	if cfg.MQDeadLetterExchange == "" {
+		return nil
+	}
+	return &mq.Target{Exchange: cfg.MQDeadLetterExchange, RoutingKey: cfg.MQDeadLetterKey}
+}
+
+func startServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, publisher *mq.Publisher, ingestService *service.IngestService, meterHandler *handler.MeterHandler, healthHandler *handler.HealthHandler, adminHandler *handler.AdminHandler, docsHandler *handler.DocsHandler, router *gin.Engine) {
 	// register routes
-	RegisterRoutes(router, meterHandler, healthHandler, logger, cfg)
+	RegisterRoutes(router, meterHandler, healthHandler, adminHandler, docsHandler, logger, cfg)
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.ServicePort),
@@ -129,9 +388,38 @@ func startServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, publis
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
+			// Loaded before serving anything, so a bad certificate stops startup
+			tlsConfig, err := serverTLSConfig(cfg)
+			if err != nil {
+				return err
+			}
+			srv.TLSConfig = tlsConfig
+			if cfg.ListenUnixSocket != "" {
+				ln, err := listenUnix(cfg.ListenUnixSocket)
+				if err != nil {
+					return err
+				}
+				go func() {
+					logger.Info("starting http server on unix socket", zap.String("path", cfg.ListenUnixSocket))
+					if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+						logger.Error("http server error", zap.Error(err))
+					}
+				}()
+			}
+			if cfg.DisableTCPListener {
+				return nil
+			}
 			go func() {
-				logger.Info("starting http server", zap.Int("port", cfg.ServicePort))
-				if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				var err error
+				if tlsConfig != nil {
+					logger.Info("starting https server", zap.Int("port", cfg.ServicePort), zap.String("min_tls_version", cfg.HTTPTLSMinVersion))
+					// The certificate is already in srv.TLSConfig
+					err = srv.ListenAndServeTLS("", "")
+				} else {
+					logger.Info("starting http server", zap.Int("port", cfg.ServicePort))
+					err = srv.ListenAndServe()
+				}
+				if err != nil && err != http.ErrServerClosed {
 					logger.Error("http server error", zap.Error(err))
 				}
 			}()
@@ -139,7 +427,39 @@ func startServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, publis
 		},
 		OnStop: func(ctx context.Context) error {
 			logger.Info("shutting down service...")
-			_ = srv.Shutdown(ctx)
+
+			// Ingest requests that still slip in get a clear 503 rather than
+			// racing the publisher shutdown
+			middleware.BeginDrain()
+			report := newShutdownReport(publisher)
+
+			// Stop accepting connections and let in-flight handlers finish
+			// publishing before the publisher goes away, so they do not fail
+			// with a spurious 503 on a closed channel
+			drainCtx, cancel := context.WithTimeout(ctx, time.Duration(cfg.ServerDrainTimeout)*time.Second)
+			defer cancel()
+			if err := srv.Shutdown(drainCtx); err != nil {
+				logger.Warn("http handlers did not drain in time", zap.Error(err))
+				report.timedOut = true
+			}
+
+			// In async mode accepted requests may still be queued, and with
+			// batching enabled the open batches are sent now
+			if err := ingestService.Close(ctx); err != nil {
+				logger.Warn("queued requests were not published in time", zap.Error(err))
+				report.timedOut = true
+			}
+
+			// Handlers that outlived the drain period may still be publishing;
+			// wait for their confirms with what is left of the stop timeout
+			if err := publisher.Drain(ctx); err != nil {
+				logger.Warn("rabbitmq publishes did not drain in time", zap.Error(err))
+				report.timedOut = true
+			}
+			// Every step above gives up when ctx is done, so the summary is
+			// reached, and counts what was lost, even when shutdown times out
+			report.emit(logger, publisher, ingestService)
+
 			if err := publisher.Close(); err != nil {
 				logger.Error("rabbitmq publisher close error", zap.Error(err))
 			}
@@ -148,3 +468,32 @@ func startServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, publis
 		},
 	})
 }
+
+// meterPriorities converts validated meter priorities to AMQP priorities
+func meterPriorities(priorities map[string]int) map[string]uint8 {
+	out := make(map[string]uint8, len(priorities))
+	for name, priority := range priorities {
+		out[name] = uint8(priority)
+	}
+	return out
+}
+
+// messageContentType returns the AMQP content type of messages in format
+func messageContentType(format string) string {
+	if format == service.MessageFormatCloudEvents {
+		return service.CloudEventsContentType
+	}
+	return "application/json"
+}
+
+// receivedAtLayout maps RECEIVED_AT_FORMAT onto a received_at layout
+func receivedAtLayout(format string) string {
+	switch format {
+	case "seconds":
+		return service.ReceivedAtSeconds
+	case "nanos":
+		return service.ReceivedAtNanos
+	default:
+		return service.ReceivedAtMillis
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/config"
+)
+
+// newPprofMux mounts the net/http/pprof handlers under /debug/pprof
+func newPprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// startPprofServer serves pprof on its own port when ENABLE_PPROF=true,
+// keeping it off the public listener. With ADMIN_PORT set it is served by
+// the admin listener instead.
+func startPprofServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) {
+	if !cfg.EnablePprof || cfg.AdminPort != 0 {
+		return
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", cfg.PprofHost, cfg.PprofPort),
+		Handler: newPprofMux(),
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			logger.Warn("PPROF ENABLED: profiling endpoints are exposed, do not route this port publicly",
+				zap.String("addr", srv.Addr),
+			)
+			go func() {
+				if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Error("pprof server error", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return srv.Shutdown(ctx)
+		},
+	})
+}
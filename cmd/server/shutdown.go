@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/handler"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+)
+
+// Shutdown metrics, set once by the stop hook. They are only visible to a
+// scrape or push that happens during shutdown; the summary log is the
+// durable record.
+var (
+	shutdownDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "shutdown_duration_seconds",
+		Help: "Time the last shutdown took, from the stop signal to the summary.",
+	})
+	shutdownRequests = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "shutdown_requests",
+		Help: "In-flight HTTP requests at the last shutdown, by outcome (drained, abandoned).",
+	}, []string{"outcome"})
+	shutdownPublishes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "shutdown_publishes",
+		Help: "Publishes pending at the last shutdown, by outcome (confirmed, abandoned).",
+	}, []string{"outcome"})
+)
+
+// shutdownReport tracks what the stop hook managed to flush
+type shutdownReport struct {
+	start            time.Time
+	requestsAtStart  int64
+	confirmedAtStart uint64
+	timedOut         bool
+}
+
+// newShutdownReport snapshots the in-flight work as shutdown begins
+func newShutdownReport(publisher *mq.Publisher) *shutdownReport {
+	return &shutdownReport{
+		start:            time.Now(),
+		requestsAtStart:  middleware.InFlightRequests(),
+		confirmedAtStart: publisher.Confirmed(),
+	}
+}
+
+// emit logs and records the summary. Anything still in flight or queued is
+// counted as abandoned, since the publisher is about to be closed.
+func (r *shutdownReport) emit(logger *zap.Logger, publisher *mq.Publisher, ingestService *service.IngestService) {
+	duration := time.Since(r.start)
+	requestsAbandoned := middleware.InFlightRequests()
+	requestsDrained := max(r.requestsAtStart-requestsAbandoned, 0)
+	publishesConfirmed := publisher.Confirmed() - r.confirmedAtStart
+	publishesAbandoned := publisher.InFlight() + ingestService.Pending()
+
+	shutdownDuration.Set(duration.Seconds())
+	shutdownRequests.WithLabelValues("drained").Set(float64(requestsDrained))
+	shutdownRequests.WithLabelValues("abandoned").Set(float64(requestsAbandoned))
+	shutdownPublishes.WithLabelValues("confirmed").Set(float64(publishesConfirmed))
+	shutdownPublishes.WithLabelValues("abandoned").Set(float64(publishesAbandoned))
+
+	fields := []zap.Field{
+		zap.Int64("requests_drained", requestsDrained),
+		zap.Int64("requests_abandoned", requestsAbandoned),
+		zap.Uint64("publishes_confirmed", publishesConfirmed),
+		zap.Int("publishes_abandoned", publishesAbandoned),
+		zap.Duration("duration", duration),
+		zap.Bool("timed_out", r.timedOut),
+	}
+	if r.timedOut || requestsAbandoned > 0 || publishesAbandoned > 0 {
+		logger.Warn("shutdown summary: work was abandoned", fields...)
+		return
+	}
+	logger.Info("shutdown summary: clean", fields...)
+}
+
+// awaitDeregistration runs between the stop signal and the drain: /ready
+// reports not-ready while the server keeps serving for delay, so the load
+// balancer (e.g. Kubernetes removing the pod from its endpoints) stops
+// routing here before connections are refused. A second signal ends the
+// wait early.
+func awaitDeregistration(logger *zap.Logger, health *handler.HealthHandler, sig os.Signal, delay time.Duration, quit <-chan os.Signal) {
+	health.SetShuttingDown()
+	if delay <= 0 {
+		logger.Info("shutdown signal received", zap.Stringer("signal", sig))
+		return
+	}
+	logger.Info("shutdown signal received, reporting not ready before draining",
+		zap.Stringer("signal", sig), zap.Duration("delay", delay))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		logger.Info("pre-shutdown delay elapsed, starting drain")
+	case sig := <-quit:
+		logger.Warn("second shutdown signal received, starting drain early", zap.Stringer("signal", sig))
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/config"
+)
+
+// serverTLSConfig loads the HTTP_TLS_CERT and HTTP_TLS_KEY pair, or returns
+// nil when TLS is not configured. The pair is checked up front, so a missing
+// file, a key that does not match the certificate or an expired certificate
+// stops startup instead of failing every handshake.
+func serverTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.HTTPTLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.HTTPTLSCert, cfg.HTTPTLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_TLS_CERT or HTTP_TLS_KEY: %w", err)
+	}
+	if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("HTTP_TLS_CERT expired at %s", cert.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if cfg.HTTPTLSMinVersion == "1.3" {
+		minVersion = tls.VersionTLS13
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+	}, nil
+}
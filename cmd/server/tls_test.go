@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/config"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 valid until
+// notAfter, returning the certificate and key paths and the parsed certificate
+func writeCert(t *testing.T, notAfter time.Time) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ingest-test"},
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServerTLSConnects(t *testing.T) {
+	certFile, keyFile, cert := writeCert(t, time.Now().Add(24*time.Hour))
+	tlsConfig, err := serverTLSConfig(&config.Config{HTTPTLSCert: certFile, HTTPTLSKey: keyFile, HTTPTLSMinVersion: "1.3"})
+	if err != nil {
+		t.Fatalf("serverTLSConfig: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := func(maxVersion uint16) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, MaxVersion: maxVersion},
+		}}
+	}
+
+	resp, err := client(0).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET over TLS: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 || string(body) != "ok" {
+		t.Errorf("response %q over %+v, want ok over TLS 1.3", body, resp.TLS)
+	}
+
+	// HTTP_TLS_MIN_VERSION=1.3 refuses TLS 1.2 clients
+	if _, err := client(tls.VersionTLS12).Get(srv.URL); err == nil {
+		t.Error("a TLS 1.2 client connected despite the 1.3 minimum")
+	}
+}
+
+func TestServerTLSConfigFailsFast(t *testing.T) {
+	certFile, keyFile, _ := writeCert(t, time.Now().Add(24*time.Hour))
+	otherCert, otherKey, _ := writeCert(t, time.Now().Add(24*time.Hour))
+	expiredCert, expiredKey, _ := writeCert(t, time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name      string
+		cert, key string
+		wantErr   string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "absent.pem"), keyFile, "invalid HTTP_TLS_CERT or HTTP_TLS_KEY"},
+		{"mismatched key", certFile, otherKey, "invalid HTTP_TLS_CERT or HTTP_TLS_KEY"},
+		{"key as certificate", otherKey, otherCert, "invalid HTTP_TLS_CERT or HTTP_TLS_KEY"},
+		{"expired", expiredCert, expiredKey, "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := serverTLSConfig(&config.Config{HTTPTLSCert: tt.cert, HTTPTLSKey: tt.key})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("serverTLSConfig = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerTLSDisabled(t *testing.T) {
+	tlsConfig, err := serverTLSConfig(&config.Config{})
+	if tlsConfig != nil || err != nil {
+		t.Errorf("serverTLSConfig without a certificate = %v, %v, want plain HTTP", tlsConfig, err)
+	}
+}
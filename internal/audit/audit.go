@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Stdout selects standard output as the audit sink instead of a file
+const Stdout = "stdout"
+
+var eventsDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "audit_events_dropped_total",
+	Help: "Audit events dropped because the audit buffer was full or the sink failed.",
+})
+
+// Event is one accepted ingest request
+type Event struct {
+	Type              string    `json:"type"` // always "audit", to tell audit lines apart on shared stdout
+	RequestID         string    `json:"request_id"`
+	ClientFingerprint string    `json:"client_fingerprint"`
+	TenantID          string    `json:"tenant_id,omitempty"`
+	MeterNames        []string  `json:"meter_names"`
+	ReadingsCount     int       `json:"readings_count"`
+	ReceivedAt        time.Time `json:"received_at"`
+}
+
+// Logger writes audit events as JSON lines to a dedicated sink. Record never
+// blocks: events are queued and written by a background goroutine, and are
+// dropped (and counted) when the queue is full.
+type Logger struct {
+	out    io.Writer
+	file   *os.File
+	events chan Event
+	logger *zap.Logger
+	wg     sync.WaitGroup
+}
+
+// NewLogger opens the audit sink at path, or uses stdout when path is Stdout.
+// Files are opened in append-only mode.
+func NewLogger(path string, bufferSize int, logger *zap.Logger) (*Logger, error) {
+	l := &Logger{
+		out:    os.Stdout,
+		events: make(chan Event, bufferSize),
+		logger: logger,
+	}
+
+	if path != Stdout {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		l.out, l.file = f, f
+	}
+
+	l.wg.Add(1)
+	go l.run()
+
+	return l, nil
+}
+
+// Record queues an event, dropping it if the queue is full
+func (l *Logger) Record(event Event) {
+	event.Type = "audit"
+	select {
+	case l.events <- event:
+	default:
+		eventsDropped.Inc()
+	}
+}
+
+// run writes queued events until the queue is closed
+func (l *Logger) run() {
+	defer l.wg.Done()
+
+	encoder := json.NewEncoder(l.out)
+	for event := range l.events {
+		if err := encoder.Encode(event); err != nil {
+			eventsDropped.Inc()
+			l.logger.Error("Failed to write audit event",
+				zap.String("request_id", event.RequestID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// Close writes the remaining queued events and closes the sink. Record must
+// not be called afterwards.
+func (l *Logger) Close() error {
+	close(l.events)
+	l.wg.Wait()
+
+	if l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return l.file.Close()
+}
@@ -2,72 +2,1024 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/textproto"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// pathSegmentPattern matches a single URL path segment made of unreserved characters
+var pathSegmentPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]+$`)
+
+// PublishTarget is an additional exchange each message is published to
+type PublishTarget struct {
+	Exchange   string
+	RoutingKey string // empty reuses the message's routing key
+}
+
 // Config holds all application configuration
 type Config struct {
 	ServiceName            string
+	InstanceID             string // identifies this instance, defaults to the hostname
+	DeployEnv              string // substituted for {env} in exchange and queue names
+	DeployRegion           string // substituted for {region} in exchange and queue names
 	ServicePort            int
+	ListenUnixSocket       string   // also serve on this Unix socket path when set
+	DisableTCPListener     bool     // serve only on ListenUnixSocket
+	HTTPTLSCert            string   // PEM certificate file; with HTTPTLSKey the TCP listener serves HTTPS
+	HTTPTLSKey             string   // PEM private key file
+	HTTPTLSMinVersion      string   // "1.2" or "1.3"
+	APIBasePath            string   // route prefix for the API, e.g. "/api/v1"
+	HealthPath             string   // extra health route besides the bare /health probe
+	TrustedProxies         []string // IPs/CIDRs whose X-Forwarded-For and X-Real-IP headers are honored
+	ClientIPStrategy       string   // rightmost-trusted, rightmost or leftmost X-Forwarded-For entry
+	RequestIDFormat        string   // "uuid" or "ulid"; client-supplied X-Request-ID values must match it
+	ProbeLogEvery          int      // log one in N successful health/readiness requests, 0 skips them
+	ReadinessTimeoutMs     int      // bounds the broker round-trip of the readiness probe
+	ReadinessCacheMs       int      // reuses the last readiness result for this long
 	RabbitMQURL            string
 	RabbitMQExchange       string
 	RabbitMQRoutingKey     string
 	RabbitMQMaxRetries     int
 	RabbitMQRetryBaseDelay int // in milliseconds
-	ServerStartTimeout     int // in seconds
-	ServerStopTimeout      int // in seconds
-	PublishConfirmTimeout  int // in seconds
+	RabbitMQMaxBackoff     int // in milliseconds, 0 disables the cap
+	RabbitMQHeartbeat      int // in seconds
+	RabbitMQDialTimeout    int // in seconds
+	RabbitMQVhost          string
+	// MQMaxConnLifetime replaces the broker connections once they are this
+	// old; 0 keeps them until they fail
+	MQMaxConnLifetime      time.Duration
+	MQKeepaliveInterval    int    // in seconds, 0 disables the background keepalive
+	MQChannelIdleTimeout   int    // in seconds an unused pooled channel is kept open, 0 keeps them
+	MQLockWatchdog         int    // in seconds the publisher lock may be held before /health fails, 0 disables
+	MQWatchdogReconnect    bool   // force-close the connections when the lock watchdog fires
+	ServerStartTimeout     int    // in seconds
+	ServerStopTimeout      int    // in seconds
+	ServerDrainTimeout     int    // in seconds, how long in-flight HTTP requests may take during shutdown
+	PreShutdownDelay       int    // in seconds /ready reports not-ready while still serving before the drain starts
+	RejectWhileDraining    bool   // answer ingest requests arriving during the drain with 503 SHUTTING_DOWN
+	StartupConnectMaxWait  int    // in seconds, how long the first broker connection is retried before exiting
+	PublishConfirmTimeout  int    // in seconds
+	ConfirmTimeoutPerMsg   int    // in milliseconds added per message after the first awaiting confirmation
+	ConfirmTimeoutMax      int    // in seconds, caps the extended confirm timeout
+	MQPublishConfirms      bool   // false trades durability for throughput: messages the broker loses are dropped silently
+	MQFailFastWhenBlocked  bool   // reject publishes while the broker blocks the connection
+	MQBatchAtomic          bool   // publish all copies of a message in one AMQP transaction
+	MQMandatory            bool   // fail publishes the broker cannot route to any queue
+	MQConfirmMode          string // sync or batch: when shard messages and spool replay wait for confirms
+	MQMessageFormat        string // native or cloudevents
+	PublishDeadline        int    // in seconds, caps publish including retries; 0 disables
+	PublishBatchWindow     int    // in milliseconds messages are collected into one batch message; 0 disables
+	PublishBatchMax        int    // messages per batch message before it is sent early
+	PublishFailureStatus   int    // HTTP status for broker failures: 429, 500, 502 or 503
+	PublishRetryAfter      int    // in seconds, sent as Retry-After on publish failures
+	RequestTimeout         int    // in seconds, caps handling of any API request; 0 disables
+	BodyReadTimeout        int    // in seconds, caps receiving an API request body; 0 disables
+	IngestMode             string // sync publishes before responding, async queues for background workers
+	AsyncWorkers           int
+	AsyncQueueSize         int
+	PublisherPoolSize      int
+	PublisherConnections   int
+	MaxConcurrentIngests   int    // 0 disables the limit
+	BackpressureMode       string // "reject" or "wait": what an ingest request does when the limit is reached
+	BackpressureWait       int    // in milliseconds, how long a request waits for a slot in wait mode
+	MaxRequestBodyBytes    int64  // 0 disables the limit
+	MQDeliveryMode         string // "persistent" survives broker restarts; "transient" skips broker disk I/O but is lost on restart
+	MQMessageTTL           int    // in milliseconds; unconsumed readings expire when consumers fall behind, 0 disables
+	MQCompressPayload      bool
+	MQCompressionLevel     int // gzip level, 1 (fastest) to 9 (smallest)
+	MQDeclareExchange      bool
+	MQExchangeType         string
+	MQDeclareQueue         bool // also declares the exchange
+	MQQueueName            string
+	MQQueueDurable         bool
+	MQQueueBindingKey      string
+	SelfTestRoutingKey     string
+	MQShardCount           int            // above 1 splits requests across ".shard.<n>" routing keys
+	MQMaxPriority          int            // 0-255; non-zero declares the queue with x-max-priority
+	MeterPriorities        map[string]int // meter name to publish priority
+	MQAlternateExchange    string         // declared with the exchange; catches unroutable messages
+	MQAlternateQueue       string
+	MQAdditionalTargets    []PublishTarget
+	MQFallbackExchange     string // last-resort exchange when the primary publish fails
+	MQFallbackRoutingKey   string // empty reuses the message's routing key
+	MQDeadLetterExchange   string // receives async messages whose publish failed; empty drops them
+	MQDeadLetterKey        string // empty reuses the message's routing key
+	LowercaseMeterNames    bool
+	MaxNameLen             int            // in bytes, 0 disables the limit
+	MaxDateLen             int            // in bytes, 0 disables the limit
+	MaxDataLen             int            // in bytes, 0 disables the limit
+	AllowedMeterNames      []string       // empty allows every name
+	MeterNamePattern       *regexp.Regexp // must fully match every name; nil allows every name
+	ReadingDateLayout      string         // Go time layout of MeterReading.Date
+	ReceivedAtFormat       string         // seconds, millis or nanos precision of received_at
+	FingerprintInputs      []string       // attributes mixed into the fingerprint besides IP and User-Agent
+	MaxUserAgentBytes      int            // longer User-Agent headers are truncated; 0 disables the limit
+	ValidationWarnRules    []string       // validation rules that warn instead of rejecting
+	PartialAccept          bool           // publish the valid readings of a request and report the invalid ones
+	RequireOrderedReadings bool
+	MaxReadingAge          time.Duration // readings dated earlier are rejected; 0 disables
+	DedupeWithinRequest    bool          // drop readings identical in name, date and data
+	UniqueMeterNames       bool          // a meter name may appear once per request, see DuplicateNamePolicy
+	DuplicateNamePolicy    string        // "reject" or "keep_last" readings repeating a name
+	DedupeWindow           int           // in seconds a published name and date are skipped in later requests; 0 disables
+	DedupeCacheSize        int           // maximum readings remembered for DedupeWindow
+	StatusTTL              int           // in seconds, how long publish outcomes can be looked up; 0 disables
+	PublishSampleRate      float64       // fraction of valid requests published, the rest are dropped; below 1 only for load testing
+	StatusMaxEntries       int
+	MeterCountsWindow      int // in seconds, window of the /admin/meters counts; 0 disables
+	MeterCountsMaxNames    int
+	AuditLogPath           string // file path, or "stdout"; empty disables audit logging
+	AuditBufferSize        int
+	MultiTenancyEnabled    bool
+	AllowedTenants         []string
+	TenantRoutingKey       string            // template, "{tenant}" is replaced with the tenant ID
+	SignatureKeys          map[string]string // key ID -> secret; empty disables signature verification
+	SignatureMaxSkew       int               // in seconds
+	NonceTTL               int               // in seconds a nonce is remembered, 0 disables replay protection
+	NonceCacheSize         int               // maximum nonces remembered; the oldest are evicted first
+	ResponseHeaders        map[string]string // canonical header name -> value set on every response; "" removes the header
+	CORSAllowedOrigins     []string          // empty disables CORS
+	CORSAllowedMethods     []string
+	CORSAllowedHeaders     []string
+	CORSAllowCredentials   bool
+	CORSMaxAge             int    // in seconds
+	AdminToken             string // bearer token for /admin routes; empty disables them
+	EnableAPIDocs          bool   // serve Swagger UI at /docs
+	EnableEcho             bool   // serve the readings echo endpoint; requires SIGNATURE_KEYS
+	ResponseFormat         string // "envelope" or "legacy" (pre-envelope response shapes)
 	GinMode                string
+	EnablePprof            bool
+	PprofHost              string
+	PprofPort              int
+	AdminHost              string // bind address of the admin listener
+	AdminPort              int    // serves /metrics, /admin and pprof on a separate listener; 0 keeps them on ServicePort
+	SpoolDir               string // empty disables the on-disk publish spool
+	SpoolMaxBytes          int    // 0 means unlimited
+	SpoolReplayBatchSize   int
+	SpoolReplayIntervalMs  int
+	StrictConfig           bool     // unparseable values fail Load instead of falling back to defaults
+	Warnings               []string // unparseable values that fell back to their defaults
 }
 
-// Load loads configuration from environment variables
+// Load loads configuration from environment variables. Numbers, booleans and
+// durations that cannot be parsed fall back to their defaults and are listed
+// in Warnings, or fail Load when CONFIG_STRICT is set; when Load fails for
+// another reason they are appended to the error, since a value that silently
+// became its default is a likely cause.
 func Load() (*Config, error) {
-	serviceName := getEnv("SERVICE_NAME", "energy-metering-ingest-api")
-	servicePort := getEnvAsInt("SERVICE_PORT", 8080)
-	rabbitMQURL := getEnv("RABBITMQ_URL", "")
-	rabbitMQExchange := getEnv("RABBITMQ_EXCHANGE", "energy-metering.ingest.exchange")
-	rabbitMQRoutingKey := getEnv("RABBITMQ_ROUTING_KEY", "meter.reading.ingested")
-	rabbitMQMaxRetries := getEnvAsInt("RABBITMQ_MAX_RETRIES", 3)
-	rabbitMQRetryBaseDelay := getEnvAsInt("RABBITMQ_RETRY_BASE_DELAY_MS", 100)
-	serverStartTimeout := getEnvAsInt("SERVER_START_TIMEOUT_SEC", 15)
-	serverStopTimeout := getEnvAsInt("SERVER_STOP_TIMEOUT_SEC", 15)
-	publishConfirmTimeout := getEnvAsInt("PUBLISH_CONFIRM_TIMEOUT_SEC", 5)
-	ginMode := getEnv("GIN_MODE", "debug")
+	l := &loader{known: make(map[string]struct{})}
+	cfg, err := l.load()
+	if len(l.invalid) == 0 {
+		return cfg, err
+	}
+	problems := strings.Join(l.invalid, "; ")
+	if err != nil {
+		return nil, fmt.Errorf("%w (unparseable values also ignored: %s)", err, problems)
+	}
+	if cfg.StrictConfig {
+		return nil, fmt.Errorf("CONFIG_STRICT: unparseable values: %s", problems)
+	}
+	cfg.Warnings = l.invalid
+	return cfg, nil
+}
+
+func (l *loader) load() (*Config, error) {
+	// A profile supplies defaults that the environment overrides
+	if name := os.Getenv("CONFIG_PROFILE"); name != "" {
+		values, err := loadProfile(os.Getenv("CONFIG_DIR"), name)
+		if err != nil {
+			return nil, err
+		}
+		l.profile = values
+	}
+
+	strictConfig := l.getEnvAsBool("CONFIG_STRICT", false)
+	serviceName := l.getEnv("SERVICE_NAME", "energy-metering-ingest-api")
+	deployEnv := l.getEnv("DEPLOY_ENV", "")
+	deployRegion := l.getEnv("DEPLOY_REGION", "")
+	servicePort := l.getEnvAsInt("SERVICE_PORT", 8080)
+	listenUnixSocket := l.getEnv("LISTEN_UNIX_SOCKET", "")
+	disableTCPListener := l.getEnvAsBool("DISABLE_TCP_LISTENER", false)
+	httpTLSCert := l.getEnv("HTTP_TLS_CERT", "")
+	httpTLSKey := l.getEnv("HTTP_TLS_KEY", "")
+	httpTLSMinVersion := l.getEnv("HTTP_TLS_MIN_VERSION", "1.2")
+	hostname, _ := os.Hostname()
+	instanceID := l.getEnv("INSTANCE_ID", hostname)
+	apiBasePath := l.getEnv("API_BASE_PATH", "/api/v1")
+	healthPath := l.getEnv("HEALTH_PATH", "/health")
+	trustedProxies := l.getEnvAsList("TRUSTED_PROXIES")
+	clientIPStrategy := l.getEnv("CLIENT_IP_STRATEGY", "rightmost-trusted")
+	if clientIPStrategy != "rightmost-trusted" && clientIPStrategy != "rightmost" && clientIPStrategy != "leftmost" {
+		return nil, fmt.Errorf("CLIENT_IP_STRATEGY must be rightmost-trusted, rightmost or leftmost")
+	}
+	requestIDFormat := l.getEnv("REQUEST_ID_FORMAT", "uuid")
+	if requestIDFormat != "uuid" && requestIDFormat != "ulid" {
+		return nil, fmt.Errorf("REQUEST_ID_FORMAT must be uuid or ulid")
+	}
+	probeLogEvery := l.getEnvAsInt("PROBE_LOG_EVERY", 1)
+	readinessTimeoutMs := l.getEnvAsInt("READINESS_TIMEOUT_MS", 2000)
+	readinessCacheMs := l.getEnvAsInt("READINESS_CACHE_MS", 1000)
+	rabbitMQURL, err := l.getSecret("RABBITMQ_URL")
+	if err != nil {
+		return nil, err
+	}
+	rabbitMQExchange := l.getEnv("RABBITMQ_EXCHANGE", "energy-metering.ingest.exchange")
+	rabbitMQRoutingKey := l.getEnv("RABBITMQ_ROUTING_KEY", "meter.reading.ingested")
+	rabbitMQMaxRetries := l.getEnvAsInt("RABBITMQ_MAX_RETRIES", 3)
+	rabbitMQRetryBaseDelay := l.getEnvAsInt("RABBITMQ_RETRY_BASE_DELAY_MS", 100)
+	rabbitMQMaxBackoff := l.getEnvAsInt("RABBITMQ_MAX_BACKOFF_MS", 5000)
+	rabbitMQHeartbeat := l.getEnvAsInt("RABBITMQ_HEARTBEAT_SEC", 10)
+	rabbitMQDialTimeout := l.getEnvAsInt("RABBITMQ_DIAL_TIMEOUT_SEC", 5)
+	rabbitMQVhost := l.getEnv("RABBITMQ_VHOST", "")
+	mqKeepaliveInterval := l.getEnvAsInt("MQ_KEEPALIVE_INTERVAL_SEC", 30)
+	mqChannelIdleTimeout := l.getEnvAsInt("MQ_CHANNEL_IDLE_TIMEOUT_SEC", 0)
+	mqMaxConnLifetime := l.getEnvAsDuration("MQ_MAX_CONNECTION_LIFETIME", 0)
+	mqLockWatchdog := l.getEnvAsInt("MQ_LOCK_WATCHDOG_SEC", 60)
+	mqWatchdogReconnect := l.getEnvAsBool("MQ_LOCK_WATCHDOG_RECONNECT", false)
+	serverStartTimeout := l.getEnvAsInt("SERVER_START_TIMEOUT_SEC", 15)
+	serverStopTimeout := l.getEnvAsInt("SERVER_STOP_TIMEOUT_SEC", 15)
+	serverDrainTimeout := l.getEnvAsInt("SERVER_DRAIN_TIMEOUT_SEC", 10)
+	startupConnectMaxWait := l.getEnvAsInt("STARTUP_CONNECT_MAX_WAIT_SEC", 30)
+	if startupConnectMaxWait <= 0 {
+		return nil, fmt.Errorf("STARTUP_CONNECT_MAX_WAIT_SEC must be positive")
+	}
+	if serverDrainTimeout < 0 {
+		return nil, fmt.Errorf("SERVER_DRAIN_TIMEOUT_SEC must not be negative")
+	}
+	preShutdownDelay := l.getEnvAsInt("PRE_SHUTDOWN_DELAY_SEC", 0)
+	rejectWhileDraining := l.getEnvAsBool("REJECT_WHILE_DRAINING", true)
+	if preShutdownDelay < 0 {
+		return nil, fmt.Errorf("PRE_SHUTDOWN_DELAY_SEC must not be negative")
+	}
+	publishConfirmTimeout := l.getEnvAsInt("PUBLISH_CONFIRM_TIMEOUT_SEC", 5)
+	confirmTimeoutPerMsg := l.getEnvAsInt("PUBLISH_CONFIRM_TIMEOUT_PER_MESSAGE_MS", 0)
+	if confirmTimeoutPerMsg < 0 {
+		return nil, fmt.Errorf("PUBLISH_CONFIRM_TIMEOUT_PER_MESSAGE_MS must not be negative")
+	}
+	confirmTimeoutMax := l.getEnvAsInt("PUBLISH_CONFIRM_TIMEOUT_MAX_SEC", 60)
+	if confirmTimeoutMax < publishConfirmTimeout {
+		return nil, fmt.Errorf("PUBLISH_CONFIRM_TIMEOUT_MAX_SEC must be at least PUBLISH_CONFIRM_TIMEOUT_SEC")
+	}
+	mqPublishConfirms := l.getEnvAsBool("MQ_PUBLISH_CONFIRMS", true)
+	mqFailFastWhenBlocked := l.getEnvAsBool("MQ_FAIL_FAST_WHEN_BLOCKED", false)
+	mqBatchAtomic := l.getEnvAsBool("MQ_BATCH_ATOMIC", false)
+	mqMandatory := l.getEnvAsBool("MQ_MANDATORY", false)
+	mqConfirmMode := l.getEnv("MQ_CONFIRM_MODE", "sync")
+	if mqConfirmMode != "sync" && mqConfirmMode != "batch" {
+		return nil, fmt.Errorf("MQ_CONFIRM_MODE must be sync or batch")
+	}
+	mqMessageFormat := l.getEnv("MQ_MESSAGE_FORMAT", "native")
+	if mqMessageFormat != "native" && mqMessageFormat != "cloudevents" {
+		return nil, fmt.Errorf("MQ_MESSAGE_FORMAT must be native or cloudevents")
+	}
+	if mqBatchAtomic && !mqPublishConfirms {
+		return nil, fmt.Errorf("MQ_BATCH_ATOMIC cannot be combined with MQ_PUBLISH_CONFIRMS=false")
+	}
+	// Returns are only told apart from successes by waiting for the confirm
+	if mqMandatory && (!mqPublishConfirms || mqBatchAtomic) {
+		return nil, fmt.Errorf("MQ_MANDATORY requires publisher confirms, so it cannot be combined with MQ_PUBLISH_CONFIRMS=false or MQ_BATCH_ATOMIC")
+	}
+	publishDeadline := l.getEnvAsInt("PUBLISH_DEADLINE_SEC", 10)
+	publishBatchWindow := l.getEnvAsInt("PUBLISH_BATCH_WINDOW_MS", 0)
+	publishBatchMax := l.getEnvAsInt("PUBLISH_BATCH_MAX_MESSAGES", 100)
+	if publishBatchWindow < 0 {
+		return nil, fmt.Errorf("PUBLISH_BATCH_WINDOW_MS must not be negative")
+	}
+	if publishBatchWindow > 0 && publishBatchMax <= 0 {
+		return nil, fmt.Errorf("PUBLISH_BATCH_MAX_MESSAGES must be positive")
+	}
+	if publishBatchWindow > 0 && mqMessageFormat != "native" {
+		return nil, fmt.Errorf("PUBLISH_BATCH_WINDOW_MS requires MQ_MESSAGE_FORMAT=native")
+	}
+	publishFailureStatus := l.getEnvAsInt("PUBLISH_FAILURE_STATUS", 503)
+	if publishFailureStatus != 429 && publishFailureStatus != 500 && publishFailureStatus != 502 && publishFailureStatus != 503 {
+		return nil, fmt.Errorf("PUBLISH_FAILURE_STATUS must be 429, 500, 502 or 503")
+	}
+	publishRetryAfter := l.getEnvAsInt("PUBLISH_RETRY_AFTER_SEC", 5)
+	if publishRetryAfter <= 0 {
+		return nil, fmt.Errorf("PUBLISH_RETRY_AFTER_SEC must be positive")
+	}
+	requestTimeout := l.getEnvAsInt("REQUEST_TIMEOUT_SEC", 30)
+	bodyReadTimeout := l.getEnvAsInt("BODY_READ_TIMEOUT_SEC", 10)
+	ingestMode := l.getEnv("INGEST_MODE", "sync")
+	if ingestMode != "sync" && ingestMode != "async" {
+		return nil, fmt.Errorf("INGEST_MODE must be sync or async")
+	}
+	asyncWorkers := l.getEnvAsInt("ASYNC_WORKERS", 4)
+	asyncQueueSize := l.getEnvAsInt("ASYNC_QUEUE_SIZE", 1000)
+	if ingestMode == "async" && (asyncWorkers <= 0 || asyncQueueSize <= 0) {
+		return nil, fmt.Errorf("ASYNC_WORKERS and ASYNC_QUEUE_SIZE must be positive")
+	}
+	publishSampleRate := l.getEnvAsFloat("PUBLISH_SAMPLE_RATE", 1)
+	if !(publishSampleRate >= 0 && publishSampleRate <= 1) { // also rejects NaN
+		return nil, fmt.Errorf("PUBLISH_SAMPLE_RATE must be between 0 and 1")
+	}
+	publisherPoolSize := l.getEnvAsInt("MQ_PUBLISHER_POOL_SIZE", 1)
+	publisherConnections := l.getEnvAsInt("MQ_PUBLISHER_CONNECTIONS", 1)
+	maxConcurrentIngests := l.getEnvAsInt("MAX_CONCURRENT_INGESTS", 1000)
+	backpressureMode := l.getEnv("BACKPRESSURE_MODE", "reject")
+	backpressureWait := l.getEnvAsInt("BACKPRESSURE_WAIT_MS", 100)
+	maxRequestBodyBytes := l.getEnvAsInt("MAX_REQUEST_BODY_BYTES", 1024*1024)
+	mqDeliveryMode := strings.ToLower(l.getEnv("MQ_DELIVERY_MODE", "persistent"))
+	mqMessageTTL := l.getEnvAsInt("MQ_MESSAGE_TTL_MS", 0)
+	mqCompressPayload := l.getEnvAsBool("MQ_COMPRESS_PAYLOAD", false)
+	mqCompressionLevel := l.getEnvAsInt("MQ_COMPRESSION_LEVEL", 6)
+	mqDeclareExchange := l.getEnvAsBool("MQ_DECLARE_EXCHANGE", false)
+	mqExchangeType := l.getEnv("MQ_EXCHANGE_TYPE", "topic")
+	mqDeclareQueue := l.getEnvAsBool("MQ_DECLARE_QUEUE", false)
+	mqQueueName := l.getEnv("MQ_QUEUE_NAME", "energy-metering.ingest.queue")
+	mqQueueDurable := l.getEnvAsBool("MQ_QUEUE_DURABLE", true)
+	mqQueueBindingKey := l.getEnv("MQ_QUEUE_BINDING_KEY", rabbitMQRoutingKey)
+	selfTestRoutingKey := l.getEnv("SELFTEST_ROUTING_KEY", "meter.reading.selftest")
+	mqShardCount := l.getEnvAsInt("MQ_SHARD_COUNT", 0)
+	if mqShardCount < 0 {
+		return nil, fmt.Errorf("MQ_SHARD_COUNT must not be negative")
+	}
+	// Sharded messages go to "<routing key>.shard.<n>", which the default
+	// binding key no longer matches: the declared queue would stay empty
+	if mqDeclareQueue && mqShardCount > 1 && mqQueueBindingKey == rabbitMQRoutingKey {
+		return nil, fmt.Errorf("MQ_QUEUE_BINDING_KEY must match the shard routing keys when MQ_SHARD_COUNT is above 1, e.g. %s.shard.*", rabbitMQRoutingKey)
+	}
+	mqMaxPriority := l.getEnvAsInt("MQ_MAX_PRIORITY", 0)
+	if mqMaxPriority < 0 || mqMaxPriority > 255 {
+		return nil, fmt.Errorf("MQ_MAX_PRIORITY must be between 0 and 255")
+	}
+	meterPriorityEntries, err := l.getEnvAsMap("METER_PRIORITIES")
+	if err != nil {
+		return nil, err
+	}
+	meterPriorities := make(map[string]int, len(meterPriorityEntries))
+	for name, value := range meterPriorityEntries {
+		priority, err := strconv.Atoi(value)
+		if err != nil || priority < 0 || priority > mqMaxPriority {
+			return nil, fmt.Errorf("METER_PRIORITIES priority of %q must be between 0 and MQ_MAX_PRIORITY", name)
+		}
+		meterPriorities[name] = priority
+	}
+	lowercaseMeterNames := l.getEnvAsBool("LOWERCASE_METER_NAMES", false)
+	allowedMeterNames := l.getEnvAsList("ALLOWED_METER_NAMES")
+	var meterNamePattern *regexp.Regexp
+	if pattern := l.getEnv("METER_NAME_PATTERN", ""); pattern != "" {
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("METER_NAME_PATTERN is not a valid regular expression: %w", err)
+		}
+		meterNamePattern = compiled
+	}
+	mqAlternateExchange := l.getEnv("MQ_ALTERNATE_EXCHANGE", "")
+	mqAlternateQueue := l.getEnv("MQ_ALTERNATE_QUEUE", "")
+	mqAdditionalTargets, err := l.getEnvAsTargets("MQ_ADDITIONAL_TARGETS")
+	if err != nil {
+		return nil, err
+	}
+	mqFallbackExchange := l.getEnv("MQ_FALLBACK_EXCHANGE", "")
+	mqFallbackRoutingKey := l.getEnv("MQ_FALLBACK_ROUTING_KEY", "")
+	mqDeadLetterExchange := l.getEnv("MQ_DEAD_LETTER_EXCHANGE", "")
+	mqDeadLetterKey := l.getEnv("MQ_DEAD_LETTER_ROUTING_KEY", "")
+	// Exchange and queue names may embed the deployment, e.g.
+	// "energy.ingest.{env}.{region}.exchange"
+	namingVars := map[string]namingVar{
+		"{env}":     {source: "DEPLOY_ENV", value: deployEnv},
+		"{region}":  {source: "DEPLOY_REGION", value: deployRegion},
+		"{service}": {source: "SERVICE_NAME", value: serviceName},
+	}
+	for _, name := range []struct {
+		key   string
+		value *string
+	}{
+		{"RABBITMQ_EXCHANGE", &rabbitMQExchange},
+		{"MQ_QUEUE_NAME", &mqQueueName},
+		{"MQ_ALTERNATE_EXCHANGE", &mqAlternateExchange},
+		{"MQ_ALTERNATE_QUEUE", &mqAlternateQueue},
+		{"MQ_FALLBACK_EXCHANGE", &mqFallbackExchange},
+		{"MQ_DEAD_LETTER_EXCHANGE", &mqDeadLetterExchange},
+	} {
+		if *name.value, err = resolveName(name.key, *name.value, namingVars); err != nil {
+			return nil, err
+		}
+	}
+	for i := range mqAdditionalTargets {
+		if mqAdditionalTargets[i].Exchange, err = resolveName("MQ_ADDITIONAL_TARGETS", mqAdditionalTargets[i].Exchange, namingVars); err != nil {
+			return nil, err
+		}
+	}
+	if mqFallbackRoutingKey != "" && mqFallbackExchange == "" {
+		return nil, fmt.Errorf("MQ_FALLBACK_ROUTING_KEY requires MQ_FALLBACK_EXCHANGE")
+	}
+	if mqFallbackExchange != "" && mqFallbackExchange == rabbitMQExchange {
+		return nil, fmt.Errorf("MQ_FALLBACK_EXCHANGE must differ from RABBITMQ_EXCHANGE")
+	}
+	if mqDeadLetterKey != "" && mqDeadLetterExchange == "" {
+		return nil, fmt.Errorf("MQ_DEAD_LETTER_ROUTING_KEY requires MQ_DEAD_LETTER_EXCHANGE")
+	}
+	if mqDeadLetterExchange != "" && mqDeadLetterExchange == rabbitMQExchange {
+		return nil, fmt.Errorf("MQ_DEAD_LETTER_EXCHANGE must differ from RABBITMQ_EXCHANGE")
+	}
+	if mqDeadLetterExchange != "" && ingestMode != "async" {
+		return nil, fmt.Errorf("MQ_DEAD_LETTER_EXCHANGE requires INGEST_MODE=async: in sync mode the client is told the publish failed")
+	}
+	maxNameLen := l.getEnvAsInt("MAX_NAME_LEN", 128)
+	maxDateLen := l.getEnvAsInt("MAX_DATE_LEN", 64)
+	maxDataLen := l.getEnvAsInt("MAX_DATA_LEN", 16384)
+	if maxNameLen < 0 || maxDateLen < 0 || maxDataLen < 0 {
+		return nil, fmt.Errorf("MAX_NAME_LEN, MAX_DATE_LEN and MAX_DATA_LEN must not be negative")
+	}
+	readingDateLayout := l.getEnv("READING_DATE_LAYOUT", "02/01/2006 15:04:05")
+	receivedAtFormat := l.getEnv("RECEIVED_AT_FORMAT", "millis")
+	if receivedAtFormat != "seconds" && receivedAtFormat != "millis" && receivedAtFormat != "nanos" {
+		return nil, fmt.Errorf("RECEIVED_AT_FORMAT must be seconds, millis or nanos")
+	}
+	fingerprintInputs := l.getEnvAsList("FINGERPRINT_INPUTS")
+	for _, input := range fingerprintInputs {
+		switch input {
+		case "key_id", "tenant_id", "device_id", "accept_language":
+		default:
+			return nil, fmt.Errorf("FINGERPRINT_INPUTS entries must be key_id, tenant_id, device_id or accept_language, got %q", input)
+		}
+	}
+	maxUserAgentBytes := l.getEnvAsInt("MAX_USER_AGENT_BYTES", 512)
+	if maxUserAgentBytes != 0 && maxUserAgentBytes < 16 {
+		return nil, fmt.Errorf("MAX_USER_AGENT_BYTES must be 0 or at least 16")
+	}
+	validationWarnRules := l.getEnvAsList("VALIDATION_WARN_RULES")
+	for _, rule := range validationWarnRules {
+		switch rule {
+		case "unknown_meter", "invalid_name", "invalid_date", "unordered", "too_old":
+		default:
+			return nil, fmt.Errorf("VALIDATION_WARN_RULES entries must be unknown_meter, invalid_name, invalid_date, unordered or too_old, got %q", rule)
+		}
+	}
+	partialAccept := l.getEnvAsBool("PARTIAL_ACCEPT", false)
+	requireOrderedReadings := l.getEnvAsBool("REQUIRE_ORDERED_READINGS", false)
+	maxReadingAge := l.getEnvAsDuration("MAX_READING_AGE", 0)
+	if maxReadingAge < 0 {
+		return nil, fmt.Errorf("MAX_READING_AGE must not be negative")
+	}
+	dedupeWithinRequest := l.getEnvAsBool("DEDUPE_WITHIN_REQUEST", false)
+	uniqueMeterNames := l.getEnvAsBool("UNIQUE_METER_NAMES_PER_REQUEST", false)
+	duplicateNamePolicy := l.getEnv("DUPLICATE_NAME_POLICY", "reject")
+	if duplicateNamePolicy != "reject" && duplicateNamePolicy != "keep_last" {
+		return nil, fmt.Errorf("DUPLICATE_NAME_POLICY must be reject or keep_last")
+	}
+	dedupeWindow := l.getEnvAsInt("DEDUPE_WINDOW_SEC", 0)
+	dedupeCacheSize := l.getEnvAsInt("DEDUPE_CACHE_SIZE", 100000)
+	statusTTL := l.getEnvAsInt("STATUS_TTL_SEC", 300)
+	statusMaxEntries := l.getEnvAsInt("STATUS_MAX_ENTRIES", 100000)
+	meterCountsWindow := l.getEnvAsInt("METER_COUNTS_WINDOW_SEC", 900)
+	meterCountsMaxNames := l.getEnvAsInt("METER_COUNTS_MAX_NAMES", 1000)
+	if meterCountsWindow > 0 && meterCountsMaxNames <= 0 {
+		return nil, fmt.Errorf("METER_COUNTS_MAX_NAMES must be positive")
+	}
+	auditLogPath := l.getEnv("AUDIT_LOG_PATH", "")
+	auditBufferSize := l.getEnvAsInt("AUDIT_BUFFER_SIZE", 10000)
+	multiTenancyEnabled := l.getEnvAsBool("MULTI_TENANCY_ENABLED", false)
+	allowedTenants := l.getEnvAsList("ALLOWED_TENANTS")
+	tenantRoutingKey := l.getEnv("TENANT_ROUTING_KEY_TEMPLATE", "meter.reading.{tenant}")
+	signatureKeysRaw, err := l.getSecret("SIGNATURE_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	signatureKeys, err := parseMap("SIGNATURE_KEYS", signatureKeysRaw)
+	if err != nil {
+		return nil, err
+	}
+	signatureMaxSkew := l.getEnvAsInt("SIGNATURE_MAX_SKEW_SEC", 300)
+	nonceTTL := l.getEnvAsInt("NONCE_TTL_SEC", 0)
+	nonceCacheSize := l.getEnvAsInt("NONCE_CACHE_SIZE", 100000)
+	responseHeaders, err := l.getEnvAsHeaders("RESPONSE_HEADERS")
+	if err != nil {
+		return nil, err
+	}
+	corsAllowedOrigins := l.getEnvAsList("CORS_ALLOWED_ORIGINS")
+	corsAllowedMethods := l.getEnvAsListOr("CORS_ALLOWED_METHODS", []string{"POST", "OPTIONS"})
+	corsAllowedHeaders := l.getEnvAsListOr("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Authorization", "X-Tenant-ID"})
+	corsAllowCredentials := l.getEnvAsBool("CORS_ALLOW_CREDENTIALS", false)
+	corsMaxAge := l.getEnvAsInt("CORS_MAX_AGE_SEC", 600)
+	adminToken, err := l.getSecret("ADMIN_TOKEN")
+	if err != nil {
+		return nil, err
+	}
+	enableAPIDocs := l.getEnvAsBool("ENABLE_API_DOCS", false)
+	enableEcho := l.getEnvAsBool("ENABLE_ECHO", false)
+	ginMode := l.getEnv("GIN_MODE", "debug")
+	responseFormat := strings.ToLower(l.getEnv("RESPONSE_FORMAT", "envelope"))
+	enablePprof := l.getEnvAsBool("ENABLE_PPROF", false)
+	pprofHost := l.getEnv("PPROF_HOST", "127.0.0.1")
+	pprofPort := l.getEnvAsInt("PPROF_PORT", 6060)
+	adminHost := l.getEnv("ADMIN_HOST", "")
+	adminPort := l.getEnvAsInt("ADMIN_PORT", 0)
+	if adminPort < 0 || adminPort > 65535 {
+		return nil, fmt.Errorf("ADMIN_PORT must be between 0 and 65535")
+	}
+	spoolDir := l.getEnv("SPOOL_DIR", "")
+	spoolMaxBytes := l.getEnvAsInt("SPOOL_MAX_BYTES", 100*1024*1024)
+	spoolReplayBatchSize := l.getEnvAsInt("SPOOL_REPLAY_BATCH_SIZE", 100)
+	spoolReplayIntervalMs := l.getEnvAsInt("SPOOL_REPLAY_INTERVAL_MS", 5000)
 
+	if err := validateServiceName(serviceName); err != nil {
+		return nil, err
+	}
+	if err := validateRoutePath("API_BASE_PATH", apiBasePath); err != nil {
+		return nil, err
+	}
+	if err := validateRoutePath("HEALTH_PATH", healthPath); err != nil {
+		return nil, err
+	}
+	if disableTCPListener && listenUnixSocket == "" {
+		return nil, fmt.Errorf("DISABLE_TCP_LISTENER requires LISTEN_UNIX_SOCKET")
+	}
+	if (httpTLSCert == "") != (httpTLSKey == "") {
+		return nil, fmt.Errorf("HTTP_TLS_CERT and HTTP_TLS_KEY must be set together")
+	}
+	if httpTLSCert != "" && disableTCPListener {
+		return nil, fmt.Errorf("HTTP_TLS_CERT only applies to the TCP listener, which DISABLE_TCP_LISTENER turns off")
+	}
+	if httpTLSMinVersion != "1.2" && httpTLSMinVersion != "1.3" {
+		return nil, fmt.Errorf("HTTP_TLS_MIN_VERSION must be 1.2 or 1.3")
+	}
+	if probeLogEvery < 0 {
+		return nil, fmt.Errorf("PROBE_LOG_EVERY must not be negative")
+	}
+	if dedupeWindow < 0 {
+		return nil, fmt.Errorf("DEDUPE_WINDOW_SEC must not be negative")
+	}
+	if dedupeWindow > 0 && dedupeCacheSize <= 0 {
+		return nil, fmt.Errorf("DEDUPE_CACHE_SIZE must be positive when DEDUPE_WINDOW_SEC is set")
+	}
+	if statusTTL < 0 {
+		return nil, fmt.Errorf("STATUS_TTL_SEC must not be negative")
+	}
+	if statusTTL > 0 && statusMaxEntries <= 0 {
+		return nil, fmt.Errorf("STATUS_MAX_ENTRIES must be positive")
+	}
+	if readinessTimeoutMs <= 0 {
+		return nil, fmt.Errorf("READINESS_TIMEOUT_MS must be positive")
+	}
+	if readinessCacheMs < 0 {
+		return nil, fmt.Errorf("READINESS_CACHE_MS must not be negative")
+	}
+	for _, proxy := range trustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES entry %q is not an IP address or CIDR", proxy)
+		}
+	}
+	if auditLogPath != "" && auditBufferSize <= 0 {
+		return nil, fmt.Errorf("AUDIT_BUFFER_SIZE must be positive")
+	}
 	if rabbitMQURL == "" {
 		return nil, fmt.Errorf("RABBITMQ_URL is required")
 	}
+	if rabbitMQMaxRetries < 1 {
+		return nil, fmt.Errorf("RABBITMQ_MAX_RETRIES must be at least 1")
+	}
+	if rabbitMQMaxBackoff < 0 {
+		return nil, fmt.Errorf("RABBITMQ_MAX_BACKOFF_MS must not be negative")
+	}
+	if rabbitMQHeartbeat < 0 {
+		return nil, fmt.Errorf("RABBITMQ_HEARTBEAT_SEC must not be negative")
+	}
+	if rabbitMQDialTimeout <= 0 {
+		return nil, fmt.Errorf("RABBITMQ_DIAL_TIMEOUT_SEC must be positive")
+	}
+	if mqMaxConnLifetime < 0 {
+		return nil, fmt.Errorf("MQ_MAX_CONNECTION_LIFETIME must not be negative")
+	}
+	if mqKeepaliveInterval < 0 {
+		return nil, fmt.Errorf("MQ_KEEPALIVE_INTERVAL_SEC must not be negative")
+	}
+	if mqChannelIdleTimeout < 0 {
+		return nil, fmt.Errorf("MQ_CHANNEL_IDLE_TIMEOUT_SEC must not be negative")
+	}
+	if mqLockWatchdog < 0 {
+		return nil, fmt.Errorf("MQ_LOCK_WATCHDOG_SEC must not be negative")
+	}
+	if maxConcurrentIngests < 0 {
+		return nil, fmt.Errorf("MAX_CONCURRENT_INGESTS must not be negative")
+	}
+	if backpressureMode != "reject" && backpressureMode != "wait" {
+		return nil, fmt.Errorf("BACKPRESSURE_MODE must be reject or wait")
+	}
+	if backpressureMode == "wait" && backpressureWait <= 0 {
+		return nil, fmt.Errorf("BACKPRESSURE_WAIT_MS must be positive when BACKPRESSURE_MODE is wait")
+	}
+	if maxRequestBodyBytes < 0 {
+		return nil, fmt.Errorf("MAX_REQUEST_BODY_BYTES must not be negative")
+	}
+	if mqDeliveryMode != "persistent" && mqDeliveryMode != "transient" {
+		return nil, fmt.Errorf("MQ_DELIVERY_MODE must be persistent or transient")
+	}
+	if responseFormat != "envelope" && responseFormat != "legacy" {
+		return nil, fmt.Errorf("RESPONSE_FORMAT must be envelope or legacy")
+	}
+	if mqMessageTTL < 0 {
+		return nil, fmt.Errorf("MQ_MESSAGE_TTL_MS must not be negative")
+	}
+	if mqCompressPayload && (mqCompressionLevel < 1 || mqCompressionLevel > 9) {
+		return nil, fmt.Errorf("MQ_COMPRESSION_LEVEL must be between 1 and 9")
+	}
+	if mqAlternateQueue != "" && mqAlternateExchange == "" {
+		return nil, fmt.Errorf("MQ_ALTERNATE_QUEUE requires MQ_ALTERNATE_EXCHANGE")
+	}
+	if multiTenancyEnabled {
+		if len(allowedTenants) == 0 {
+			return nil, fmt.Errorf("ALLOWED_TENANTS is required when MULTI_TENANCY_ENABLED is true")
+		}
+		for _, tenant := range allowedTenants {
+			if !pathSegmentPattern.MatchString(tenant) || strings.Contains(tenant, ".") {
+				return nil, fmt.Errorf("ALLOWED_TENANTS entry %q may only contain letters, digits, '_', '~' and '-'", tenant)
+			}
+		}
+		if !strings.Contains(tenantRoutingKey, "{tenant}") {
+			return nil, fmt.Errorf("TENANT_ROUTING_KEY_TEMPLATE must contain {tenant}")
+		}
+	}
+	if enableEcho && len(signatureKeys) == 0 {
+		return nil, fmt.Errorf("ENABLE_ECHO requires SIGNATURE_KEYS so the endpoint is authenticated")
+	}
+	if len(signatureKeys) > 0 && signatureMaxSkew <= 0 {
+		return nil, fmt.Errorf("SIGNATURE_MAX_SKEW_SEC must be positive")
+	}
+	if nonceTTL < 0 {
+		return nil, fmt.Errorf("NONCE_TTL_SEC must not be negative")
+	}
+	if nonceTTL > 0 && nonceCacheSize <= 0 {
+		return nil, fmt.Errorf("NONCE_CACHE_SIZE must be positive when NONCE_TTL_SEC is set")
+	}
+	if corsAllowCredentials && slices.Contains(corsAllowedOrigins, "*") {
+		return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS must list explicit origins when CORS_ALLOW_CREDENTIALS is true")
+	}
+	if enablePprof && adminPort == 0 && pprofPort == servicePort {
+		return nil, fmt.Errorf("PPROF_PORT must differ from SERVICE_PORT")
+	}
+	if adminPort != 0 && adminPort == servicePort {
+		return nil, fmt.Errorf("ADMIN_PORT must differ from SERVICE_PORT")
+	}
+	if publishDeadline < 0 {
+		return nil, fmt.Errorf("PUBLISH_DEADLINE_SEC must not be negative")
+	}
+	if requestTimeout < 0 {
+		return nil, fmt.Errorf("REQUEST_TIMEOUT_SEC must not be negative")
+	}
+	if bodyReadTimeout < 0 {
+		return nil, fmt.Errorf("BODY_READ_TIMEOUT_SEC must not be negative")
+	}
+	if publisherPoolSize < 1 {
+		return nil, fmt.Errorf("MQ_PUBLISHER_POOL_SIZE must be at least 1")
+	}
+	if publisherConnections < 1 || publisherConnections > publisherPoolSize {
+		return nil, fmt.Errorf("MQ_PUBLISHER_CONNECTIONS must be between 1 and MQ_PUBLISHER_POOL_SIZE")
+	}
+	if spoolDir != "" && (spoolReplayBatchSize <= 0 || spoolReplayIntervalMs <= 0) {
+		return nil, fmt.Errorf("SPOOL_REPLAY_BATCH_SIZE and SPOOL_REPLAY_INTERVAL_MS must be positive")
+	}
+	if spoolDir != "" && spoolMaxBytes < 0 {
+		return nil, fmt.Errorf("SPOOL_MAX_BYTES must not be negative (0 = unlimited)")
+	}
+
+	if err := l.checkProfileKeys(); err != nil {
+		return nil, err
+	}
 
 	return &Config{
 		ServiceName:            serviceName,
+		DeployEnv:              deployEnv,
+		DeployRegion:           deployRegion,
+		InstanceID:             instanceID,
 		ServicePort:            servicePort,
+		ListenUnixSocket:       listenUnixSocket,
+		HTTPTLSCert:            httpTLSCert,
+		HTTPTLSKey:             httpTLSKey,
+		HTTPTLSMinVersion:      httpTLSMinVersion,
+		DisableTCPListener:     disableTCPListener,
+		APIBasePath:            apiBasePath,
+		HealthPath:             healthPath,
+		TrustedProxies:         trustedProxies,
+		ClientIPStrategy:       clientIPStrategy,
+		RequestIDFormat:        requestIDFormat,
+		ProbeLogEvery:          probeLogEvery,
+		ReadinessTimeoutMs:     readinessTimeoutMs,
+		ReadinessCacheMs:       readinessCacheMs,
 		RabbitMQURL:            rabbitMQURL,
 		RabbitMQExchange:       rabbitMQExchange,
 		RabbitMQRoutingKey:     rabbitMQRoutingKey,
 		RabbitMQMaxRetries:     rabbitMQMaxRetries,
 		RabbitMQRetryBaseDelay: rabbitMQRetryBaseDelay,
+		RabbitMQMaxBackoff:     rabbitMQMaxBackoff,
+		RabbitMQHeartbeat:      rabbitMQHeartbeat,
+		RabbitMQDialTimeout:    rabbitMQDialTimeout,
+		RabbitMQVhost:          rabbitMQVhost,
+		MQKeepaliveInterval:    mqKeepaliveInterval,
+		MQChannelIdleTimeout:   mqChannelIdleTimeout,
+		MQMaxConnLifetime:      mqMaxConnLifetime,
+		MQLockWatchdog:         mqLockWatchdog,
+		MQWatchdogReconnect:    mqWatchdogReconnect,
 		ServerStartTimeout:     serverStartTimeout,
 		ServerStopTimeout:      serverStopTimeout,
+		ServerDrainTimeout:     serverDrainTimeout,
+		PreShutdownDelay:       preShutdownDelay,
+		RejectWhileDraining:    rejectWhileDraining,
+		StartupConnectMaxWait:  startupConnectMaxWait,
 		PublishConfirmTimeout:  publishConfirmTimeout,
+		ConfirmTimeoutPerMsg:   confirmTimeoutPerMsg,
+		ConfirmTimeoutMax:      confirmTimeoutMax,
+		MQPublishConfirms:      mqPublishConfirms,
+		MQFailFastWhenBlocked:  mqFailFastWhenBlocked,
+		MQBatchAtomic:          mqBatchAtomic,
+		MQMandatory:            mqMandatory,
+		MQConfirmMode:          mqConfirmMode,
+		MQMessageFormat:        mqMessageFormat,
+		PublishDeadline:        publishDeadline,
+		PublishBatchWindow:     publishBatchWindow,
+		PublishBatchMax:        publishBatchMax,
+		PublishFailureStatus:   publishFailureStatus,
+		PublishRetryAfter:      publishRetryAfter,
+		RequestTimeout:         requestTimeout,
+		BodyReadTimeout:        bodyReadTimeout,
+		IngestMode:             ingestMode,
+		AsyncWorkers:           asyncWorkers,
+		AsyncQueueSize:         asyncQueueSize,
+		PublisherPoolSize:      publisherPoolSize,
+		PublisherConnections:   publisherConnections,
+		MaxConcurrentIngests:   maxConcurrentIngests,
+		BackpressureMode:       backpressureMode,
+		BackpressureWait:       backpressureWait,
+		MaxRequestBodyBytes:    int64(maxRequestBodyBytes),
+		MQDeliveryMode:         mqDeliveryMode,
+		MQMessageTTL:           mqMessageTTL,
+		MQCompressPayload:      mqCompressPayload,
+		MQCompressionLevel:     mqCompressionLevel,
+		MQDeclareExchange:      mqDeclareExchange,
+		MQExchangeType:         mqExchangeType,
+		MQDeclareQueue:         mqDeclareQueue,
+		MQQueueName:            mqQueueName,
+		MQQueueDurable:         mqQueueDurable,
+		MQQueueBindingKey:      mqQueueBindingKey,
+		SelfTestRoutingKey:     selfTestRoutingKey,
+		MQShardCount:           mqShardCount,
+		MQMaxPriority:          mqMaxPriority,
+		MeterPriorities:        meterPriorities,
+		LowercaseMeterNames:    lowercaseMeterNames,
+		MaxNameLen:             maxNameLen,
+		MaxDateLen:             maxDateLen,
+		MaxDataLen:             maxDataLen,
+		AllowedMeterNames:      allowedMeterNames,
+		MeterNamePattern:       meterNamePattern,
+		ReadingDateLayout:      readingDateLayout,
+		ReceivedAtFormat:       receivedAtFormat,
+		FingerprintInputs:      fingerprintInputs,
+		MaxUserAgentBytes:      maxUserAgentBytes,
+		ValidationWarnRules:    validationWarnRules,
+		PartialAccept:          partialAccept,
+		RequireOrderedReadings: requireOrderedReadings,
+		MaxReadingAge:          maxReadingAge,
+		DedupeWithinRequest:    dedupeWithinRequest,
+		UniqueMeterNames:       uniqueMeterNames,
+		DuplicateNamePolicy:    duplicateNamePolicy,
+		DedupeWindow:           dedupeWindow,
+		DedupeCacheSize:        dedupeCacheSize,
+		StatusTTL:              statusTTL,
+		PublishSampleRate:      publishSampleRate,
+		StatusMaxEntries:       statusMaxEntries,
+		MeterCountsWindow:      meterCountsWindow,
+		MeterCountsMaxNames:    meterCountsMaxNames,
+		AuditLogPath:           auditLogPath,
+		AuditBufferSize:        auditBufferSize,
+		MQAlternateExchange:    mqAlternateExchange,
+		MQAlternateQueue:       mqAlternateQueue,
+		MQAdditionalTargets:    mqAdditionalTargets,
+		MQFallbackExchange:     mqFallbackExchange,
+		MQFallbackRoutingKey:   mqFallbackRoutingKey,
+		MQDeadLetterExchange:   mqDeadLetterExchange,
+		MQDeadLetterKey:        mqDeadLetterKey,
+		MultiTenancyEnabled:    multiTenancyEnabled,
+		AllowedTenants:         allowedTenants,
+		TenantRoutingKey:       tenantRoutingKey,
+		SignatureKeys:          signatureKeys,
+		SignatureMaxSkew:       signatureMaxSkew,
+		NonceTTL:               nonceTTL,
+		NonceCacheSize:         nonceCacheSize,
+		ResponseHeaders:        responseHeaders,
+		CORSAllowedOrigins:     corsAllowedOrigins,
+		CORSAllowedMethods:     corsAllowedMethods,
+		CORSAllowedHeaders:     corsAllowedHeaders,
+		CORSAllowCredentials:   corsAllowCredentials,
+		CORSMaxAge:             corsMaxAge,
+		AdminToken:             adminToken,
+		EnableAPIDocs:          enableAPIDocs,
+		EnableEcho:             enableEcho,
+		ResponseFormat:         responseFormat,
 		GinMode:                ginMode,
+		EnablePprof:            enablePprof,
+		PprofHost:              pprofHost,
+		PprofPort:              pprofPort,
+		AdminHost:              adminHost,
+		AdminPort:              adminPort,
+		SpoolDir:               spoolDir,
+		SpoolMaxBytes:          spoolMaxBytes,
+		SpoolReplayBatchSize:   spoolReplayBatchSize,
+		SpoolReplayIntervalMs:  spoolReplayIntervalMs,
+		StrictConfig:           strictConfig,
 	}, nil
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+// validateServiceName ensures the service name can be used as a route prefix
+func validateServiceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("SERVICE_NAME must not be empty")
+	}
+	if name == "." || name == ".." || !pathSegmentPattern.MatchString(name) {
+		return fmt.Errorf("SERVICE_NAME %q is not URL-path-safe: use only letters, digits, '.', '_', '~' and '-'", name)
+	}
+	return nil
+}
+
+// validateRoutePath ensures a configured route is an absolute path made of
+// URL-path-safe segments, without empty segments or a trailing slash
+func validateRoutePath(key, routePath string) error {
+	if routePath == "/" {
+		return nil
+	}
+	if !strings.HasPrefix(routePath, "/") || strings.HasSuffix(routePath, "/") {
+		return fmt.Errorf("%s %q must start with '/' and must not end with '/'", key, routePath)
+	}
+	for _, segment := range strings.Split(routePath[1:], "/") {
+		if segment == "." || segment == ".." || !pathSegmentPattern.MatchString(segment) {
+			return fmt.Errorf("%s %q contains an invalid path segment %q", key, routePath, segment)
+		}
+	}
+	return nil
+}
+
+func (l *loader) getEnv(key, defaultValue string) string {
+	if value := l.lookup(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	valueStr := os.Getenv(key)
+// getSecret reads a sensitive value from key, or from the file named by
+// key_FILE (Docker and Kubernetes secrets), trimming trailing newlines.
+// Setting both is an error, as is a file that cannot be read.
+func (l *loader) getSecret(key string) (string, error) {
+	// The pair is taken from the environment when either is set there, so
+	// the environment overrides a profile without tripping the check below
+	l.known[key], l.known[key+"_FILE"] = struct{}{}, struct{}{}
+	value, path := os.Getenv(key), os.Getenv(key+"_FILE")
+	if value == "" && path == "" {
+		value, path = l.profile[key], l.profile[key+"_FILE"]
+	}
+	if path == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s and %s_FILE must not both be set", key, key)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE: cannot read secret: %w", key, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func (l *loader) getEnvAsInt(key string, defaultValue int) int {
+	valueStr := l.lookup(key)
 	if valueStr == "" {
 		return defaultValue
 	}
 	value, err := strconv.Atoi(valueStr)
 	if err != nil {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s=%q is not an integer, using the default %d", key, valueStr, defaultValue))
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsDuration parses a Go duration such as "90m" or "720h"
+func (l *loader) getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := l.lookup(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s=%q is not a duration such as 720h, using the default %s", key, valueStr, defaultValue))
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsFloat parses a decimal number such as "0.25"
+func (l *loader) getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := l.lookup(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s=%q is not a number, using the default %g", key, valueStr, defaultValue))
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvAsList splits a comma-separated variable, dropping empty entries
+func (l *loader) getEnvAsList(key string) []string {
+	return splitList(l.lookup(key))
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvAsListOr is getEnvAsList with a default for unset or empty variables
+func (l *loader) getEnvAsListOr(key string, defaultValue []string) []string {
+	if values := l.getEnvAsList(key); len(values) > 0 {
+		return values
+	}
+	return defaultValue
+}
+
+// getEnvAsMap parses a comma-separated list of key:value pairs. Values may
+// contain ':' but not ','.
+func (l *loader) getEnvAsMap(key string) (map[string]string, error) {
+	return parseMap(key, l.lookup(key))
+}
+
+// parseMap parses raw, the value of key, as getEnvAsMap does
+func parseMap(key, raw string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, pair := range splitList(raw) {
+		k, v, ok := strings.Cut(pair, ":")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("%s entries must be formatted as key:value", key)
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+// headerNamePattern matches an HTTP header field name (RFC 9110 token)
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// getEnvAsHeaders parses newline-separated "Name: value" lines, since header
+// values such as Permissions-Policy may contain commas. Blank lines are
+// skipped and a line with an empty value, e.g. "Server:", removes the header.
+func (l *loader) getEnvAsHeaders(key string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(l.lookup(key), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !headerNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s lines must be formatted as Name: value", key)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if _, dup := headers[name]; dup {
+			return nil, fmt.Errorf("%s sets %s more than once", key, name)
+		}
+		headers[name] = value
+	}
+	return headers, nil
+}
+
+// getEnvAsTargets parses a comma-separated list of exchange[:routing_key]
+// publish targets, preserving their order
+func (l *loader) getEnvAsTargets(key string) ([]PublishTarget, error) {
+	var targets []PublishTarget
+	for _, entry := range l.getEnvAsList(key) {
+		exchange, routingKey, _ := strings.Cut(entry, ":")
+		exchange, routingKey = strings.TrimSpace(exchange), strings.TrimSpace(routingKey)
+		if exchange == "" {
+			return nil, fmt.Errorf("%s entries must be formatted as exchange[:routing_key]", key)
+		}
+		targets = append(targets, PublishTarget{Exchange: exchange, RoutingKey: routingKey})
+	}
+	return targets, nil
+}
+
+func (l *loader) getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := l.lookup(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		l.invalid = append(l.invalid, fmt.Sprintf("%s=%q is not a boolean, using the default %t", key, valueStr, defaultValue))
 		return defaultValue
 	}
 	return value
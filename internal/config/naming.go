@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// namePlaceholder matches a "{...}" placeholder in an exchange or queue name
+var namePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// brokerNamePattern holds the characters RabbitMQ recommends for exchange
+// and queue names; the broker caps them at 255 bytes
+var brokerNamePattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+const maxBrokerNameLen = 255
+
+// namingVar is a value substituted for a placeholder, with the variable it
+// was read from for error messages
+type namingVar struct {
+	source string
+	value  string
+}
+
+// resolveName substitutes the placeholders of a name read from key, e.g.
+// "energy.ingest.{env}.{region}.exchange", and checks the result is a valid
+// broker name. An empty name stays empty.
+func resolveName(key, name string, vars map[string]namingVar) (string, error) {
+	var err error
+	resolved := namePlaceholder.ReplaceAllStringFunc(name, func(placeholder string) string {
+		v, ok := vars[placeholder]
+		switch {
+		case err != nil:
+		case !ok:
+			err = fmt.Errorf("%s: unknown placeholder %s, use {env}, {region} or {service}", key, placeholder)
+		case v.value == "":
+			err = fmt.Errorf("%s uses %s but %s is empty", key, placeholder, v.source)
+		}
+		return v.value
+	})
+	if err != nil {
+		return "", err
+	}
+	if resolved == "" {
+		return "", nil
+	}
+	if len(resolved) > maxBrokerNameLen || !brokerNamePattern.MatchString(resolved) {
+		return "", fmt.Errorf("%s %q must be at most %d bytes of letters, digits, '.', '_', ':' or '-'", key, resolved, maxBrokerNameLen)
+	}
+	return resolved, nil
+}
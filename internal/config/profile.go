@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileExtensions are tried in order when looking for a profile file.
+// YAML is a superset of JSON, so both are decoded the same way.
+var profileExtensions = []string{".yaml", ".yml", ".json"}
+
+// loader holds the state of one Load: the defaults of the active
+// CONFIG_PROFILE, keyed by environment variable name (nil when no profile is
+// selected), every variable looked up, to reject profile keys Load never
+// reads, and the values that could not be parsed and fell back to their
+// defaults
+type loader struct {
+	profile map[string]string
+	known   map[string]struct{}
+	invalid []string
+}
+
+// loadProfile reads config.<name>.yaml (or .yml, .json) from dir. The file
+// is a flat mapping of environment variable names to scalar values, e.g.
+// "SERVICE_PORT: 8080"; lists are written as comma-separated strings as in
+// the environment.
+func loadProfile(dir, name string) (map[string]string, error) {
+	if !pathSegmentPattern.MatchString(name) {
+		return nil, fmt.Errorf("CONFIG_PROFILE must only contain letters, digits, '.', '_', '~' or '-'")
+	}
+	for _, ext := range profileExtensions {
+		path := filepath.Join(dir, "config."+name+ext)
+		content, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CONFIG_PROFILE: cannot read %s: %w", path, err)
+		}
+		values := make(map[string]string)
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("CONFIG_PROFILE: %s must map variable names to scalar values: %w", path, err)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("CONFIG_PROFILE: no config.%s.yaml, .yml or .json in %q", name, dir)
+}
+
+// lookup returns the value of key from the environment, or from the active
+// profile when the variable is unset or empty
+func (l *loader) lookup(key string) string {
+	l.known[key] = struct{}{}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return l.profile[key]
+}
+
+// checkProfileKeys rejects profile keys that Load never looked up, so a
+// typo in a profile fails startup instead of being silently ignored
+func (l *loader) checkProfileKeys() error {
+	var unknown []string
+	for key := range l.profile {
+		if _, ok := l.known[key]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("CONFIG_PROFILE: unknown settings %v", unknown)
+}
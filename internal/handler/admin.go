@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AdminHandler handles operator endpoints
+type AdminHandler struct {
+	level   zap.AtomicLevel
+	meters  *service.MeterCounter
+	service *service.IngestService
+	logger  *zap.Logger
+}
+
+// NewAdminHandler creates a new admin handler. meters may be nil when
+// per-meter counting is disabled.
+func NewAdminHandler(level zap.AtomicLevel, meters *service.MeterCounter, ingest *service.IngestService, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{
+		level:   level,
+		meters:  meters,
+		service: ingest,
+		logger:  logger,
+	}
+}
+
+// logLevelRequest is the body of PUT /admin/loglevel
+type logLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// GetLogLevel handles GET /admin/loglevel
+func (h *AdminHandler) GetLogLevel(c *gin.Context) {
+	response.OK(c, http.StatusOK, gin.H{
+		"level": h.level.Level().String(),
+	})
+}
+
+// SetLogLevel handles PUT /admin/loglevel
+func (h *AdminHandler) SetLogLevel(c *gin.Context) {
+	var req logLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, response.CodeValidationFailed,
+			"Invalid request payload", err.Error())
+		return
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		response.Error(c, http.StatusBadRequest, response.CodeValidationFailed,
+			"Invalid log level", err.Error())
+		return
+	}
+
+	previous := h.level.Level()
+	h.level.SetLevel(level)
+
+	// Logged at warn so the audit entry survives any level change
+	logging.FromContext(c.Request.Context(), h.logger).Warn("Log level changed",
+		zap.String("previous_level", previous.String()),
+		zap.String("new_level", level.String()),
+		zap.String("client_ip", middleware.ClientIP(c)),
+	)
+
+	response.OK(c, http.StatusOK, gin.H{
+		"level":          level.String(),
+		"previous_level": previous.String(),
+	})
+}
+
+// GetMeters handles GET /admin/meters, returning the readings accepted per
+// meter name within the counting window
+func (h *AdminHandler) GetMeters(c *gin.Context) {
+	if h.meters == nil {
+		response.Error(c, http.StatusNotFound, response.CodeNotFound,
+			"Not found", "Per-meter counting is disabled")
+		return
+	}
+	counts := h.meters.Snapshot()
+	data := make(gin.H, len(counts))
+	for name, count := range counts {
+		data[name] = count
+	}
+	response.OK(c, http.StatusOK, data)
+}
+
+// SelfTest handles POST /admin/selftest. It publishes a synthetic message and
+// reports whether the broker confirmed it, for post-deploy smoke tests.
+func (h *AdminHandler) SelfTest(c *gin.Context) {
+	result, err := h.service.SelfTest(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context(), h.logger).Error("Self-test publish failed", zap.Error(err))
+		response.Error(c, http.StatusServiceUnavailable, response.CodeBrokerUnavailable,
+			"Self-test failed", err.Error())
+		return
+	}
+
+	logging.FromContext(c.Request.Context(), h.logger).Info("Self-test publish succeeded",
+		zap.String("request_id", result.RequestID),
+		zap.Duration("latency", result.Latency),
+	)
+	response.OK(c, http.StatusOK, gin.H{
+		"status":      "ok",
+		"request_id":  result.RequestID,
+		"routing_key": result.RoutingKey,
+		"latency_ms":  result.Latency.Milliseconds(),
+	})
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Rejection reasons for bodies that never reach the service, recorded in
+// ingest_rejections_total next to the service.Reject* reasons
+const (
+	rejectEmptyBody     = "empty_body"
+	rejectMalformedBody = "malformed_body"
+	rejectMissingField  = "missing_field"
+	rejectBodyTooLarge  = "body_too_large"
+	rejectBodyTimeout   = "body_timeout"
+)
+
+// ConfigureBinding sets up Gin's request binding the way the handlers expect
+// it. It changes process-wide state, so it is called once before serving.
+func ConfigureBinding() {
+	// Numbers bound into interface{} become json.Number rather than float64,
+	// which would silently round meter registers above 2^53
+	binding.EnableDecoderUseNumber = true
+	// Validation errors name fields by their json tag (PM[0].name), matching
+	// what the client sent rather than the Go struct field
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// bindError describes why a request body could not be bound, already mapped
+// to the status and details the client should see
+type bindError struct {
+	status  int
+	reason  string
+	details []string
+}
+
+// bindBody decodes and validates body into obj, separating the three ways a
+// payload can be rejected before it reaches the service:
+//   - an empty or whitespace-only body (400 "request body is empty")
+//   - a body the codec cannot decode (400 "invalid JSON: <detail>")
+//   - a well-formed body missing required fields (422 with one entry per field)
+func bindBody(body []byte, b binding.BindingBody, obj any) *bindError {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &bindError{status: http.StatusBadRequest, reason: rejectEmptyBody, details: []string{"request body is empty"}}
+	}
+
+	err := b.BindBody(body, obj)
+	if err == nil {
+		return nil
+	}
+
+	// The binding decodes first and validates second; only the validator
+	// returns ValidationErrors, so anything else is a decode failure
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		details := make([]string, 0, len(verrs))
+		for _, fe := range verrs {
+			details = append(details, fieldErrorDetail(fe))
+		}
+		return &bindError{status: http.StatusUnprocessableEntity, reason: rejectMissingField, details: details}
+	}
+
+	format := "JSON"
+	if b == binding.MsgPack {
+		format = "MessagePack"
+	}
+	return &bindError{
+		status:  http.StatusBadRequest,
+		reason:  rejectMalformedBody,
+		details: []string{fmt.Sprintf("invalid %s: %v", format, err)},
+	}
+}
+
+// fieldErrorDetail renders a validator error as "PM[0].name is required",
+// using the json field path without the root struct name
+func fieldErrorDetail(fe validator.FieldError) string {
+	field := fe.Namespace()
+	if i := strings.IndexByte(field, '.'); i >= 0 {
+		field = field[i+1:]
+	}
+	if fe.Tag() == "required" {
+		return field + " is required"
+	}
+	return fmt.Sprintf("%s failed the %q check", field, fe.Tag())
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"github.com/ugorji/go/codec"
+)
+
+func TestMessagePackBodyPublishesSameMessage(t *testing.T) {
+	reading := map[string]string{"date": "01/03/2024 12:00:00", "data": "42.5", "name": "meter-1"}
+
+	jsonPub := &recordingPublisher{}
+	w := post(newTestRouter(t, jsonPub, nil), "application/json",
+		[]byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":"42.5","name":"meter-1"}]}`), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("JSON status = %d: %s", w.Code, w.Body)
+	}
+
+	var body []byte
+	if err := codec.NewEncoderBytes(&body, new(codec.MsgpackHandle)).Encode(map[string]interface{}{"PM": []map[string]string{reading}}); err != nil {
+		t.Fatalf("encode msgpack: %v", err)
+	}
+	msgpackPub := &recordingPublisher{}
+	w = post(newTestRouter(t, msgpackPub, nil), "application/msgpack", body, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("MessagePack status = %d: %s", w.Code, w.Body)
+	}
+
+	fromJSON, fromMsgpack := publishedMessage(t, jsonPub), publishedMessage(t, msgpackPub)
+	// Request IDs are generated per request
+	fromJSON.RequestID, fromMsgpack.RequestID = "", ""
+	if fromJSON.Payload.PM[0] != fromMsgpack.Payload.PM[0] || fromJSON.ReceivedAt != fromMsgpack.ReceivedAt ||
+		fromJSON.SchemaVersion != fromMsgpack.SchemaVersion || fromJSON.ClientFingerprint != fromMsgpack.ClientFingerprint {
+		t.Errorf("MessagePack published %+v, want the JSON message %+v", fromMsgpack, fromJSON)
+	}
+}
+
+func TestMalformedMessagePackBody(t *testing.T) {
+	pub := &recordingPublisher{}
+	w := post(newTestRouter(t, pub, nil), "application/msgpack", []byte{0xc1}, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an undecodable body", w.Code)
+	}
+	if len(pub.published()) != 0 {
+		t.Error("an undecodable body was published")
+	}
+}
+
+func TestLargeRegisterRoundTripsExactly(t *testing.T) {
+	// 2^53 + 1 is the first integer a float64 cannot represent
+	const register = "9007199254740993"
+	if strconv.FormatFloat(mustParseFloat(t, register), 'f', -1, 64) == register {
+		t.Fatal("the register value does not exercise float64 rounding")
+	}
+
+	pub := &recordingPublisher{}
+	w := post(newTestRouter(t, pub, nil), "application/json",
+		[]byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":"`+register+`","name":"meter-1"}]}`), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	// As the publisher serializes it, and as a consumer decodes it
+	body, err := json.Marshal(publishedMessage(t, pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var consumed struct {
+		Payload struct {
+			PM []struct {
+				Data json.Number `json:"data"`
+			} `json:"PM"`
+		} `json:"payload"`
+	}
+	if err := dec.Decode(&consumed); err != nil {
+		t.Fatalf("decode published message: %v", err)
+	}
+	if got := consumed.Payload.PM[0].Data.String(); got != register {
+		t.Errorf("published data = %s, want %s exactly", got, register)
+	}
+}
+
+func TestBareNumberDataRejected(t *testing.T) {
+	pub := &recordingPublisher{}
+	w := post(newTestRouter(t, pub, nil), "application/json",
+		[]byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":9007199254740993,"name":"meter-1"}]}`), nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for a numeric data field", w.Code)
+	}
+	if len(pub.published()) != 0 {
+		t.Error("a numeric data field was published")
+	}
+}
+
+func mustParseFloat(t *testing.T, s string) float64 {
+	t.Helper()
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestBodyRejections(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantCode    int
+		wantDetails []string
+	}{
+		{"empty body", ``, http.StatusBadRequest, []string{"request body is empty"}},
+		{"whitespace-only body", " \n\t ", http.StatusBadRequest, []string{"request body is empty"}},
+		{"malformed JSON", `{"PM": [`, http.StatusBadRequest, []string{"invalid JSON: unexpected EOF"}},
+		{"empty object", `{}`, http.StatusUnprocessableEntity, []string{"PM is required"}},
+		{"missing reading fields", `{"PM":[{"date":"01/03/2024 12:00:00"}]}`, http.StatusUnprocessableEntity, []string{"PM[0].data is required", "PM[0].name is required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &recordingPublisher{}
+			w := post(newTestRouter(t, pub, nil), "application/json", []byte(tt.body), nil)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			var envelope response.Envelope
+			if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error == nil {
+				t.Fatalf("body %s is not an error envelope: %v", w.Body, err)
+			}
+			if !slices.Equal(envelope.Error.Details, tt.wantDetails) {
+				t.Errorf("details = %q, want %q", envelope.Error.Details, tt.wantDetails)
+			}
+			if len(pub.published()) != 0 {
+				t.Error("a rejected body was published")
+			}
+		})
+	}
+}
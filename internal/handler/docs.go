@@ -0,0 +1,73 @@
+package handler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec documents the routes in this package. Its API paths use the
+// default "/api/v1" base path and are rewritten to the configured one.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+const defaultSpecBasePath = "/api/v1"
+
+// swaggerUIPage renders the spec with Swagger UI loaded from a CDN
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Energy Metering Ingest API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>`
+
+// DocsHandler serves the OpenAPI spec and Swagger UI
+type DocsHandler struct {
+	spec []byte
+}
+
+// NewDocsHandler creates a docs handler whose spec uses apiBasePath
+func NewDocsHandler(apiBasePath string) (*DocsHandler, error) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse embedded OpenAPI spec: %w", err)
+	}
+
+	paths, _ := spec["paths"].(map[string]interface{})
+	rewritten := make(map[string]interface{}, len(paths))
+	for path, item := range paths {
+		if rest, ok := strings.CutPrefix(path, defaultSpecBasePath+"/"); ok {
+			path = apiBasePath + "/" + rest
+		}
+		rewritten[path] = item
+	}
+	spec["paths"] = rewritten
+
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render OpenAPI spec: %w", err)
+	}
+	return &DocsHandler{spec: body}, nil
+}
+
+// Spec handles GET /openapi.json
+func (h *DocsHandler) Spec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", h.spec)
+}
+
+// UI handles GET /docs
+func (h *DocsHandler) UI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
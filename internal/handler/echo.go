@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+)
+
+// EchoReading handles POST /api/v1/meter/readings/echo. It binds the body
+// exactly like IngestReading and reports how the service interprets it,
+// without publishing anything. A request that would be rejected still gets
+// 200, with the rejection in validation_error, so integrators can see why.
+//
+// Only identifiers derived from the request are echoed: the signing key ID,
+// never a key or the Authorization header value.
+func (h *MeterHandler) EchoReading(c *gin.Context) {
+	var req service.IngestRequest
+	if h.bindRequest(c, &req) != "" {
+		return
+	}
+	metadata := clientMetadata(c)
+
+	result := h.service.Echo(req, metadata)
+
+	data := gin.H{
+		"request": req,
+		"client_metadata": gin.H{
+			"ip_address":      metadata.IPAddress,
+			"user_agent":      metadata.UserAgent,
+			"has_auth_header": metadata.HasAuthHeader,
+			"tenant_id":       metadata.TenantID,
+			"key_id":          metadata.KeyID,
+			"device_id":       metadata.DeviceID,
+			"accept_language": metadata.AcceptLanguage,
+			"priority":        metadata.Priority,
+		},
+		"client_fingerprint": result.ClientFingerprint,
+	}
+	if result.ValidationError != nil {
+		data["validation_error"] = result.ValidationError.Error()
+	} else {
+		data["normalized"] = result.Normalized
+		data["tenant_id"] = result.TenantID
+		data["routing_keys"] = result.RoutingKeys
+		data["priority"] = result.Priority
+		data["duplicates_dropped"] = result.DuplicatesDropped
+		data["warnings"] = result.Warnings
+		if result.AcceptedIndices != nil {
+			data["accepted_indices"] = result.AcceptedIndices
+			data["rejected"] = result.Rejected
+		}
+	}
+	response.OK(c, http.StatusOK, data)
+}
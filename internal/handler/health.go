@@ -1,23 +1,164 @@
 package handler
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
 )
 
-// HealthHandler handles health check endpoint
-type HealthHandler struct{}
+// BrokerPinger performs a round-trip to the message broker. It is satisfied
+// by *mq.Publisher.
+type BrokerPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// LivenessChecker reports, without blocking, whether a component is wedged.
+// It is satisfied by *mq.Publisher.
+type LivenessChecker interface {
+	Wedged() error
+}
+
+// PublishTracker reports when a publish last succeeded. It is satisfied by
+// *mq.Publisher.
+type PublishTracker interface {
+	LastConfirmed() time.Time
+}
+
+// ReadinessConfig configures the readiness probe
+type ReadinessConfig struct {
+	// Timeout bounds the broker round-trip so the probe never hangs
+	Timeout time.Duration
+	// CacheTTL reuses the last result for this long; zero pings on every probe
+	CacheTTL time.Duration
+}
+
+// brokerCheck is the outcome of one broker ping
+type brokerCheck struct {
+	err       error
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+// HealthHandler handles health check endpoints
+type HealthHandler struct {
+	broker    BrokerPinger
+	liveness  LivenessChecker
+	publishes PublishTracker
+	cfg       ReadinessConfig
+	startedAt time.Time // the handler is built at startup, so this is the process start
+
+	shuttingDown atomic.Bool
+
+	mu        sync.Mutex
+	lastCheck brokerCheck
+}
 
 // NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+func NewHealthHandler(broker BrokerPinger, liveness LivenessChecker, publishes PublishTracker, cfg ReadinessConfig) *HealthHandler {
+	return &HealthHandler{
+		broker:    broker,
+		liveness:  liveness,
+		publishes: publishes,
+		cfg:       cfg,
+		startedAt: time.Now(),
+	}
 }
 
-// Check handles GET /health
+// Check handles GET /health. It never waits on the broker or the publisher,
+// and fails only when the publisher is wedged, so a restart is the fix.
 func (h *HealthHandler) Check(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "healthy",
-		"service": "energy-metering-ingest-api",
+	if h.liveness != nil {
+		if err := h.liveness.Wedged(); err != nil {
+			response.Error(c, http.StatusServiceUnavailable, response.CodeInternal,
+				"Not healthy", err.Error())
+			return
+		}
+	}
+	response.OK(c, http.StatusOK, gin.H{
+		"status":                  "healthy",
+		"service":                 "energy-metering-ingest-api",
+		"uptime_seconds":          h.uptimeSeconds(),
+		"last_successful_publish": h.lastSuccessfulPublish(),
 	})
 }
+
+// SetShuttingDown makes /ready report not-ready from now on, so load
+// balancers stop routing to an instance that is about to drain
+func (h *HealthHandler) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Ready handles GET /ready. It reports not-ready once shutdown has begun, or
+// while the broker cannot be reached so load balancers stop routing ingest
+// traffic that would fail, and reports the broker round-trip latency otherwise.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	if h.shuttingDown.Load() {
+		response.Error(c, http.StatusServiceUnavailable, response.CodeShuttingDown,
+			"Not ready", "service is shutting down")
+		return
+	}
+	check := h.checkBroker(c.Request.Context())
+	if check.err != nil {
+		response.Error(c, http.StatusServiceUnavailable, response.CodeBrokerUnavailable,
+			"Not ready", "RabbitMQ is not reachable: "+check.err.Error())
+		return
+	}
+	response.OK(c, http.StatusOK, gin.H{
+		"status":                  "ready",
+		"uptime_seconds":          h.uptimeSeconds(),
+		"last_successful_publish": h.lastSuccessfulPublish(),
+		"rabbitmq": gin.H{
+			"up":         true,
+			"latency_ms": check.latency.Milliseconds(),
+			"checked_at": check.checkedAt.UTC().Format(time.RFC3339Nano),
+		},
+	})
+}
+
+// checkBroker pings the broker, reusing a result younger than the cache TTL.
+// Concurrent probes share one ping instead of piling onto the broker.
+func (h *HealthHandler) checkBroker(ctx context.Context) brokerCheck {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.lastCheck.checkedAt.IsZero() && time.Since(h.lastCheck.checkedAt) < h.cfg.CacheTTL {
+		return h.lastCheck
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.broker.Ping(pingCtx)
+	h.lastCheck = brokerCheck{
+		err:       err,
+		latency:   time.Since(start),
+		checkedAt: start,
+	}
+	return h.lastCheck
+}
+
+// uptimeSeconds returns how long the process has been running
+func (h *HealthHandler) uptimeSeconds() int64 {
+	return int64(time.Since(h.startedAt).Seconds())
+}
+
+// lastSuccessfulPublish returns when a publish last succeeded, or nil if
+// none has since startup. Together with recent traffic, a value far in the
+// past means publishes are failing.
+func (h *HealthHandler) lastSuccessfulPublish() *string {
+	if h.publishes == nil {
+		return nil
+	}
+	last := h.publishes.LastConfirmed()
+	if last.IsZero() {
+		return nil
+	}
+	formatted := last.UTC().Format(time.RFC3339Nano)
+	return &formatted
+}
@@ -1,89 +1,330 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
 	"github.com/septivank/energy-metering-ingest-api/internal/service"
 	"go.uber.org/zap"
 )
 
+// StatusClientClosedRequest is the non-standard status (popularized by nginx)
+// recorded when the client disconnects before a response is produced
+const StatusClientClosedRequest = 499
+
+// PublishOutcomeHeader tells the client of a failed publish whether the
+// message may have been delivered anyway: "unknown" (retrying risks a
+// duplicate) or "not_delivered" (safe to retry)
+const PublishOutcomeHeader = "X-Publish-Outcome"
+
+// PublishFailureConfig shapes the response to a failed publish
+type PublishFailureConfig struct {
+	// Status replaces 503 for broker failures, e.g. 429 for clients that
+	// treat 503 as permanent
+	Status int
+	// RetryAfter is sent as Retry-After on every publish failure
+	RetryAfter time.Duration
+}
+
 // MeterHandler handles meter reading endpoints
 type MeterHandler struct {
-	service *service.IngestService
-	logger  *zap.Logger
+	service        *service.IngestService
+	failureStatus  int
+	retryAfterSecs string
+	logger         *zap.Logger
 }
 
 // NewMeterHandler creates a new meter handler
-func NewMeterHandler(service *service.IngestService, logger *zap.Logger) *MeterHandler {
+func NewMeterHandler(service *service.IngestService, failure PublishFailureConfig, logger *zap.Logger) *MeterHandler {
 	return &MeterHandler{
-		service: service,
-		logger:  logger,
+		service:        service,
+		failureStatus:  failure.Status,
+		retryAfterSecs: strconv.Itoa(int(failure.RetryAfter.Seconds())),
+		logger:         logger,
 	}
 }
 
+// log returns the logger of the request, carrying its request_id
+func (h *MeterHandler) log(c *gin.Context) *zap.Logger {
+	return logging.FromContext(c.Request.Context(), h.logger)
+}
+
 // IngestReading handles POST /api/v1/meter/readings
 func (h *MeterHandler) IngestReading(c *gin.Context) {
 	var req service.IngestRequest
+	if reason := h.bindRequest(c, &req); reason != "" {
+		ingestRejections.WithLabelValues(reason).Inc()
+		return
+	}
+	metadata := clientMetadata(c)
 
-	// Bind and validate JSON
-	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.Warn("Invalid request payload",
-			zap.Error(err),
-			zap.String("client_ip", getClientIP(c)),
+	// Process reading
+	result, err := h.service.ProcessReading(c.Request.Context(), req, metadata)
+	if errors.Is(err, service.ErrInvalidTenant) {
+		ingestErrors.WithLabelValues("invalid_tenant").Inc()
+		ingestRejections.WithLabelValues(service.RejectInvalidTenant).Inc()
+		h.log(c).Warn("Rejected request with invalid tenant",
+			zap.String("tenant_id", metadata.TenantID),
+			zap.String("client_ip", metadata.IPAddress),
 		)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid request payload",
-			"details": err.Error(),
-		})
+		response.Error(c, http.StatusBadRequest, response.CodeValidationFailed,
+			"Invalid tenant", "X-Tenant-ID header is missing or not recognized")
+		return
+	}
+	if metadata.TenantID != "" {
+		c.Set(middleware.TenantIDKey, metadata.TenantID)
+	}
+	if result.ClientFingerprint != "" {
+		c.Set(middleware.ClientFingerprintKey, result.ClientFingerprint)
+	}
+	if err != nil {
+		h.writeProcessError(c, err, metadata)
 		return
 	}
 
-	// Extract client metadata
-	metadata := service.ClientMetadata{
-		IPAddress:     getClientIP(c),
-		UserAgent:     c.GetHeader("User-Agent"),
-		HasAuthHeader: c.GetHeader("Authorization") != "",
+	message := "Meter reading ingested successfully"
+	if result.Queued {
+		message = "Meter reading queued for publishing"
+	}
+	data := gin.H{
+		"status":             "accepted",
+		"message":            message,
+		"request_id":         result.RequestID,
+		"readings_accepted":  result.ReadingsAccepted,
+		"duplicates_dropped": result.DuplicatesDropped,
+		"previously_seen":    result.PreviouslySeen,
+	}
+	if len(result.Warnings) > 0 {
+		data["warnings"] = result.Warnings
+	}
+	status := http.StatusAccepted
+	if len(result.Rejected) > 0 {
+		// Multi-Status: some readings were published, the rest rejected
+		status = http.StatusMultiStatus
+		data["status"] = "partially_accepted"
+		data["message"] = "Some meter readings were invalid; the others were ingested"
+		data["accepted_indices"] = result.AcceptedIndices
+		data["rejected"] = result.Rejected
 	}
+	if h.service.TracksStatus() {
+		statusURL := strings.TrimSuffix(c.Request.URL.Path, "/") + "/" + result.RequestID + "/status"
+		c.Header("Location", statusURL)
+		data["status_url"] = statusURL
+	}
+	response.OK(c, status, data)
+}
 
-	// Process reading
-	if err := h.service.ProcessReading(c.Request.Context(), req, metadata); err != nil {
-		h.logger.Error("Failed to process reading",
+// writeProcessError maps a ProcessReading error to a response and an
+// ingest_errors_total type: invalid content is the client's fault (422), a
+// gone client gets 499, broker failures and timeouts are retryable (503, or
+// PUBLISH_FAILURE_STATUS, and 504), and anything else is a bug (500)
+func (h *MeterHandler) writeProcessError(c *gin.Context, err error, metadata service.ClientMetadata) {
+	ingestErrors.WithLabelValues(errorType(err)).Inc()
+
+	switch {
+	case errors.Is(err, service.ErrValidation):
+		ingestRejections.WithLabelValues(service.RejectionReason(err)).Inc()
+		h.log(c).Warn("Rejected invalid reading",
+			zap.Error(err),
+			zap.String("client_ip", metadata.IPAddress),
+		)
+		response.Error(c, http.StatusUnprocessableEntity, response.CodeValidationFailed,
+			"Invalid reading", err.Error())
+	case errors.Is(err, service.ErrPublishDeadline):
+		h.setPublishFailureHeaders(c, err)
+		response.Error(c, http.StatusGatewayTimeout, response.CodeBrokerTimeout,
+			"Failed to process reading", "Publishing did not complete in time")
+	case errors.Is(err, service.ErrPublishTimeout):
+		h.log(c).Error("Broker did not confirm the reading in time",
+			zap.Error(err),
+			zap.String("client_ip", metadata.IPAddress),
+		)
+		h.setPublishFailureHeaders(c, err)
+		response.Error(c, http.StatusGatewayTimeout, response.CodeBrokerTimeout,
+			"Failed to process reading", "The message broker did not confirm the reading in time")
+	case errors.Is(err, context.DeadlineExceeded):
+		h.log(c).Warn("Request timed out before the reading was processed",
+			zap.Error(err),
+			zap.String("client_ip", metadata.IPAddress),
+		)
+		response.Error(c, http.StatusGatewayTimeout, response.CodeRequestTimeout,
+			"Request timed out", "The request did not complete in time")
+	case errors.Is(err, context.Canceled):
+		h.log(c).Warn("Client went away before the reading was processed",
+			zap.Error(err),
+			zap.String("client_ip", metadata.IPAddress),
+		)
+		response.Error(c, StatusClientClosedRequest, response.CodeClientClosed,
+			"Request canceled", "The client closed the request before it completed")
+	case errors.Is(err, service.ErrQueueFull):
+		c.Header("Retry-After", "1")
+		response.Error(c, http.StatusServiceUnavailable, response.CodeRateLimited,
+			"Failed to process reading", "Publish queue is full, retry later")
+	case errors.Is(err, service.ErrShuttingDown):
+		// Like RejectWhileDraining, so the client retries on another instance
+		c.Header("Connection", "close")
+		response.Error(c, http.StatusServiceUnavailable, response.CodeShuttingDown,
+			"Service unavailable", "service is shutting down")
+	case errors.Is(err, service.ErrBrokerBlocked):
+		h.log(c).Warn("Broker is blocking publishes",
+			zap.Error(err),
+			zap.String("client_ip", metadata.IPAddress),
+		)
+		h.setPublishFailureHeaders(c, err)
+		response.Error(c, h.failureStatus, response.CodeBrokerBlocked,
+			"Failed to process reading", "The message broker is temporarily refusing messages")
+	case errors.Is(err, service.ErrBrokerUnavailable):
+		h.log(c).Error("Failed to process reading",
 			zap.Error(err),
 			zap.String("client_ip", metadata.IPAddress),
 		)
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"error":   "Failed to process reading",
-			"message": "Service temporarily unavailable",
-		})
+		h.setPublishFailureHeaders(c, err)
+		response.Error(c, h.failureStatus, response.CodeBrokerUnavailable,
+			"Failed to process reading", "Service temporarily unavailable")
+	default:
+		h.log(c).Error("Unexpected error processing reading",
+			zap.Error(err),
+			zap.String("client_ip", metadata.IPAddress),
+		)
+		response.Error(c, http.StatusInternalServerError, response.CodeInternal,
+			"Internal server error")
+	}
+}
+
+// setPublishFailureHeaders tells the client when to retry a failed publish
+// and whether doing so risks delivering the message twice
+func (h *MeterHandler) setPublishFailureHeaders(c *gin.Context, err error) {
+	c.Header("Retry-After", h.retryAfterSecs)
+	outcome := "not_delivered"
+	if service.DeliveryUnknown(err) {
+		outcome = "unknown"
+	}
+	c.Header(PublishOutcomeHeader, outcome)
+}
+
+// errorType returns the ingest_errors_total label for a ProcessReading error,
+// checking in the same order as writeProcessError
+func errorType(err error) string {
+	switch {
+	case errors.Is(err, service.ErrEmptyBatch):
+		return "empty_batch"
+	case errors.Is(err, service.ErrInvalidField):
+		return "invalid_field"
+	case errors.Is(err, service.ErrValidation):
+		return "validation"
+	case errors.Is(err, service.ErrPublishDeadline):
+		return "publish_deadline"
+	case errors.Is(err, service.ErrPublishTimeout):
+		return "publish_timeout"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "request_timeout"
+	case errors.Is(err, context.Canceled):
+		return "client_closed"
+	case errors.Is(err, service.ErrQueueFull):
+		return "queue_full"
+	case errors.Is(err, service.ErrShuttingDown):
+		return "shutting_down"
+	case errors.Is(err, service.ErrBrokerBlocked):
+		return "broker_blocked"
+	case errors.Is(err, service.ErrBrokerUnavailable):
+		return "broker_unavailable"
+	default:
+		return "internal"
+	}
+}
+
+// GetStatus handles GET /api/v1/meter/readings/:request_id/status
+func (h *MeterHandler) GetStatus(c *gin.Context) {
+	requestID := c.Param("request_id")
+	entry, ok := h.service.Status(requestID)
+	if !ok {
+		response.Error(c, http.StatusNotFound, response.CodeNotFound,
+			"Unknown request", "No status is recorded for this request ID; it may have expired")
 		return
 	}
 
-	c.JSON(http.StatusAccepted, gin.H{
-		"status":  "accepted",
-		"message": "Meter reading ingested successfully",
+	response.OK(c, http.StatusOK, gin.H{
+		"request_id": requestID,
+		"status":     entry.Status,
+		"updated_at": entry.UpdatedAt.UTC().Format(time.RFC3339),
 	})
 }
 
-// getClientIP extracts the real client IP, respecting X-Forwarded-For
-func getClientIP(c *gin.Context) string {
-	// Check X-Forwarded-For header first
-	xff := c.GetHeader("X-Forwarded-For")
-	if xff != "" {
-		// X-Forwarded-For can contain multiple IPs, take the first one
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+// bindRequest reads and binds the request body into obj. On failure it
+// writes the error response and returns the rejection reason; it returns ""
+// on success.
+func (h *MeterHandler) bindRequest(c *gin.Context, obj any) string {
+	// Bind and validate the body; RequireContentType admits only JSON and
+	// MessagePack, which decodes into the same struct via its json tags.
+	// The body is read up front so an empty one can be told apart from a
+	// malformed one and from one that is merely missing fields
+	b := binding.JSON
+	if ct := c.ContentType(); strings.EqualFold(ct, binding.MIMEMSGPACK) || strings.EqualFold(ct, binding.MIMEMSGPACK2) {
+		b = binding.MsgPack
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.log(c).Warn("Request body too large",
+				zap.Int64("limit", maxBytesErr.Limit),
+				zap.String("client_ip", middleware.ClientIP(c)),
+			)
+			middleware.AbortBodyTooLarge(c, maxBytesErr.Limit)
+			return rejectBodyTooLarge
+		}
+		var timeoutErr *middleware.BodyReadTimeoutError
+		if errors.As(err, &timeoutErr) {
+			h.log(c).Warn("Request body not received in time",
+				zap.Duration("timeout", timeoutErr.Timeout),
+				zap.String("client_ip", middleware.ClientIP(c)),
+			)
+			middleware.AbortBodyReadTimeout(c, timeoutErr.Timeout)
+			return rejectBodyTimeout
 		}
+		h.log(c).Warn("Failed to read request body",
+			zap.Error(err),
+			zap.String("client_ip", middleware.ClientIP(c)),
+		)
+		response.Error(c, http.StatusBadRequest, response.CodeValidationFailed,
+			"Invalid request payload", err.Error())
+		return rejectMalformedBody
 	}
-
-	// Check X-Real-IP header
-	xri := c.GetHeader("X-Real-IP")
-	if xri != "" {
-		return strings.TrimSpace(xri)
+	if berr := bindBody(body, b, obj); berr != nil {
+		h.log(c).Warn("Invalid request payload",
+			zap.Int("status", berr.status),
+			zap.Strings("details", berr.details),
+			zap.String("client_ip", middleware.ClientIP(c)),
+		)
+		response.Error(c, berr.status, response.CodeValidationFailed,
+			"Invalid request payload", berr.details...)
+		return berr.reason
 	}
+	return ""
+}
 
-	// Fall back to RemoteAddr
-	return c.ClientIP()
+// clientMetadata extracts the client information the service uses for
+// fingerprinting, tenancy and priority
+func clientMetadata(c *gin.Context) service.ClientMetadata {
+	return service.ClientMetadata{
+		IPAddress:     middleware.ClientIP(c),
+		UserAgent:     c.GetHeader("User-Agent"),
+		HasAuthHeader: c.GetHeader("Authorization") != "",
+		TenantID:      strings.TrimSpace(c.GetHeader("X-Tenant-ID")),
+
+		KeyID:          c.GetHeader(middleware.SignatureKeyIDHeader),
+		DeviceID:       strings.TrimSpace(c.GetHeader("X-Device-ID")),
+		AcceptLanguage: c.GetHeader("Accept-Language"),
+		Priority:       strings.TrimSpace(c.GetHeader("X-Priority")),
+	}
 }
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+	"github.com/septivank/energy-metering-ingest-api/tools/fingerprint"
+	"go.uber.org/zap"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	ConfigureBinding()
+}
+
+// recordingPublisher records published messages; fail, when set, decides
+// the error of the n-th call (counting from 0)
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages []interface{}
+	fail     func(n int) error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, routingKey string, message interface{}, opts mq.PublishOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.messages)
+	p.messages = append(p.messages, message)
+	if p.fail != nil {
+		return p.fail(n)
+	}
+	return nil
+}
+
+func (p *recordingPublisher) published() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]interface{}(nil), p.messages...)
+}
+
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+const readingsPath = "/api/v1/meter/readings"
+
+// newTestRouter serves the ingest route with a service publishing to pub;
+// configure adjusts the service options first
+func newTestRouter(t *testing.T, pub service.Publisher, configure func(*service.Options)) *gin.Engine {
+	t.Helper()
+	return newTestRouterWith(t, pub, configure)
+}
+
+// newTestRouterWith is newTestRouter with middleware ahead of the route
+func newTestRouterWith(t *testing.T, pub service.Publisher, configure func(*service.Options), handlers ...gin.HandlerFunc) *gin.Engine {
+	t.Helper()
+	opts := service.Options{
+		RoutingKey: "meter.reading.ingested",
+		Source:     "ingest-test",
+		DateLayout: service.DefaultDateLayout,
+		Clock:      fixedClock{time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	h := NewMeterHandler(service.NewIngestService(pub, zap.NewNop(), opts), PublishFailureConfig{Status: http.StatusServiceUnavailable}, zap.NewNop())
+	r := gin.New()
+	r.Use(handlers...)
+	r.POST(readingsPath, h.IngestReading)
+	return r
+}
+
+// post sends body to the ingest route with contentType and extra headers
+func post(r http.Handler, contentType string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, readingsPath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// publishedMessage returns the single native message published to pub
+func publishedMessage(t *testing.T, pub *recordingPublisher) service.IngestMessage {
+	t.Helper()
+	messages := pub.published()
+	if len(messages) != 1 {
+		t.Fatalf("published %d messages, want 1", len(messages))
+	}
+	msg, ok := messages[0].(service.IngestMessage)
+	if !ok {
+		t.Fatalf("published %T, want service.IngestMessage", messages[0])
+	}
+	return msg
+}
+
+// responseData decodes the data of a success envelope
+func responseData(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var envelope struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("response %q: %v", w.Body, err)
+	}
+	return envelope.Data
+}
+
+// shardedBody returns a body with one reading for each of n meters
+func shardedBody(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"PM":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"date":"01/03/2024 12:00:00","data":"1","name":"meter-%d"}`, i)
+	}
+	b.WriteString(`]}`)
+	return b.Bytes()
+}
+
+func TestShardPublishOutcome(t *testing.T) {
+	tests := []struct {
+		name        string
+		failFrom    int // first failing publish
+		wantOutcome string
+	}{
+		{name: "first shard fails", failFrom: 0, wantOutcome: "not_delivered"},
+		{name: "later shard fails", failFrom: 1, wantOutcome: "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &recordingPublisher{fail: func(n int) error {
+				if n >= tt.failFrom {
+					return mq.ErrNotConnected
+				}
+				return nil
+			}}
+			r := newTestRouter(t, pub, func(o *service.Options) { o.ShardCount = 4 })
+
+			w := post(r, "application/json", shardedBody(20), nil)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get(PublishOutcomeHeader); got != tt.wantOutcome {
+				t.Errorf("%s = %q, want %q", PublishOutcomeHeader, got, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestWarningsInAcceptedResponse(t *testing.T) {
+	pub := &recordingPublisher{}
+	r := newTestRouter(t, pub, func(o *service.Options) {
+		o.AllowedMeterNames = []string{"m1"}
+		o.WarnRules = []string{service.RejectUnknownMeter}
+	})
+
+	w := post(r, "application/json", []byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m9"}]}`), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+	data := responseData(t, w)
+	warnings, _ := data["warnings"].([]interface{})
+	if data["status"] != "accepted" || len(warnings) != 1 {
+		t.Fatalf("response %v, want accepted with one warning", data)
+	}
+	if rule := warnings[0].(map[string]interface{})["rule"]; rule != service.RejectUnknownMeter {
+		t.Errorf("warning rule = %v, want %s", rule, service.RejectUnknownMeter)
+	}
+	if msg := publishedMessage(t, pub); len(msg.Warnings) != 1 {
+		t.Errorf("message warnings %+v, want one", msg.Warnings)
+	}
+}
+
+func TestPartialAcceptMultiStatus(t *testing.T) {
+	pub := &recordingPublisher{}
+	r := newTestRouter(t, pub, func(o *service.Options) { o.PartialAccept = true })
+
+	body := `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"},{"date":" ","data":"2","name":"m2"}]}`
+	w := post(r, "application/json", []byte(body), nil)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", w.Code, w.Body)
+	}
+	data := responseData(t, w)
+	rejected, _ := data["rejected"].([]interface{})
+	if data["status"] != "partially_accepted" || len(rejected) != 1 {
+		t.Fatalf("response %v, want partially_accepted with one rejection", data)
+	}
+	if accepted, _ := data["accepted_indices"].([]interface{}); len(accepted) != 1 || accepted[0] != float64(0) {
+		t.Errorf("accepted_indices = %v, want [0]", data["accepted_indices"])
+	}
+	if pm := publishedMessage(t, pub).Payload.PM; len(pm) != 1 || pm[0].Name != "m1" {
+		t.Errorf("published readings %+v, want only m1", pm)
+	}
+}
+
+func TestOversizedUserAgentTruncated(t *testing.T) {
+	pub := &recordingPublisher{}
+	r := newTestRouterWith(t, pub, nil, middleware.LimitUserAgent(512))
+	body := []byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"}]}`)
+
+	w := post(r, "application/json", body, map[string]string{"User-Agent": strings.Repeat("a", 4<<20)})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+	msg := publishedMessage(t, pub)
+	want := strings.Repeat("a", 509) + "..."
+	if msg.UserAgent != want {
+		t.Fatalf("message User-Agent is %d bytes, want the 512-byte truncation", len(msg.UserAgent))
+	}
+
+	// The fingerprint is derived from the truncated value, so it matches a
+	// client that sent exactly that User-Agent
+	pub.messages = nil
+	post(r, "application/json", body, map[string]string{"User-Agent": want})
+	if fp := publishedMessage(t, pub).ClientFingerprint; fp != msg.ClientFingerprint {
+		t.Errorf("fingerprint %s, want %s from the truncated User-Agent", msg.ClientFingerprint, fp)
+	}
+	if want := fingerprint.Generate(fingerprint.Input{IPAddress: msg.IPAddress, UserAgent: want}); msg.ClientFingerprint != want {
+		t.Errorf("fingerprint %s, want %s", msg.ClientFingerprint, want)
+	}
+}
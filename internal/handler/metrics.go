@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ingestErrors counts failed ingest requests by error type. The label is one
+// of the fixed values set in writeProcessError, so cardinality is bounded.
+var ingestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingest_errors_total",
+	Help: "Number of ingest requests that failed, by error type.",
+}, []string{"type"})
+
+// ingestRejections counts ingest requests rejected as invalid, by the rule
+// they failed. The label is a service.Reject* reason or one of the body
+// reasons in bind.go, so cardinality is bounded.
+var ingestRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingest_rejections_total",
+	Help: "Number of ingest requests rejected as invalid, by reason.",
+}, []string{"reason"})
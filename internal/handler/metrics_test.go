@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+	"go.uber.org/zap"
+)
+
+func TestRejectionsCountedByReason(t *testing.T) {
+	tests := []struct {
+		reason    string
+		body      string
+		configure func(*service.Options)
+	}{
+		{rejectEmptyBody, ``, nil},
+		{rejectMalformedBody, `{"PM":`, nil},
+		{rejectMissingField, `{}`, nil},
+		{service.RejectEmptyBatch, `{"PM":[]}`, nil},
+		{service.RejectMissingDate, `{"PM":[{"date":" ","data":"1","name":"m1"}]}`, nil},
+		{service.RejectMissingData, `{"PM":[{"date":"01/03/2024 12:00:00","data":" ","name":"m1"}]}`, nil},
+		{service.RejectMissingName, `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":" "}]}`, nil},
+		{service.RejectInvalidDate, `{"PM":[{"date":"2024-03-01","data":"1","name":"m1"}]}`,
+			func(o *service.Options) { o.RequireOrderedReadings = true }},
+		{service.RejectTooLarge, `{"PM":[{"date":"01/03/2024 12:00:00","data":"12345","name":"m1"}]}`,
+			func(o *service.Options) { o.FieldLimits.Data = 4 }},
+		{service.RejectUnordered, `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"},{"date":"01/03/2024 11:00:00","data":"2","name":"m2"}]}`,
+			func(o *service.Options) { o.RequireOrderedReadings = true }},
+		{service.RejectTooOld, `{"PM":[{"date":"01/01/2024 12:00:00","data":"1","name":"m1"}]}`,
+			func(o *service.Options) { o.MaxReadingAge = 24 * time.Hour }},
+		{service.RejectDuplicateName, `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"},{"date":"01/03/2024 12:15:00","data":"2","name":"m1"}]}`,
+			func(o *service.Options) { o.DuplicateNames = service.DuplicateNamesReject }},
+		{service.RejectUnknownMeter, `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m9"}]}`,
+			func(o *service.Options) { o.AllowedMeterNames = []string{"m1"} }},
+		{service.RejectInvalidName, `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"M 1"}]}`,
+			func(o *service.Options) { o.MeterNamePattern = regexp.MustCompile(`^[a-z0-9]+$`) }},
+		{service.RejectInvalidTenant, `{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"}]}`,
+			func(o *service.Options) { o.MultiTenancy, o.AllowedTenants = true, []string{"acme"} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			pub := &recordingPublisher{}
+			r := newTestRouter(t, pub, tt.configure)
+			before := testutil.ToFloat64(ingestRejections.WithLabelValues(tt.reason))
+			otherBefore := testutil.CollectAndCount(ingestRejections)
+
+			w := post(r, "application/json", []byte(tt.body), nil)
+			if w.Code < http.StatusBadRequest {
+				t.Fatalf("status = %d, want a rejection: %s", w.Code, w.Body)
+			}
+			if got := testutil.ToFloat64(ingestRejections.WithLabelValues(tt.reason)) - before; got != 1 {
+				t.Errorf("ingest_rejections_total{reason=%q} rose by %v, want 1", tt.reason, got)
+			}
+			if got := testutil.CollectAndCount(ingestRejections); got > otherBefore+1 {
+				t.Errorf("rejection counted under %d new labels, want at most 1", got-otherBefore)
+			}
+			if n := len(pub.published()); n != 0 {
+				t.Errorf("published %d messages for a rejected request, want 0", n)
+			}
+		})
+	}
+}
+
+func TestAcceptedRequestCountsNoRejection(t *testing.T) {
+	reasons := []string{
+		rejectEmptyBody, rejectMalformedBody, rejectMissingField, rejectBodyTooLarge, rejectBodyTimeout,
+		service.RejectEmptyBatch, service.RejectMissingDate, service.RejectMissingData, service.RejectMissingName,
+		service.RejectInvalidDate, service.RejectInvalidInterval, service.RejectUnordered, service.RejectTooOld,
+		service.RejectTooLarge, service.RejectDuplicateName, service.RejectUnknownMeter, service.RejectInvalidName,
+		service.RejectInvalidPriority, service.RejectInvalidTenant, service.RejectOther,
+	}
+	total := func() (sum float64) {
+		for _, reason := range reasons {
+			sum += testutil.ToFloat64(ingestRejections.WithLabelValues(reason))
+		}
+		return sum
+	}
+	r := newTestRouter(t, &recordingPublisher{}, nil)
+	start := total()
+
+	w := post(r, "application/json", []byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"}]}`), nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+	if got := total() - start; got != 0 {
+		t.Errorf("rejections rose by %v for an accepted request", got)
+	}
+}
+
+func TestClosedAsyncQueueAnswersShuttingDown(t *testing.T) {
+	svc := service.NewIngestService(&recordingPublisher{}, zap.NewNop(), service.Options{
+		RoutingKey:     "meter.reading.ingested",
+		DateLayout:     service.DefaultDateLayout,
+		AsyncWorkers:   1,
+		AsyncQueueSize: 4,
+	})
+	if err := svc.Close(context.Background()); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+	h := NewMeterHandler(svc, PublishFailureConfig{Status: http.StatusServiceUnavailable}, zap.NewNop())
+	r := gin.New()
+	r.POST(readingsPath, h.IngestReading)
+	before := testutil.ToFloat64(ingestErrors.WithLabelValues("shutting_down"))
+	queueFull := testutil.ToFloat64(ingestErrors.WithLabelValues("queue_full"))
+
+	w := post(r, "application/json", []byte(`{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"m1"}]}`), nil)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", w.Code, w.Body)
+	}
+	var envelope response.Envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error == nil || envelope.Error.Code != response.CodeShuttingDown {
+		t.Errorf("body = %s, want SHUTTING_DOWN", w.Body)
+	}
+	if w.Header().Get("Connection") != "close" || w.Header().Get("Retry-After") != "" {
+		t.Errorf("headers = %v, want Connection: close and no Retry-After", w.Header())
+	}
+	if got := testutil.ToFloat64(ingestErrors.WithLabelValues("shutting_down")) - before; got != 1 {
+		t.Errorf("ingest_errors_total{type=shutting_down} rose by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(ingestErrors.WithLabelValues("queue_full")) - queueFull; got != 0 {
+		t.Errorf("ingest_errors_total{type=queue_full} rose by %v, want 0", got)
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"go.uber.org/zap"
+)
+
+// AdminAuth requires "Authorization: Bearer <token>" matching the admin token
+func AdminAuth(token string, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			logging.FromContext(c.Request.Context(), logger).Warn("Admin request rejected",
+				zap.String("path", c.Request.URL.Path),
+				zap.String("client_ip", ClientIP(c)),
+			)
+			response.Abort(c, http.StatusUnauthorized, response.CodeUnauthorized,
+				"Unauthorized", "A valid admin token is required")
+			return
+		}
+		c.Next()
+	}
+}
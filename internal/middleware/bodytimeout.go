@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"go.uber.org/zap"
+)
+
+// BodyReadTimeoutError is returned by reads of a request body that was not
+// fully received within the BodyReadTimeout limit
+type BodyReadTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *BodyReadTimeoutError) Error() string {
+	return "request body not received within " + e.Timeout.String()
+}
+
+// BodyReadTimeout bounds how long the request body may take to arrive, so a
+// client trickling its body cannot hold a handler indefinitely. It sets a
+// read deadline on the connection and wraps the body so reads past it fail
+// with *BodyReadTimeoutError; readers abort with AbortBodyReadTimeout. The
+// deadline is cleared once the body has been read, so it never limits the
+// time spent handling the request afterwards. Connections without read
+// deadlines are left unbounded, which is logged once.
+func BodyReadTimeout(timeout time.Duration, logger *zap.Logger) gin.HandlerFunc {
+	var unsupported sync.Once
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+		rc := http.NewResponseController(c.Writer)
+		// Connections that do not support deadlines (e.g. in tests) are
+		// left unbounded rather than failing the request
+		if err := rc.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			unsupported.Do(func() {
+				logger.Warn("Body read timeout disabled: connection does not support read deadlines",
+					zap.Duration("timeout", timeout),
+					zap.Error(err),
+				)
+			})
+			c.Next()
+			return
+		}
+		body := &deadlineBody{ReadCloser: c.Request.Body, rc: rc, timeout: timeout}
+		c.Request.Body = body
+		c.Next()
+		// Also cleared here for bodies the handlers never read to the end.
+		// Once it fired it is kept, so the server gives up draining the
+		// rest of the body and closes the connection instead of waiting on
+		// the client
+		if !body.timedOut {
+			_ = rc.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// deadlineBody translates the connection's read deadline into a
+// *BodyReadTimeoutError and lifts the deadline once the body is consumed
+type deadlineBody struct {
+	io.ReadCloser
+	rc       *http.ResponseController
+	timeout  time.Duration
+	timedOut bool
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		b.timedOut = true
+		err = &BodyReadTimeoutError{Timeout: b.timeout}
+	case err == io.EOF:
+		_ = b.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// AbortBodyReadTimeout writes the 408 response used when a body did not
+// arrive within timeout. The connection cannot be reused after a read
+// deadline fired, so it is closed.
+func AbortBodyReadTimeout(c *gin.Context, timeout time.Duration) {
+	c.Header("Connection", "close")
+	response.Abort(c, http.StatusRequestTimeout, response.CodeBodyReadTimeout,
+		"Request timeout", "Request body must be received within "+timeout.String())
+}
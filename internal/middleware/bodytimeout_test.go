@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// bodyTimeoutRouter reads the whole body like the ingest handler, answering
+// 408 when it does not arrive within timeout
+func bodyTimeoutRouter(timeout time.Duration, logger *zap.Logger) *gin.Engine {
+	r := gin.New()
+	r.Use(BodyReadTimeout(timeout, logger))
+	r.POST("/", func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			var timeoutErr *BodyReadTimeoutError
+			if errors.As(err, &timeoutErr) {
+				AbortBodyReadTimeout(c, timeoutErr.Timeout)
+				return
+			}
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		c.Status(http.StatusAccepted)
+	})
+	return r
+}
+
+func TestBodyReadTimeoutTrickledBody(t *testing.T) {
+	srv := httptest.NewServer(bodyTimeoutRouter(100*time.Millisecond, zap.NewNop()))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Announce 100 bytes but send one every 50ms, so the body cannot arrive in time
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("status = %d, want 408", resp.StatusCode)
+	}
+	// ReadResponse turns Connection: close into resp.Close
+	if !resp.Close {
+		t.Errorf("response does not carry Connection: close")
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("read after the response = %v, want the server to close the connection", err)
+	}
+	<-done
+}
+
+func TestBodyReadTimeoutPromptBody(t *testing.T) {
+	srv := httptest.NewServer(bodyTimeoutRouter(time.Second, zap.NewNop()))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"PM":[]}`))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", resp.StatusCode)
+	}
+}
+
+func TestBodyReadTimeoutUnsupportedLoggedOnce(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	// The recorder does not support read deadlines
+	r := bodyTimeoutRouter(time.Second, zap.New(core))
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`)))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("status = %d, want 202 with the timeout disabled", w.Code)
+		}
+	}
+	if n := logs.FilterMessageSnippet("Body read timeout disabled").Len(); n != 1 {
+		t.Errorf("logged %d warnings, want 1", n)
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Client IP extraction strategies, see ClientIPResolver
+const (
+	// IPStrategyRightmostTrusted walks X-Forwarded-For from the right,
+	// skipping trusted proxies, and uses the first untrusted address
+	IPStrategyRightmostTrusted = "rightmost-trusted"
+	// IPStrategyRightmost uses the last X-Forwarded-For entry, i.e. the peer
+	// of the outermost trusted proxy
+	IPStrategyRightmost = "rightmost"
+	// IPStrategyLeftmost uses the first X-Forwarded-For entry, the address the
+	// original client claims. It is client-controlled and must not be used
+	// for anything security-relevant.
+	IPStrategyLeftmost = "leftmost"
+)
+
+// ClientIPKey is the Gin context key holding the resolved client IP
+const ClientIPKey = "client_ip"
+
+// ClientIPResolver resolves the client IP of every request with strategy and
+// stores it under ClientIPKey. Forwarded headers are only consulted when the
+// connection comes from one of trustedProxies (IPs or CIDRs); otherwise the
+// remote address is used, whatever the strategy.
+func ClientIPResolver(strategy string, trustedProxies []string) gin.HandlerFunc {
+	trusted := parseTrustedProxies(trustedProxies)
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if strategy != IPStrategyRightmostTrusted && isTrusted(trusted, c.RemoteIP()) {
+			if forwarded := forwardedFor(c.GetHeader("X-Forwarded-For"), strategy); forwarded != "" {
+				ip = forwarded
+			}
+		}
+		c.Set(ClientIPKey, ip)
+		c.Next()
+	}
+}
+
+// ClientIP returns the IP resolved by ClientIPResolver, falling back to
+// Gin's own resolution on routes without it
+func ClientIP(c *gin.Context) string {
+	if ip := c.GetString(ClientIPKey); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
+
+// forwardedFor picks the leftmost or rightmost valid address of an
+// X-Forwarded-For header, or "" if there is none
+func forwardedFor(header, strategy string) string {
+	if header == "" {
+		return ""
+	}
+	entries := strings.Split(header, ",")
+	if strategy == IPStrategyRightmost {
+		for i := len(entries) - 1; i >= 0; i-- {
+			if ip := net.ParseIP(strings.TrimSpace(entries[i])); ip != nil {
+				return ip.String()
+			}
+		}
+		return ""
+	}
+	for _, entry := range entries {
+		if ip := net.ParseIP(strings.TrimSpace(entry)); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// parseTrustedProxies turns IPs and CIDRs into networks; invalid entries are
+// skipped since the configuration has validated them already
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil {
+				bits := 128
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
+
+// isTrusted reports whether ip lies in one of nets
+func isTrusted(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// resolveIP sends a request from remoteAddr with the given forwarding
+// headers through a router configured like the server's, returning the
+// resolved client IP
+func resolveIP(t *testing.T, strategy string, trustedProxies []string, remoteAddr string, headers map[string]string) string {
+	t.Helper()
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatalf("SetTrustedProxies: %v", err)
+	}
+	r.Use(ClientIPResolver(strategy, trustedProxies))
+	r.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, ClientIP(c)) })
+
+	req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Body.String()
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	spoofed := map[string]string{"X-Forwarded-For": "198.51.100.9", "X-Real-IP": "198.51.100.9"}
+	tests := []struct {
+		name       string
+		proxies    []string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"no trusted proxies ignores forwarded headers", nil, "203.0.113.7:5000", spoofed, "203.0.113.7"},
+		{"untrusted peer ignores forwarded headers", []string{"10.0.0.0/8"}, "203.0.113.7:5000", spoofed, "203.0.113.7"},
+		{"trusted proxy CIDR is honored", []string{"10.0.0.0/8"}, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"trusted proxy IP is honored", []string{"10.1.2.3"}, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"no forwarded header falls back to the peer", []string{"10.0.0.0/8"}, "10.1.2.3:5000", nil, "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveIP(t, IPStrategyRightmostTrusted, tt.proxies, tt.remoteAddr, tt.headers); got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPStrategies(t *testing.T) {
+	trusted := []string{"10.0.0.0/8"}
+	// The client claims 1.1.1.1, its real address 198.51.100.9 reached a CDN
+	// edge 10.0.0.5, which forwarded to our load balancer 10.0.0.6
+	chain := map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.9, 10.0.0.5"}
+
+	tests := []struct {
+		name       string
+		strategy   string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{"rightmost-trusted skips trusted hops", IPStrategyRightmostTrusted, "10.0.0.6:443", chain, "198.51.100.9"},
+		{"rightmost takes the last hop", IPStrategyRightmost, "10.0.0.6:443", chain, "10.0.0.5"},
+		{"leftmost takes the claimed client", IPStrategyLeftmost, "10.0.0.6:443", chain, "1.1.1.1"},
+		{"leftmost from an untrusted peer", IPStrategyLeftmost, "203.0.113.7:443", chain, "203.0.113.7"},
+		{"rightmost from an untrusted peer", IPStrategyRightmost, "203.0.113.7:443", chain, "203.0.113.7"},
+		{"leftmost skips invalid entries", IPStrategyLeftmost, "10.0.0.6:443", map[string]string{"X-Forwarded-For": "unknown, 198.51.100.9"}, "198.51.100.9"},
+		{"rightmost skips invalid entries", IPStrategyRightmost, "10.0.0.6:443", map[string]string{"X-Forwarded-For": "198.51.100.9, _hidden"}, "198.51.100.9"},
+		{"IPv6 chain", IPStrategyRightmostTrusted, "10.0.0.6:443", map[string]string{"X-Forwarded-For": "2001:db8::1, 10.0.0.5"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveIP(t, tt.strategy, trusted, tt.remoteAddr, tt.headers); got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+)
+
+var draining atomic.Bool
+
+// BeginDrain makes RejectWhileDraining turn requests away from now on. It is
+// called as the drain starts, after the pre-shutdown delay, so load
+// balancers have already stopped routing here.
+func BeginDrain() {
+	draining.Store(true)
+}
+
+// RejectWhileDraining answers requests arriving once the drain has begun
+// with 503 SHUTTING_DOWN and Connection: close, instead of letting them start
+// a publish the shutdown is about to cut off. Requests already past it
+// finish normally.
+func RejectWhileDraining() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.Header("Connection", "close")
+			response.Abort(c, http.StatusServiceUnavailable, response.CodeShuttingDown,
+				"Service unavailable", "service is shutting down")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// StaticHeaders sets headers, canonical name to value, on every response; an
+// empty value removes the header instead. It runs before any other
+// middleware, so aborted, error and recovered responses carry the headers
+// too. A handler setting one of them later overrides it.
+func StaticHeaders(headers map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		for name, value := range headers {
+			if value == "" {
+				h.Del(name)
+			} else {
+				h.Set(name, value)
+			}
+		}
+		c.Next()
+	}
+}
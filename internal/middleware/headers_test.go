@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestStaticHeadersOnEveryResponse(t *testing.T) {
+	headers := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Strict-Transport-Security": "max-age=63072000",
+		"X-Powered-By":              "",
+	}
+	r := gin.New()
+	// Stands in for anything earlier that identifies the server
+	r.Use(func(c *gin.Context) { c.Header("X-Powered-By", "gin") })
+	r.Use(StaticHeaders(headers))
+	r.Use(Recovery(zap.NewNop()))
+	r.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/error", func(c *gin.Context) { c.AbortWithStatus(http.StatusBadRequest) })
+	r.GET("/panic", func(c *gin.Context) { panic("boom") })
+	r.GET("/override", func(c *gin.Context) {
+		c.Header("X-Frame-Options", "SAMEORIGIN")
+		c.Status(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantFrame  string
+	}{
+		{"/ok", http.StatusOK, "DENY"},
+		{"/error", http.StatusBadRequest, "DENY"},
+		{"/panic", http.StatusInternalServerError, "DENY"},
+		{"/missing", http.StatusNotFound, "DENY"},
+		{"/override", http.StatusNoContent, "SAMEORIGIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			h := w.Header()
+			if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Strict-Transport-Security") != "max-age=63072000" {
+				t.Errorf("headers %v, want the static headers", h)
+			}
+			if got := h.Get("X-Frame-Options"); got != tt.wantFrame {
+				t.Errorf("X-Frame-Options = %q, want %q", got, tt.wantFrame)
+			}
+			if _, ok := h["X-Powered-By"]; ok {
+				t.Errorf("X-Powered-By = %q, want it removed", h.Get("X-Powered-By"))
+			}
+		})
+	}
+}
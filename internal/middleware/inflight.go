@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+var requestsInFlight atomic.Int64
+
+// TrackInFlight counts the requests currently being served, so shutdown can
+// report how many it drained and how many it cut off
+func TrackInFlight() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestsInFlight.Add(1)
+		defer requestsInFlight.Add(-1)
+
+		c.Next()
+	}
+}
+
+// InFlightRequests returns the number of requests currently being served
+func InFlightRequests() int64 {
+	return requestsInFlight.Load()
+}
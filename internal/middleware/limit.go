@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+)
+
+var (
+	ingestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ingest_in_flight",
+		Help: "Number of ingest requests currently being processed.",
+	})
+	ingestSlotWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ingest_slot_wait_duration_seconds",
+		Help:    "Time ingest requests waited for a concurrency slot while the limit was reached.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+	ingestSaturatedRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ingest_saturated_rejections_total",
+		Help: "Ingest requests rejected with 503 because no concurrency slot became free.",
+	})
+)
+
+// MaxBodySize rejects request bodies larger than limit bytes with 413. The
+// limit applies to the bytes received on the wire, before any decoding. A
+// declared Content-Length over the limit is rejected up front; otherwise the
+// body is wrapped so reads fail with *http.MaxBytesError once exceeded.
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			AbortBodyTooLarge(c, limit)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// AbortBodyTooLarge writes the 413 response used when a body exceeds limit bytes
+func AbortBodyTooLarge(c *gin.Context, limit int64) {
+	response.Abort(c, http.StatusRequestEntityTooLarge, response.CodePayloadTooLarge,
+		"Payload too large", "Request body must not exceed "+strconv.FormatInt(limit, 10)+" bytes")
+}
+
+// ConcurrencyLimit bounds how many requests run the remaining handlers at
+// once. With wait zero, excess requests are rejected immediately with 503
+// and a Retry-After header instead of queueing, so overload cannot pile up
+// goroutines and clients can fail over at once. Otherwise they wait up to
+// wait for a slot, trading latency for fewer rejections, and get the 503
+// only when none frees up in time or the client goes away.
+func ConcurrencyLimit(max int, wait, retryAfter time.Duration) gin.HandlerFunc {
+	slots := make(chan struct{}, max)
+	retryAfterSec := strconv.Itoa(int(retryAfter.Seconds()))
+
+	return func(c *gin.Context) {
+		if !acquireSlot(c, slots, wait) {
+			ingestSaturatedRejections.Inc()
+			c.Header("Retry-After", retryAfterSec)
+			response.Abort(c, http.StatusServiceUnavailable, response.CodeRateLimited,
+				"Too many concurrent requests", "Server is at capacity, retry later")
+			return
+		}
+
+		ingestsInFlight.Inc()
+		defer func() {
+			ingestsInFlight.Dec()
+			<-slots
+		}()
+
+		c.Next()
+	}
+}
+
+// acquireSlot takes a slot, waiting up to wait once the limit is reached
+func acquireSlot(c *gin.Context, slots chan struct{}, wait time.Duration) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	start := time.Now()
+	defer func() { ingestSlotWait.Observe(time.Since(start).Seconds()) }()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+// RequestTimeout gives each request a context deadline of timeout. Handlers
+// must honor the request context; if one returns after the deadline without
+// writing a response, a 504 is written for it.
+func RequestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if !c.Writer.Written() && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			response.Abort(c, http.StatusGatewayTimeout, response.CodeRequestTimeout,
+				"Request timed out", "The request did not complete within "+timeout.String())
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestConcurrencyLimitHoldsUnderLoad(t *testing.T) {
+	const limit, clients = 4, 64
+
+	var running, peak atomic.Int64
+	release := make(chan struct{})
+	r := gin.New()
+	r.Use(ConcurrencyLimit(limit, 0, 3*time.Second))
+	r.POST("/ingest", func(c *gin.Context) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		c.Status(http.StatusAccepted)
+	})
+
+	codes := make(chan *httptest.ResponseRecorder, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", nil))
+			codes <- w
+		}()
+	}
+
+	// Every request beyond the limit is rejected without waiting for a slot
+	for i := 0; i < clients-limit; i++ {
+		w := <-codes
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("status = %d, want 503 while saturated", w.Code)
+		}
+		if w.Header().Get("Retry-After") != "3" {
+			t.Errorf("Retry-After = %q, want 3", w.Header().Get("Retry-After"))
+		}
+	}
+	if got := testutil.ToFloat64(ingestsInFlight); got != limit {
+		t.Errorf("in-flight gauge = %v, want %d", got, limit)
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for w := range codes {
+		if w.Code != http.StatusAccepted {
+			t.Errorf("status = %d, want 202 for an admitted request", w.Code)
+		}
+	}
+	if peak.Load() > limit {
+		t.Errorf("%d requests ran at once, want at most %d", peak.Load(), limit)
+	}
+	if got := testutil.ToFloat64(ingestsInFlight); got != 0 {
+		t.Errorf("in-flight gauge = %v after the load, want 0", got)
+	}
+}
+
+func TestConcurrencyLimitWaitsForSlot(t *testing.T) {
+	r := gin.New()
+	r.Use(ConcurrencyLimit(1, time.Second, time.Second))
+	r.POST("/ingest", func(c *gin.Context) {
+		time.Sleep(20 * time.Millisecond)
+		c.Status(http.StatusAccepted)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", nil))
+			if w.Code != http.StatusAccepted {
+				t.Errorf("status = %d, want 202 once a slot frees up", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMaxBodySize(t *testing.T) {
+	const limit = 16
+	r := gin.New()
+	r.Use(MaxBodySize(limit))
+	r.POST("/ingest", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			AbortBodyTooLarge(c, maxBytesErr.Limit)
+			return
+		}
+		c.String(http.StatusAccepted, "%d", len(body))
+	})
+
+	tests := []struct {
+		name          string
+		size          int
+		contentLength bool
+		wantCode      int
+	}{
+		{"at the limit", limit, true, http.StatusAccepted},
+		{"one byte over", limit + 1, true, http.StatusRequestEntityTooLarge},
+		{"one byte over without Content-Length", limit + 1, false, http.StatusRequestEntityTooLarge},
+		{"at the limit without Content-Length", limit, false, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(strings.Repeat("x", tt.size)))
+			if !tt.contentLength {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusRequestEntityTooLarge && !strings.Contains(w.Body.String(), "must not exceed 16 bytes") {
+				t.Errorf("body = %s, want the limit named", w.Body)
+			}
+		})
+	}
+}
+
+func TestBackpressureModesWhenSaturated(t *testing.T) {
+	tests := []struct {
+		name     string
+		wait     time.Duration // 0 for BACKPRESSURE_MODE=reject
+		wantWait bool
+	}{
+		{"reject", 0, false},
+		{"wait", 50 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			admitted := make(chan struct{})
+			r := gin.New()
+			r.Use(ConcurrencyLimit(1, tt.wait, time.Second))
+			r.POST("/ingest", func(c *gin.Context) {
+				admitted <- struct{}{}
+				<-release
+				c.Status(http.StatusAccepted)
+			})
+
+			// Saturate the only slot
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingest", nil))
+			}()
+			<-admitted
+
+			rejectedBefore := testutil.ToFloat64(ingestSaturatedRejections)
+			waitsBefore := histogramSamples(t)
+			start := time.Now()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ingest", nil))
+			elapsed := time.Since(start)
+			close(release)
+			<-done
+
+			if w.Code != http.StatusServiceUnavailable {
+				t.Fatalf("status = %d, want 503 while saturated", w.Code)
+			}
+			if tt.wantWait && elapsed < tt.wait {
+				t.Errorf("rejected after %v, want a wait of %v first", elapsed, tt.wait)
+			}
+			if !tt.wantWait && elapsed > 20*time.Millisecond {
+				t.Errorf("rejected after %v, want an immediate 503", elapsed)
+			}
+			if got := testutil.ToFloat64(ingestSaturatedRejections) - rejectedBefore; got != 1 {
+				t.Errorf("saturated rejections rose by %v, want 1", got)
+			}
+			wantWaits := uint64(0)
+			if tt.wantWait {
+				wantWaits = 1
+			}
+			if got := histogramSamples(t) - waitsBefore; got != wantWaits {
+				t.Errorf("slot wait recorded %d waits, want %d", got, wantWaits)
+			}
+		})
+	}
+}
+
+// histogramSamples returns how many slot waits were observed so far
+func histogramSamples(t *testing.T) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := ingestSlotWait.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
@@ -1,15 +1,47 @@
 package middleware
 
 import (
+	"fmt"
+	"mime"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
 	"go.uber.org/zap"
 )
 
-// RequestLogger logs HTTP requests
-func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
+// TenantIDKey is the Gin context key holding the validated tenant of a request
+const TenantIDKey = "tenant_id"
+
+// ClientFingerprintKey is the Gin context key holding the client fingerprint
+// computed for an ingest request
+const ClientFingerprintKey = "client_fingerprint"
+
+// RequestLoggerConfig configures the RequestLogger middleware
+type RequestLoggerConfig struct {
+	// ProbePaths are health/readiness routes whose successful requests are sampled
+	ProbePaths []string
+	// ProbeLogEvery logs one in every N successful probe requests; 0 skips them
+	ProbeLogEvery int
+}
+
+// RequestLogger logs one structured line per HTTP request. Failed probe
+// requests are always logged.
+func RequestLogger(logger *zap.Logger, cfg RequestLoggerConfig) gin.HandlerFunc {
+	probePaths := make(map[string]struct{}, len(cfg.ProbePaths))
+	for _, path := range cfg.ProbePaths {
+		probePaths[path] = struct{}{}
+	}
+	var probeCount atomic.Uint64
+
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
@@ -20,46 +52,121 @@ func RequestLogger(logger *zap.Logger) gin.HandlerFunc {
 		latency := time.Since(start)
 		statusCode := c.Writer.Status()
 
-		logger.Info("HTTP request",
+		if _, ok := probePaths[path]; ok && statusCode < http.StatusBadRequest {
+			if cfg.ProbeLogEvery <= 0 || (probeCount.Add(1)-1)%uint64(cfg.ProbeLogEvery) != 0 {
+				return
+			}
+		}
+
+		logging.FromContext(c.Request.Context(), logger).Info("HTTP request",
 			zap.String("method", c.Request.Method),
 			zap.String("path", path),
 			zap.String("query", query),
 			zap.Int("status", statusCode),
+			zap.Int("response_bytes", c.Writer.Size()),
 			zap.Duration("latency", latency),
-			zap.String("client_ip", c.ClientIP()),
+			zap.String("client_ip", ClientIP(c)),
 			zap.String("user_agent", c.GetHeader("User-Agent")),
+			zap.String("client_fingerprint", c.GetString(ClientFingerprintKey)),
+			zap.String("tenant_id", c.GetString(TenantIDKey)),
 		)
 	}
 }
 
-// Recovery recovers from panics
+var panicsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "panics_total",
+	Help: "Panics recovered while handling HTTP requests, by route.",
+}, []string{"route"})
+
+// Recovery recovers from panics, logging the stack trace and answering with
+// a 500 error envelope. The panic value is only echoed to the client in Gin
+// debug mode.
 func Recovery(logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				logger.Error("Panic recovered",
+				route := c.FullPath()
+				if route == "" {
+					route = "unmatched"
+				}
+				panicsTotal.WithLabelValues(route).Inc()
+				logging.FromContext(c.Request.Context(), logger).Error("Panic recovered",
 					zap.Any("error", err),
 					zap.String("path", c.Request.URL.Path),
+					zap.String("route", route),
+					zap.Stack("stack"),
 				)
-				c.AbortWithStatusJSON(500, gin.H{
-					"error": "Internal server error",
-				})
+
+				var details []string
+				if gin.IsDebugging() {
+					details = append(details, fmt.Sprint(err))
+				}
+				response.Abort(c, http.StatusInternalServerError, response.CodeInternal, "Internal server error", details...)
 			}
 		}()
 		c.Next()
 	}
 }
 
-// CORS handles Cross-Origin Resource Sharing
-func CORS() gin.HandlerFunc {
+// CORSConfig configures the CORS middleware
+type CORSConfig struct {
+	AllowedOrigins   []string // exact origins, or "*" when credentials are not allowed
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORS handles Cross-Origin Resource Sharing for whitelisted origins only.
+// Allowed origins are reflected back individually rather than wildcarded, so
+// credentialed requests never see Access-Control-Allow-Origin: *.
+func CORS(cfg CORSConfig) gin.HandlerFunc {
+	allowAny := false
+	origins := make(map[string]struct{}, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		origins[origin] = struct{}{}
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Origin")
+		_, allowed := origins[origin]
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		if !allowed && !allowAny {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if allowAny && !cfg.AllowCredentials {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
 
 		// Handle preflight OPTIONS request
-		if c.Request.Method == "OPTIONS" {
+		if preflight {
+			c.Writer.Header().Set("Access-Control-Allow-Methods", methods)
+			c.Writer.Header().Set("Access-Control-Allow-Headers", headers)
+			c.Writer.Header().Set("Access-Control-Max-Age", maxAge)
 			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
@@ -67,3 +174,20 @@ func CORS() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// RequireContentType rejects requests whose Content-Type is not one of
+// mediaTypes. Media type parameters such as charset are allowed and matching
+// is case-insensitive.
+func RequireContentType(mediaTypes ...string) gin.HandlerFunc {
+	detail := "Content-Type must be " + strings.Join(mediaTypes, " or ")
+	return func(c *gin.Context) {
+		contentType := c.GetHeader("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || !slices.ContainsFunc(mediaTypes, func(t string) bool { return strings.EqualFold(mediaType, t) }) {
+			response.Abort(c, http.StatusUnsupportedMediaType, response.CodeUnsupportedMediaType,
+				"Unsupported media type", detail)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRecoveryReturnsJSONError(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	r := gin.New()
+	r.Use(Recovery(zap.New(core)))
+	r.GET("/boom/:id", func(c *gin.Context) {
+		panic("secret connection string")
+	})
+	before := testutil.ToFloat64(panicsTotal.WithLabelValues("/boom/:id"))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/boom/1", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+	var envelope response.Envelope
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("body %q is not the error envelope: %v", w.Body, err)
+	}
+	if envelope.Success || envelope.Error == nil || envelope.Error.Code != response.CodeInternal || envelope.Error.Message == "" {
+		t.Errorf("body = %s, want an INTERNAL error", w.Body)
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("body = %s echoes the panic value outside debug mode", w.Body)
+	}
+
+	if got := testutil.ToFloat64(panicsTotal.WithLabelValues("/boom/:id")) - before; got != 1 {
+		t.Errorf("panics_total{route=/boom/:id} grew by %v, want 1", got)
+	}
+	entries := logs.FilterMessage("Panic recovered").All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d panics, want 1", len(entries))
+	}
+	if fields := entries[0].ContextMap(); fields["stack"] == "" || fields["error"] != "secret connection string" {
+		t.Errorf("log fields = %v, want the panic value and stack", fields)
+	}
+}
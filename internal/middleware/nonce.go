@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/response"
+	"github.com/septivank/energy-metering-ingest-api/tools/fingerprint"
+	"go.uber.org/zap"
+)
+
+// NonceHeader carries a client-chosen value that must be unique per request
+const NonceHeader = "X-Nonce"
+
+// maxNonceLen bounds the memory a single cache entry can take
+const maxNonceLen = 128
+
+type nonceRecord struct {
+	key       string
+	expiresAt time.Time
+}
+
+// NonceCache is a bounded in-memory set of recently seen nonces. Entries
+// expire ttl after they were first seen, and the oldest entries are evicted
+// once maxEntries is reached. Nothing is shared between instances.
+type NonceCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	records map[string]*list.Element
+	order   *list.List // oldest first; every record shares the same ttl
+}
+
+// NewNonceCache creates a nonce cache
+func NewNonceCache(ttl time.Duration, maxEntries int) *NonceCache {
+	return &NonceCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		records:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Add records key and reports whether it is new. It returns false, leaving
+// the original expiry in place, when key was already seen within the window.
+func (nc *NonceCache) Add(key string) bool {
+	now := time.Now()
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.evict(now)
+	if _, ok := nc.records[key]; ok {
+		return false
+	}
+	for nc.maxEntries > 0 && nc.order.Len() >= nc.maxEntries {
+		nc.remove(nc.order.Front())
+	}
+	nc.records[key] = nc.order.PushBack(&nonceRecord{key: key, expiresAt: now.Add(nc.ttl)})
+	return true
+}
+
+// evict drops expired records from the front of the list
+func (nc *NonceCache) evict(now time.Time) {
+	for el := nc.order.Front(); el != nil && !now.Before(el.Value.(*nonceRecord).expiresAt); el = nc.order.Front() {
+		nc.remove(el)
+	}
+}
+
+func (nc *NonceCache) remove(el *list.Element) {
+	delete(nc.records, el.Value.(*nonceRecord).key)
+	nc.order.Remove(el)
+}
+
+// RejectReplayedNonce requires an X-Nonce header and rejects a nonce already
+// seen from the same client within the cache window with 409. Requests that
+// passed VerifySignature are scoped by their signing key, which an attacker
+// cannot change, and their nonce is covered by the signature; others are
+// scoped by the client fingerprint (IP and User-Agent). It must run after
+// VerifySignature.
+func RejectReplayedNonce(cache *NonceCache, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nonce := c.GetHeader(NonceHeader)
+		if nonce == "" || len(nonce) > maxNonceLen {
+			response.Abort(c, http.StatusBadRequest, response.CodeValidationFailed,
+				"Invalid nonce", "X-Nonce header is required and must be at most 128 bytes")
+			return
+		}
+
+		scope := c.GetString(SignatureKeyIDKey)
+		if scope == "" {
+			scope = fingerprint.Generate(fingerprint.Input{
+				IPAddress: ClientIP(c),
+				UserAgent: c.Request.UserAgent(),
+			})
+		}
+		if !cache.Add(scope + "\x00" + nonce) {
+			logging.FromContext(c.Request.Context(), logger).Warn("Rejected replayed nonce",
+				zap.String("nonce", nonce),
+				zap.String("client_ip", ClientIP(c)),
+			)
+			response.Abort(c, http.StatusConflict, response.CodeReplayedRequest,
+				"Replayed request", "X-Nonce has already been used")
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// nonceRouter serves GET /ingest behind RejectReplayedNonce. A request's
+// X-Key-ID header, when set, stands in for a verified signing key.
+func nonceRouter(cache *NonceCache) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if keyID := c.GetHeader(SignatureKeyIDHeader); keyID != "" {
+			c.Set(SignatureKeyIDKey, keyID)
+		}
+	})
+	r.Use(RejectReplayedNonce(cache, zap.NewNop()))
+	r.GET("/ingest", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+func sendNonce(r http.Handler, nonce, remoteAddr, keyID string) int {
+	req := httptest.NewRequest(http.MethodGet, "/ingest", nil)
+	req.RemoteAddr = remoteAddr
+	if nonce != "" {
+		req.Header.Set(NonceHeader, nonce)
+	}
+	if keyID != "" {
+		req.Header.Set(SignatureKeyIDHeader, keyID)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRejectReplayedNonce(t *testing.T) {
+	r := nonceRouter(NewNonceCache(time.Minute, 100))
+
+	steps := []struct {
+		name       string
+		nonce      string
+		remoteAddr string
+		keyID      string
+		want       int
+	}{
+		{"first use", "n-1", "203.0.113.7:1000", "", http.StatusOK},
+		{"replay", "n-1", "203.0.113.7:1000", "", http.StatusConflict},
+		{"same nonce from another client", "n-1", "203.0.113.8:1000", "", http.StatusOK},
+		{"signed first use", "n-1", "203.0.113.7:1000", "key-1", http.StatusOK},
+		{"signed replay from another address", "n-1", "198.51.100.1:1000", "key-1", http.StatusConflict},
+		{"same nonce under another key", "n-1", "203.0.113.7:1000", "key-2", http.StatusOK},
+		{"missing nonce", "", "203.0.113.7:1000", "", http.StatusBadRequest},
+		{"nonce at the length limit", strings.Repeat("n", maxNonceLen), "203.0.113.7:1000", "", http.StatusOK},
+		{"nonce over the length limit", strings.Repeat("n", maxNonceLen+1), "203.0.113.7:1000", "", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		if got := sendNonce(r, step.nonce, step.remoteAddr, step.keyID); got != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+func TestNonceCacheExpiry(t *testing.T) {
+	nc := NewNonceCache(20*time.Millisecond, 100)
+	if !nc.Add("n") {
+		t.Fatal("a new nonce was reported as seen")
+	}
+	if nc.Add("n") {
+		t.Fatal("a nonce was accepted twice within its window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !nc.Add("n") {
+		t.Error("a nonce was still rejected after its window")
+	}
+}
+
+func TestNonceCacheEvictsOldest(t *testing.T) {
+	nc := NewNonceCache(time.Minute, 2)
+	nc.Add("a")
+	nc.Add("b")
+	nc.Add("c") // evicts a
+	if !nc.Add("a") {
+		t.Error("the oldest nonce was not evicted at capacity")
+	}
+	if nc.Add("c") {
+		t.Error("a recent nonce was evicted")
+	}
+}
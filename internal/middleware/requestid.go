@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/tools/requestid"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the ID assigned to every request back to the client
+const RequestIDHeader = "X-Request-ID"
+
+// RequestContext assigns every request an ID, returned in RequestIDHeader,
+// and stores it in the request context with a logger carrying it as
+// request_id. Everything logging through logging.FromContext while handling
+// the request, down to the publisher, carries the field, so one search on
+// the ID returns the whole request. For ingest requests the ID is also the
+// published request_id.
+//
+// IDs are generated in format (requestid.FormatUUID or FormatULID). A
+// client-supplied RequestIDHeader is kept only when it is a well-formed ID in
+// that format, so IDs from upstream proxies can be traced end to end without
+// letting clients inject arbitrary strings into logs and messages; any other
+// value is replaced with a generated ID and logged.
+func RequestContext(logger *zap.Logger, format string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestid.Valid(format, requestID) {
+			if requestID != "" {
+				logger.Info("Replaced malformed client request ID",
+					zap.String("client_request_id", truncateID(requestID)),
+					zap.String("format", format),
+				)
+			}
+			requestID = requestid.New(format)
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		ctx := logging.WithRequestID(c.Request.Context(), requestID)
+		ctx = logging.WithLogger(ctx, logger.With(zap.String("request_id", requestID)))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// maxLoggedIDLen bounds how much of a rejected client request ID is logged
+const maxLoggedIDLen = 64
+
+// truncateID shortens a rejected client request ID for logging
+func truncateID(id string) string {
+	if len(id) <= maxLoggedIDLen {
+		return id
+	}
+	return id[:maxLoggedIDLen] + truncatedMarker
+}
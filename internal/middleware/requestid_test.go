@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/tools/requestid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestContextID(t *testing.T) {
+	const (
+		clientUUID = "550e8400-e29b-41d4-a716-446655440000"
+		clientULID = "01JA2X3Y4Z5A6B7C8D9E0FGHJK"
+	)
+	tests := []struct {
+		name         string
+		format       string
+		clientID     string
+		wantKept     bool // the client ID becomes request_id
+		wantReplaced bool // logged as a malformed client ID
+	}{
+		{"uuid without client ID", requestid.FormatUUID, "", false, false},
+		{"ulid without client ID", requestid.FormatULID, "", false, false},
+		{"valid client uuid is kept", requestid.FormatUUID, clientUUID, true, false},
+		{"valid client ulid is kept", requestid.FormatULID, clientULID, true, false},
+		{"ulid in uuid format", requestid.FormatUUID, clientULID, false, true},
+		{"uuid in ulid format", requestid.FormatULID, clientUUID, false, true},
+		{"arbitrary string", requestid.FormatUUID, "abc\nrequest_id=forged", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+			var ctxID string
+			r := gin.New()
+			r.Use(RequestContext(zap.New(core), tt.format))
+			r.GET("/", func(c *gin.Context) {
+				ctxID = logging.RequestID(c.Request.Context())
+				logging.FromContext(c.Request.Context(), zap.NewNop()).Info("handled")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.clientID != "" {
+				req.Header.Set(RequestIDHeader, tt.clientID)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if !requestid.Valid(tt.format, id) {
+				t.Fatalf("%s = %q, want a well-formed %s", RequestIDHeader, id, tt.format)
+			}
+			if kept := id == tt.clientID; kept != tt.wantKept {
+				t.Errorf("%s = %q with client ID %q, want kept %t", RequestIDHeader, id, tt.clientID, tt.wantKept)
+			}
+			if ctxID != id {
+				t.Errorf("context request ID = %q, want %q", ctxID, id)
+			}
+
+			handled := logs.FilterMessage("handled").All()
+			if len(handled) != 1 {
+				t.Fatalf("logged %d handler lines, want 1", len(handled))
+			}
+			if got := handled[0].ContextMap()["request_id"]; got != id {
+				t.Errorf("request_id = %v, want %q", got, id)
+			}
+			if got := logs.FilterMessage("Replaced malformed client request ID").Len(); (got == 1) != tt.wantReplaced {
+				t.Errorf("logged %d replaced client IDs, want replaced %t", got, tt.wantReplaced)
+			}
+		})
+	}
+}
+
+func TestRequestContextGeneratesUniqueIDs(t *testing.T) {
+	r := gin.New()
+	r.Use(RequestContext(zap.NewNop(), requestid.FormatULID))
+	r.GET("/", func(c *gin.Context) {})
+
+	seen := make(map[string]bool)
+	for range 100 {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		id := w.Header().Get(RequestIDHeader)
+		if seen[id] {
+			t.Fatalf("request ID %q assigned twice", id)
+		}
+		seen[id] = true
+	}
+}
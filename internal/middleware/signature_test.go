@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// sign returns the X-Signature value for body signed at timestamp
+func sign(secret, timestamp, nonce, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	if nonce != "" {
+		mac.Write([]byte(nonce + "."))
+	}
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedRouter serves POST /ingest behind VerifySignature, echoing the body
+// the handler receives
+func signedRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(VerifySignature(SignatureConfig{
+		Keys:    map[string]string{"collector-1": "s3cret"},
+		MaxSkew: 5 * time.Minute,
+	}, zap.NewNop()))
+	r.POST("/ingest", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, "%s|%s", c.GetString(SignatureKeyIDKey), body)
+	})
+	return r
+}
+
+func TestVerifySignature(t *testing.T) {
+	const body = `{"PM":[{"Name":"m","Date":"d","Data":"1"}]}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		keyID     string
+		timestamp string
+		signature string
+		body      string
+		wantCode  int
+	}{
+		{"valid", "collector-1", now, sign("s3cret", now, "", body), body, http.StatusOK},
+		{"tampered body", "collector-1", now, sign("s3cret", now, "", body), strings.Replace(body, `"1"`, `"9"`, 1), http.StatusUnauthorized},
+		{"wrong secret", "collector-1", now, sign("other", now, "", body), body, http.StatusUnauthorized},
+		{"unknown key", "collector-2", now, sign("s3cret", now, "", body), body, http.StatusUnauthorized},
+		{"replayed outside skew", "collector-1", stale, sign("s3cret", stale, "", body), body, http.StatusUnauthorized},
+		{"timestamp swapped on replay", "collector-1", now, sign("s3cret", stale, "", body), body, http.StatusUnauthorized},
+		{"malformed timestamp", "collector-1", "yesterday", sign("s3cret", "yesterday", "", body), body, http.StatusUnauthorized},
+		{"malformed signature", "collector-1", now, "sha256=zz", body, http.StatusUnauthorized},
+	}
+	router := signedRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(tt.body))
+			req.Header.Set(SignatureKeyIDHeader, tt.keyID)
+			req.Header.Set(SignatureTimestampHeader, tt.timestamp)
+			req.Header.Set(SignatureHeader, tt.signature)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+			if tt.wantCode == http.StatusOK && w.Body.String() != "collector-1|"+body {
+				t.Errorf("handler saw %q, want the key ID and the restored body", w.Body)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureCoversNonce(t *testing.T) {
+	const body = `{}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	router := signedRouter()
+
+	for _, tt := range []struct {
+		name     string
+		signed   string
+		sent     string
+		wantCode int
+	}{
+		{"signed nonce", "n-1", "n-1", http.StatusOK},
+		{"swapped nonce", "n-1", "n-2", http.StatusUnauthorized},
+		{"nonce added after signing", "", "n-1", http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+			req.Header.Set(SignatureKeyIDHeader, "collector-1")
+			req.Header.Set(SignatureTimestampHeader, now)
+			req.Header.Set(SignatureHeader, sign("s3cret", now, tt.signed, body))
+			req.Header.Set(NonceHeader, tt.sent)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// truncatedMarker ends a User-Agent cut by LimitUserAgent
+const truncatedMarker = "..."
+
+// LimitUserAgent truncates a User-Agent header longer than maxBytes to
+// maxBytes, the last of which are truncatedMarker. The header itself is
+// replaced, so the published message, the client fingerprint, nonce scoping
+// and the logs all see the same capped value and an oversized header cannot
+// inflate any of them. It must run before anything reading the header.
+func LimitUserAgent(maxBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ua := c.Request.Header.Get("User-Agent"); len(ua) > maxBytes {
+			c.Request.Header.Set("User-Agent", truncateUserAgent(ua, maxBytes))
+		}
+		c.Next()
+	}
+}
+
+// truncateUserAgent cuts ua to at most maxBytes including truncatedMarker,
+// without splitting a multi-byte character
+func truncateUserAgent(ua string, maxBytes int) string {
+	cut := max(maxBytes-len(truncatedMarker), 0)
+	for cut > 0 && !utf8.RuneStart(ua[cut]) {
+		cut--
+	}
+	return ua[:cut] + truncatedMarker
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		max  int
+		want string
+	}{
+		{"ascii", strings.Repeat("a", 20), 10, "aaaaaaa..."},
+		// "é" is two bytes; cutting at byte 7 would split the fourth one
+		{"multi-byte", strings.Repeat("é", 10), 10, "ééé..."},
+		{"limit below the marker", "collector/1.0", 2, "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateUserAgent(tt.ua, tt.max)
+			if got != tt.want {
+				t.Errorf("truncateUserAgent = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateUserAgent = %q, not valid UTF-8", got)
+			}
+		})
+	}
+}
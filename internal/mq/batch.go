@@ -0,0 +1,157 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Confirm modes select how a sequence of messages waits for broker confirms
+const (
+	// ConfirmModeSync publishes each message and waits for its confirm before
+	// publishing the next
+	ConfirmModeSync = "sync"
+	// ConfirmModeBatch publishes every message of a batch first and then
+	// waits once for all of their confirms
+	ConfirmModeBatch = "batch"
+)
+
+// BatchMessage is one message of a PublishBatch call
+type BatchMessage struct {
+	RoutingKey string
+	// Body is published as is; it is compressed when compression is enabled
+	Body []byte
+	Opts PublishOptions
+}
+
+// BatchError reports the messages of a batch the broker nacked, or returned
+// as unroutable under mandatory publishing. Messages not listed in Failed
+// were confirmed.
+type BatchError struct {
+	Failed []int // indexes into the batch, ascending
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d message(s) of the batch not acknowledged or returned by broker (indexes %v)", len(e.Failed), e.Failed)
+}
+
+// PublishBatch publishes msgs on one channel and then waits for all of their
+// confirms at once, so a batch costs one confirm round-trip instead of one
+// per message. A partial nack returns a *BatchError naming the failed
+// messages; any other error means no message can be assumed confirmed. The
+// batch is not retried or spooled, so the caller decides what to resend.
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []BatchMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	if p.failFastWhenBlocked {
+		if err := p.blockedErr(); err != nil {
+			return err
+		}
+	}
+
+	contentEncoding := ""
+	if p.compress {
+		contentEncoding = "gzip"
+		compressed := make([]BatchMessage, len(msgs))
+		for i, msg := range msgs {
+			body, err := gzipBody(msg.Body, p.compressionLevel)
+			if err != nil {
+				return err
+			}
+			msg.Body = body
+			compressed[i] = msg
+		}
+		msgs = compressed
+	}
+
+	pc, err := p.checkout(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = p.publishBatchOn(ctx, pc, msgs, contentEncoding)
+	p.checkin(pc, err == nil)
+	return err
+}
+
+// publishBatchOn publishes every copy of every message on pc, then waits for
+// all confirms and maps nacked delivery tags back to message indexes
+func (p *Publisher) publishBatchOn(ctx context.Context, pc *pooledChannel, msgs []BatchMessage, contentEncoding string) error {
+	pc.tracker.reset()
+
+	targets := append([]Target{{Exchange: p.exchange}}, p.additionalTargets...)
+	index := make(map[uint64]int, len(msgs)*len(targets))
+	for i, msg := range msgs {
+		for _, target := range targets {
+			key := msg.RoutingKey
+			if target.RoutingKey != "" {
+				key = target.RoutingKey
+			}
+
+			tag := pc.ch.GetNextPublishSeqNo()
+			var headers amqp.Table
+			if p.mandatory {
+				headers = withPublishTag(nil, tag)
+			}
+			err := pc.ch.PublishWithContext(
+				ctx,
+				target.Exchange,
+				key,
+				p.mandatory,
+				false, // immediate
+				amqp.Publishing{
+					DeliveryMode:    p.deliveryMode,
+					Priority:        msg.Opts.Priority,
+					Expiration:      p.expiration,
+					ContentType:     p.contentType,
+					ContentEncoding: contentEncoding,
+					Headers:         headers,
+					Body:            msg.Body,
+					Timestamp:       time.Now(),
+				},
+			)
+			if err != nil {
+				if p.mode == modeTx {
+					pc.ch.TxRollback()
+				}
+				return fmt.Errorf("batch publish to exchange %q failed at message %d: %w", target.Exchange, i, err)
+			}
+			pc.tracker.track(tag)
+			index[tag] = i
+		}
+	}
+
+	switch p.mode {
+	case modeNoConfirm:
+		return nil
+	case modeTx:
+		// A transaction commits or fails as a whole
+		if err := pc.ch.TxCommit(); err != nil {
+			return fmt.Errorf("%w: transaction commit failed: %w", ErrDeliveryUnknown, err)
+		}
+		return nil
+	}
+
+	if err := deliveryOutcome(p.waitConfirms(ctx, pc, msgs[0].RoutingKey)); errors.Is(err, ErrDeliveryUnknown) {
+		return err
+	}
+	failedTags := pc.tracker.failed()
+	if len(failedTags) == 0 {
+		return nil
+	}
+
+	// A message failed if any of its copies was nacked or returned; failed
+	// tags are in publish order, so the indexes come out ascending
+	failed := make([]int, 0, len(failedTags))
+	for _, tag := range failedTags {
+		i := index[tag]
+		if len(failed) == 0 || failed[len(failed)-1] != i {
+			failed = append(failed, i)
+		}
+	}
+	return &BatchError{Failed: failed}
+}
@@ -0,0 +1,70 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// batchOf returns n messages with bodies {"n":0} to {"n":n-1}
+func batchOf(n int) []BatchMessage {
+	msgs := make([]BatchMessage, n)
+	for i := range msgs {
+		msgs[i] = BatchMessage{RoutingKey: "meter", Body: []byte(fmt.Sprintf(`{"n":%d}`, i))}
+	}
+	return msgs
+}
+
+func TestPublishBatchReportsNackedMessages(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(func(m fakeMessage) fakeAction {
+		if string(m.Body) == `{"n":1}` || string(m.Body) == `{"n":3}` {
+			return fakeNack
+		}
+		return fakeAck
+	})
+	p := newTestPublisher(t, b, nil)
+
+	err := p.PublishBatch(context.Background(), batchOf(5))
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("PublishBatch = %v, want a *BatchError", err)
+	}
+	if !slices.Equal(batchErr.Failed, []int{1, 3}) {
+		t.Errorf("failed indexes = %v, want [1 3]", batchErr.Failed)
+	}
+	if len(b.messages()) != 3 {
+		t.Errorf("broker routed %d messages, want the 3 acked ones", len(b.messages()))
+	}
+}
+
+// BenchmarkConfirmModes publishes 100 messages per op, waiting for each
+// confirm in turn (sync) or once for all of them (batch)
+func BenchmarkConfirmModes(b *testing.B) {
+	msgs := batchOf(100)
+	for _, mode := range []string{ConfirmModeSync, ConfirmModeBatch} {
+		b.Run(mode, func(b *testing.B) {
+			broker := newFakeBroker(b)
+			broker.setAckDelay(50 * time.Microsecond)
+			p := newTestPublisher(b, broker, func(o *Options) { o.PoolSize = 1 })
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if mode == ConfirmModeBatch {
+					if err := p.PublishBatch(context.Background(), msgs); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				for _, m := range msgs {
+					if err := p.publishWithConfirm(context.Background(), m.RoutingKey, m.Body, m.Opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
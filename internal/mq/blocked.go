@@ -0,0 +1,61 @@
+package mq
+
+import (
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// ErrBlocked is returned while the broker blocks a publisher connection. The
+// broker does so on a memory or disk alarm, and publishes on a blocked
+// connection hang until the confirm timeout instead of failing.
+var ErrBlocked = errors.New("broker is blocking publishes")
+
+// watchBlocked tracks the blocked state of conn until the connection closes
+func (p *Publisher) watchBlocked(conn *amqp.Connection, notify <-chan amqp.Blocking) {
+	for b := range notify {
+		p.blockedMu.Lock()
+		if b.Active {
+			p.blocked[conn] = b.Reason
+		} else {
+			delete(p.blocked, conn)
+		}
+		p.updateBlockedGauge()
+		p.blockedMu.Unlock()
+
+		if b.Active {
+			p.logger.Warn("RabbitMQ blocked the publisher connection", zap.String("reason", b.Reason))
+		} else {
+			p.logger.Info("RabbitMQ unblocked the publisher connection")
+		}
+	}
+
+	// The notification channel is closed with the connection
+	p.blockedMu.Lock()
+	delete(p.blocked, conn)
+	p.updateBlockedGauge()
+	p.blockedMu.Unlock()
+}
+
+// updateBlockedGauge must be called with blockedMu held
+func (p *Publisher) updateBlockedGauge() {
+	if len(p.blocked) > 0 {
+		connectionBlocked.Set(1)
+	} else {
+		connectionBlocked.Set(0)
+	}
+}
+
+// blockedErr returns an error wrapping ErrBlocked while any connection is
+// blocked, and nil otherwise
+func (p *Publisher) blockedErr() error {
+	p.blockedMu.Lock()
+	defer p.blockedMu.Unlock()
+
+	for _, reason := range p.blocked {
+		return fmt.Errorf("%w: %s", ErrBlocked, reason)
+	}
+	return nil
+}
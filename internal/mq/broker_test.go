@@ -0,0 +1,842 @@
+package mq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// fakeBroker is a minimal in-process AMQP 0-9-1 server covering what the
+// publisher uses: the connection handshake, channels in confirm or tx mode,
+// topology declarations and basic.publish. How each publish is answered is
+// decided by onPublish, so tests can nack, return, withhold or fail them.
+type fakeBroker struct {
+	t         testing.TB
+	addr      string
+	tlsConfig *tls.Config
+
+	mu        sync.Mutex
+	ln        net.Listener
+	conns     map[*fakeConn]struct{}
+	published []fakeMessage
+	returned  []fakeMessage
+	dials     int
+	closed    int // channels closed by the client
+	onPublish func(fakeMessage) fakeAction
+	ackDelay  time.Duration
+	missing   map[string]bool       // exchanges a passive declare reports as absent
+	queues    map[string]amqp.Table // declared queues and their arguments
+}
+
+// fakeMessage is a message as received by the fake broker
+type fakeMessage struct {
+	Exchange        string
+	RoutingKey      string
+	Mandatory       bool
+	ContentType     string
+	ContentEncoding string
+	Headers         amqp.Table
+	DeliveryMode    uint8
+	Priority        uint8
+	Expiration      string
+	Body            []byte
+	Tag             uint64 // delivery tag on confirm channels, 0 otherwise
+}
+
+// fakeAction is how the fake broker answers a publish
+type fakeAction int
+
+const (
+	fakeAck             fakeAction = iota // route and ack
+	fakeNack                              // nack without routing
+	fakeReturn                            // basic.return, then ack, as for an unroutable mandatory publish
+	fakeWithhold                          // route but never confirm
+	fakeCloseChannel                      // close the channel instead of confirming
+	fakeCloseConnection                   // drop the connection instead of confirming
+)
+
+// newFakeBroker starts a fake broker on a random local port; it is stopped
+// when the test ends
+func newFakeBroker(t testing.TB) *fakeBroker {
+	t.Helper()
+	return newFakeBrokerTLS(t, nil)
+}
+
+// newFakeBrokerTLS starts a fake broker that, with tlsConfig set, only
+// accepts TLS connections
+func newFakeBrokerTLS(t testing.TB, tlsConfig *tls.Config) *fakeBroker {
+	t.Helper()
+	b := &fakeBroker{t: t, tlsConfig: tlsConfig, conns: make(map[*fakeConn]struct{}), missing: make(map[string]bool)}
+	b.listen("127.0.0.1:0")
+	t.Cleanup(b.stop)
+	return b
+}
+
+// url returns the AMQP URL of the broker
+func (b *fakeBroker) url() string {
+	scheme := "amqp"
+	if b.tlsConfig != nil {
+		scheme = "amqps"
+	}
+	return fmt.Sprintf("%s://guest:guest@%s/", scheme, b.addr)
+}
+
+func (b *fakeBroker) listen(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		b.t.Fatalf("fake broker listen: %v", err)
+	}
+	if b.tlsConfig != nil {
+		ln = tls.NewListener(ln, b.tlsConfig)
+	}
+	b.mu.Lock()
+	b.ln = ln
+	b.addr = ln.Addr().String()
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(nc)
+		}
+	}()
+}
+
+// stop closes the listener and every connection, like a broker going down
+func (b *fakeBroker) stop() {
+	b.mu.Lock()
+	ln := b.ln
+	b.ln = nil
+	conns := b.conns
+	b.conns = make(map[*fakeConn]struct{})
+	b.mu.Unlock()
+
+	if ln != nil {
+		ln.Close()
+	}
+	for c := range conns {
+		c.nc.Close()
+	}
+}
+
+// restart brings a stopped broker back on the same address
+func (b *fakeBroker) restart() {
+	b.listen(b.addr)
+}
+
+// dropConnections closes every client connection but keeps listening
+func (b *fakeBroker) dropConnections() {
+	b.mu.Lock()
+	conns := b.conns
+	b.conns = make(map[*fakeConn]struct{})
+	b.mu.Unlock()
+	for c := range conns {
+		c.nc.Close()
+	}
+}
+
+// setOnPublish replaces the publish handler; nil acks everything
+func (b *fakeBroker) setOnPublish(fn func(fakeMessage) fakeAction) {
+	b.mu.Lock()
+	b.onPublish = fn
+	b.mu.Unlock()
+}
+
+// setAckDelay delays every confirm by d. Confirms on one channel are sent
+// one after the other, so a batch of n publishes takes n*d to confirm, while
+// channels proceed independently.
+func (b *fakeBroker) setAckDelay(d time.Duration) {
+	b.mu.Lock()
+	b.ackDelay = d
+	b.mu.Unlock()
+}
+
+// messages returns the messages routed so far
+func (b *fakeBroker) messages() []fakeMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]fakeMessage(nil), b.published...)
+}
+
+// returnedMessages returns the messages sent back with basic.return so far
+func (b *fakeBroker) returnedMessages() []fakeMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]fakeMessage(nil), b.returned...)
+}
+
+// dialCount returns how many connections were opened
+func (b *fakeBroker) dialCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dials
+}
+
+// declaredQueue returns the arguments queue was declared with, and whether
+// it was declared
+func (b *fakeBroker) declaredQueue(queue string) (amqp.Table, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	args, ok := b.queues[queue]
+	return args, ok
+}
+
+// closedChannels returns how many channels the client has closed
+func (b *fakeBroker) closedChannels() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// openChannels returns the number of channels open across all connections
+func (b *fakeBroker) openChannels() int {
+	b.mu.Lock()
+	conns := make([]*fakeConn, 0, len(b.conns))
+	for c := range b.conns {
+		conns = append(conns, c)
+	}
+	b.mu.Unlock()
+
+	n := 0
+	for _, c := range conns {
+		c.mu.Lock()
+		n += len(c.channels)
+		c.mu.Unlock()
+	}
+	return n
+}
+
+func (b *fakeBroker) action(m fakeMessage) (fakeAction, time.Duration) {
+	b.mu.Lock()
+	fn, delay := b.onPublish, b.ackDelay
+	b.mu.Unlock()
+	if fn == nil {
+		return fakeAck, delay
+	}
+	return fn(m), delay
+}
+
+func (b *fakeBroker) route(m fakeMessage) {
+	b.mu.Lock()
+	b.published = append(b.published, m)
+	b.mu.Unlock()
+}
+
+// fakeConn is one client connection to the fake broker
+type fakeConn struct {
+	broker *fakeBroker
+	nc     net.Conn
+	r      *bufio.Reader
+
+	wmu      sync.Mutex // serializes frame writes
+	mu       sync.Mutex
+	channels map[uint16]*fakeChannel
+}
+
+// fakeChannel is the state of one open channel
+type fakeChannel struct {
+	confirm bool
+	tx      bool
+	closing bool // closed by the broker, awaiting close-ok
+	nextTag uint64
+	pending *fakeMessage // publish awaiting its content
+	size    uint64
+	txBuf   []fakeMessage
+	ackAt   time.Time // when the last delayed confirm is due
+}
+
+func (b *fakeBroker) serve(nc net.Conn) {
+	c := &fakeConn{broker: b, nc: nc, r: bufio.NewReader(nc), channels: make(map[uint16]*fakeChannel)}
+	b.mu.Lock()
+	if b.ln == nil {
+		b.mu.Unlock()
+		nc.Close()
+		return
+	}
+	b.conns[c] = struct{}{}
+	b.dials++
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		nc.Close()
+	}()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return
+	}
+	var start fakeWriter
+	start.octet(0)
+	start.octet(9)
+	start.table(nil)
+	start.longstr("PLAIN")
+	start.longstr("en_US")
+	c.method(0, 10, 10, start.Bytes())
+
+	for {
+		typ, channel, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch typ {
+		case 1:
+			if !c.handleMethod(channel, payload) {
+				return
+			}
+		case 2:
+			c.handleHeader(channel, payload)
+		case 3:
+			c.handleBody(channel, payload)
+		}
+	}
+}
+
+func (c *fakeConn) readFrame() (byte, uint16, []byte, error) {
+	var hdr [7]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[3:])
+	payload := make([]byte, size+1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	if payload[size] != 0xCE {
+		return 0, 0, nil, errors.New("bad frame end")
+	}
+	return hdr[0], binary.BigEndian.Uint16(hdr[1:3]), payload[:size], nil
+}
+
+func (c *fakeConn) frame(typ byte, channel uint16, payload []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var buf bytes.Buffer
+	buf.WriteByte(typ)
+	binary.Write(&buf, binary.BigEndian, channel)
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+	buf.WriteByte(0xCE)
+	c.nc.Write(buf.Bytes())
+}
+
+func (c *fakeConn) method(channel, class, method uint16, args []byte) {
+	var w fakeWriter
+	w.short(class)
+	w.short(method)
+	w.Write(args)
+	c.frame(1, channel, w.Bytes())
+}
+
+func (c *fakeConn) channel(id uint16) *fakeChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels[id]
+}
+
+// closeChannel closes a channel from the broker side with code
+func (c *fakeConn) closeChannel(id uint16, code uint16, text string, class, method uint16) {
+	if ch := c.channel(id); ch != nil {
+		ch.closing = true
+	}
+	var w fakeWriter
+	w.short(code)
+	w.shortstr(text)
+	w.short(class)
+	w.short(method)
+	c.method(id, 20, 40, w.Bytes())
+}
+
+// handleMethod answers a method frame and reports whether to keep serving
+func (c *fakeConn) handleMethod(channel uint16, payload []byte) bool {
+	r := fakeReader{b: payload}
+	class, method := r.short(), r.short()
+
+	if ch := c.channel(channel); ch != nil && ch.closing && !(class == 20 && method == 41) {
+		return true // frames racing a broker-side close are discarded
+	}
+
+	switch {
+	case class == 10 && method == 11: // connection.start-ok
+		var w fakeWriter
+		w.short(2047)
+		w.long(131072)
+		w.short(0)
+		c.method(0, 10, 30, w.Bytes())
+	case class == 10 && method == 31: // connection.tune-ok
+	case class == 10 && method == 40: // connection.open
+		var w fakeWriter
+		w.shortstr("")
+		c.method(0, 10, 41, w.Bytes())
+	case class == 10 && method == 50: // connection.close
+		c.method(0, 10, 51, nil)
+		return false
+	case class == 10 && method == 51: // connection.close-ok
+		return false
+
+	case class == 20 && method == 10: // channel.open
+		c.mu.Lock()
+		c.channels[channel] = &fakeChannel{}
+		c.mu.Unlock()
+		var w fakeWriter
+		w.longstr("")
+		c.method(channel, 20, 11, w.Bytes())
+	case class == 20 && method == 40: // channel.close
+		c.mu.Lock()
+		delete(c.channels, channel)
+		c.mu.Unlock()
+		c.broker.mu.Lock()
+		c.broker.closed++
+		c.broker.mu.Unlock()
+		c.method(channel, 20, 41, nil)
+	case class == 20 && method == 41: // channel.close-ok
+		c.mu.Lock()
+		delete(c.channels, channel)
+		c.mu.Unlock()
+
+	case class == 40 && method == 10: // exchange.declare
+		r.short()
+		name := r.shortstr()
+		r.shortstr()
+		bits := r.octet()
+		passive, noWait := bits&1 != 0, bits&16 != 0
+		c.broker.mu.Lock()
+		missing := c.broker.missing[name]
+		c.broker.mu.Unlock()
+		if passive && missing {
+			c.closeChannel(channel, 404, "NOT_FOUND - no exchange '"+name+"'", 40, 10)
+			return true
+		}
+		if !noWait {
+			c.method(channel, 40, 11, nil)
+		}
+	case class == 50 && method == 10: // queue.declare
+		r.short()
+		name := r.shortstr()
+		r.octet()
+		args := r.table()
+		c.broker.mu.Lock()
+		if c.broker.queues == nil {
+			c.broker.queues = make(map[string]amqp.Table)
+		}
+		c.broker.queues[name] = args
+		c.broker.mu.Unlock()
+		var w fakeWriter
+		w.shortstr(name)
+		w.long(0)
+		w.long(0)
+		c.method(channel, 50, 11, w.Bytes())
+	case class == 50 && method == 20: // queue.bind
+		c.method(channel, 50, 21, nil)
+	case class == 60 && method == 10: // basic.qos
+		c.method(channel, 60, 11, nil)
+
+	case class == 85 && method == 10: // confirm.select
+		if ch := c.channel(channel); ch != nil {
+			ch.confirm = true
+		}
+		if r.octet()&1 == 0 {
+			c.method(channel, 85, 11, nil)
+		}
+	case class == 90 && method == 10: // tx.select
+		if ch := c.channel(channel); ch != nil {
+			ch.tx = true
+		}
+		c.method(channel, 90, 11, nil)
+	case class == 90 && method == 20: // tx.commit
+		return c.commit(channel)
+	case class == 90 && method == 30: // tx.rollback
+		if ch := c.channel(channel); ch != nil {
+			ch.txBuf = nil
+		}
+		c.method(channel, 90, 31, nil)
+
+	case class == 60 && method == 40: // basic.publish
+		r.short()
+		m := &fakeMessage{Exchange: r.shortstr(), RoutingKey: r.shortstr()}
+		m.Mandatory = r.octet()&1 != 0
+		if ch := c.channel(channel); ch != nil {
+			ch.pending = m
+		}
+	}
+	return true
+}
+
+func (c *fakeConn) handleHeader(channel uint16, payload []byte) {
+	ch := c.channel(channel)
+	if ch == nil || ch.pending == nil {
+		return
+	}
+	r := fakeReader{b: payload}
+	r.short() // class
+	r.short() // weight
+	ch.size = r.longlong()
+	flags := r.short()
+	m := ch.pending
+	if flags&0x8000 != 0 {
+		m.ContentType = r.shortstr()
+	}
+	if flags&0x4000 != 0 {
+		m.ContentEncoding = r.shortstr()
+	}
+	if flags&0x2000 != 0 {
+		m.Headers = r.table()
+	}
+	if flags&0x1000 != 0 {
+		m.DeliveryMode = r.octet()
+	}
+	if flags&0x0800 != 0 {
+		m.Priority = r.octet()
+	}
+	for _, bit := range []uint16{0x0400, 0x0200} {
+		if flags&bit != 0 {
+			r.shortstr()
+		}
+	}
+	if flags&0x0100 != 0 {
+		m.Expiration = r.shortstr()
+	}
+	if ch.size == 0 {
+		c.published(channel, ch)
+	}
+}
+
+func (c *fakeConn) handleBody(channel uint16, payload []byte) {
+	ch := c.channel(channel)
+	if ch == nil || ch.pending == nil {
+		return
+	}
+	ch.pending.Body = append(ch.pending.Body, payload...)
+	if uint64(len(ch.pending.Body)) >= ch.size {
+		c.published(channel, ch)
+	}
+}
+
+// published answers a publish whose content is complete
+func (c *fakeConn) published(channel uint16, ch *fakeChannel) {
+	m := *ch.pending
+	ch.pending = nil
+
+	if ch.tx {
+		ch.txBuf = append(ch.txBuf, m)
+		return
+	}
+	if ch.confirm {
+		ch.nextTag++
+		m.Tag = ch.nextTag
+	}
+
+	action, delay := c.broker.action(m)
+	switch action {
+	case fakeCloseChannel:
+		c.closeChannel(channel, 541, "INTERNAL_ERROR", 60, 40)
+		return
+	case fakeCloseConnection:
+		c.nc.Close()
+		return
+	case fakeReturn:
+		c.broker.mu.Lock()
+		c.broker.returned = append(c.broker.returned, m)
+		c.broker.mu.Unlock()
+		c.basicReturn(channel, m)
+	case fakeAck, fakeWithhold:
+		c.broker.route(m)
+	}
+
+	if !ch.confirm || action == fakeWithhold {
+		return
+	}
+	var w fakeWriter
+	w.longlong(m.Tag)
+	w.octet(0)
+	method := uint16(80)
+	if action == fakeNack {
+		method = 120
+	}
+	if delay <= 0 {
+		c.method(channel, 60, method, w.Bytes())
+		return
+	}
+	ch.ackAt = later(ch.ackAt, time.Now()).Add(delay)
+	due := ch.ackAt
+	go func() {
+		time.Sleep(time.Until(due))
+		c.method(channel, 60, method, w.Bytes())
+	}()
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// commit applies a transaction: every buffered publish is routed, unless
+// one of them fails, in which case none is
+func (c *fakeConn) commit(channel uint16) bool {
+	ch := c.channel(channel)
+	if ch == nil {
+		return true
+	}
+	buf := ch.txBuf
+	ch.txBuf = nil
+	for _, m := range buf {
+		action, _ := c.broker.action(m)
+		switch action {
+		case fakeCloseChannel:
+			c.closeChannel(channel, 541, "INTERNAL_ERROR", 90, 20)
+			return true
+		case fakeCloseConnection:
+			c.nc.Close()
+			return false
+		}
+	}
+	for _, m := range buf {
+		c.broker.route(m)
+	}
+	c.method(channel, 90, 21, nil)
+	return true
+}
+
+// basicReturn sends m back as unroutable, echoing its headers
+func (c *fakeConn) basicReturn(channel uint16, m fakeMessage) {
+	var w fakeWriter
+	w.short(312)
+	w.shortstr("NO_ROUTE")
+	w.shortstr(m.Exchange)
+	w.shortstr(m.RoutingKey)
+	c.method(channel, 60, 50, w.Bytes())
+
+	var h fakeWriter
+	h.short(60)
+	h.short(0)
+	h.longlong(uint64(len(m.Body)))
+	h.short(0x2000)
+	h.table(m.Headers)
+	c.frame(2, channel, h.Bytes())
+	if len(m.Body) > 0 {
+		c.frame(3, channel, m.Body)
+	}
+}
+
+// fakeWriter encodes AMQP method arguments
+type fakeWriter struct{ bytes.Buffer }
+
+func (w *fakeWriter) octet(v uint8)     { w.WriteByte(v) }
+func (w *fakeWriter) short(v uint16)    { binary.Write(w, binary.BigEndian, v) }
+func (w *fakeWriter) long(v uint32)     { binary.Write(w, binary.BigEndian, v) }
+func (w *fakeWriter) longlong(v uint64) { binary.Write(w, binary.BigEndian, v) }
+
+func (w *fakeWriter) shortstr(s string) {
+	w.octet(uint8(len(s)))
+	w.WriteString(s)
+}
+
+func (w *fakeWriter) longstr(s string) {
+	w.long(uint32(len(s)))
+	w.WriteString(s)
+}
+
+// table encodes the field types the publisher sends in headers
+func (w *fakeWriter) table(t amqp.Table) {
+	var fields fakeWriter
+	for k, v := range t {
+		fields.shortstr(k)
+		switch v := v.(type) {
+		case int64:
+			fields.octet('l')
+			fields.longlong(uint64(v))
+		case int32:
+			fields.octet('I')
+			fields.long(uint32(v))
+		case string:
+			fields.octet('S')
+			fields.longstr(v)
+		case bool:
+			fields.octet('t')
+			if v {
+				fields.octet(1)
+			} else {
+				fields.octet(0)
+			}
+		default:
+			fields.octet('V')
+		}
+	}
+	w.long(uint32(fields.Len()))
+	w.Write(fields.Bytes())
+}
+
+// fakeReader decodes AMQP method arguments
+type fakeReader struct {
+	b []byte
+}
+
+func (r *fakeReader) next(n int) []byte {
+	if n > len(r.b) {
+		n = len(r.b)
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *fakeReader) octet() uint8 {
+	if len(r.b) == 0 {
+		return 0
+	}
+	return r.next(1)[0]
+}
+
+func (r *fakeReader) short() uint16 {
+	b := r.next(2)
+	if len(b) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *fakeReader) long() uint32 {
+	b := r.next(4)
+	if len(b) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *fakeReader) longlong() uint64 {
+	b := r.next(8)
+	if len(b) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *fakeReader) shortstr() string {
+	return string(r.next(int(r.octet())))
+}
+
+func (r *fakeReader) longstr() string {
+	return string(r.next(int(r.long())))
+}
+
+func (r *fakeReader) table() amqp.Table {
+	sub := fakeReader{b: r.next(int(r.long()))}
+	t := amqp.Table{}
+	for len(sub.b) > 0 {
+		key := sub.shortstr()
+		t[key] = sub.value()
+	}
+	return t
+}
+
+func (r *fakeReader) value() interface{} {
+	switch r.octet() {
+	case 't':
+		return r.octet() != 0
+	case 'b':
+		return int8(r.octet())
+	case 'B':
+		return r.octet()
+	case 's':
+		return int16(r.short())
+	case 'u':
+		return r.short()
+	case 'I':
+		return int32(r.long())
+	case 'i':
+		return r.long()
+	case 'l':
+		return int64(r.longlong())
+	case 'f':
+		return math.Float32frombits(r.long())
+	case 'd':
+		return math.Float64frombits(r.longlong())
+	case 'D':
+		scale := r.octet()
+		return amqp.Decimal{Scale: scale, Value: int32(r.long())}
+	case 'S':
+		return r.longstr()
+	case 'x':
+		return []byte(r.longstr())
+	case 'T':
+		return time.Unix(int64(r.longlong()), 0)
+	case 'F':
+		return r.table()
+	case 'A':
+		sub := fakeReader{b: r.next(int(r.long()))}
+		var values []interface{}
+		for len(sub.b) > 0 {
+			values = append(values, sub.value())
+		}
+		return values
+	}
+	return nil
+}
+
+// newTestPublisher returns a connected publisher on b with test-friendly
+// retry and timeout settings; configure adjusts the options first
+func newTestPublisher(t testing.TB, b *fakeBroker, configure func(*Options)) *Publisher {
+	t.Helper()
+	opts := Options{
+		URL:                   b.url(),
+		Exchange:              "meter",
+		MaxRetries:            3,
+		RetryBaseDelay:        time.Millisecond,
+		MaxBackoff:            10 * time.Millisecond,
+		PublishConfirmTimeout: time.Second,
+		PoolSize:              4,
+		Connections:           1,
+		DialTimeout:           time.Second,
+		StartupConnectMaxWait: 2 * time.Second,
+		SpoolReplayBatchSize:  100,
+		SpoolReplayInterval:   10 * time.Millisecond,
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	p, err := NewPublisher(opts, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewPublisher: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	select {
+	case err := <-p.Started():
+		if err != nil {
+			t.Fatalf("publisher did not connect: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publisher did not connect in time")
+	}
+	return p
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(t testing.TB, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
@@ -0,0 +1,25 @@
+package mq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// gzipBody compresses body with gzip at level
+func gzipBody(body []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(len(body) / 4)
+
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to compress message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
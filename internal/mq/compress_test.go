@@ -0,0 +1,90 @@
+package mq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// representativeBatch returns the JSON of a message carrying n readings, as
+// a collector sends them every quarter hour
+func representativeBatch(t testing.TB, n int) []byte {
+	t.Helper()
+	type reading struct {
+		Date string `json:"Date"`
+		Data string `json:"Data"`
+		Name string `json:"Name"`
+	}
+	pm := make([]reading, n)
+	for i := range pm {
+		pm[i] = reading{
+			Date: fmt.Sprintf("19/12/2025 %02d:%02d:00", i/4%24, i%4*15),
+			Data: fmt.Sprintf("%d.%03d", 1000+i*7, i*37%1000),
+			Name: fmt.Sprintf("building-a/floor-%d/meter-%03d", i%5, i%40),
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"schema_version": "1.0",
+		"source":         "ingest-1",
+		"request_id":     "01HQ7Z3X4V5W6Y7Z8A9B0C1D2E",
+		"payload":        map[string]interface{}{"PM": pm},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestPublishCompressedBody(t *testing.T) {
+	b := newFakeBroker(t)
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.Compress = true
+		o.CompressionLevel = gzip.BestSpeed
+	})
+
+	message := map[string]string{"request_id": "req-1"}
+	if err := p.Publish(context.Background(), "meter.reading.ingested", message, PublishOptions{}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	msgs := b.messages()
+	if len(msgs) != 1 {
+		t.Fatalf("broker received %d messages, want 1", len(msgs))
+	}
+	if msgs[0].ContentEncoding != "gzip" || msgs[0].ContentType != "application/json" {
+		t.Errorf("content type %q, encoding %q, want gzipped JSON", msgs[0].ContentType, msgs[0].ContentEncoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(msgs[0].Body))
+	if err != nil {
+		t.Fatalf("body is not gzip: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if string(plain) != `{"request_id":"req-1"}` {
+		t.Errorf("decompressed body = %s", plain)
+	}
+}
+
+func BenchmarkGzipBody(b *testing.B) {
+	body := representativeBatch(b, 96)
+	for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			var compressed []byte
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				var err error
+				if compressed, err = gzipBody(body, level); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(body)), "raw-bytes")
+			b.ReportMetric(float64(len(compressed)), "gzip-bytes")
+			b.ReportMetric(float64(len(compressed))/float64(len(body)), "ratio")
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"go.uber.org/zap"
+)
+
+// ErrConfirmChannelClosed is returned when the channel or its connection
+// closes while confirmations are pending. Unlike a nack the broker has not
+// rejected the message, so the publish is retried after reconnecting.
+var ErrConfirmChannelClosed = errors.New("connection closed during confirm")
+
+// ErrDeliveryUnknown is matched by publish errors after a message reached the
+// broker connection but was neither confirmed nor nacked (confirm timeout,
+// channel closed mid-wait, failed commit), so it may still have been routed.
+// Retrying such a publish can produce a duplicate.
+var ErrDeliveryUnknown = errors.New("delivery unknown")
+
+// ErrConfirmTimeout is returned when the broker does not confirm a publish
+// within the confirm timeout
+var ErrConfirmTimeout = errors.New("confirmation timeout")
+
+// NackError reports a publish the broker negatively acknowledged
+type NackError struct {
+	DeliveryTag uint64
+}
+
+func (e *NackError) Error() string {
+	return fmt.Sprintf("publish not acknowledged by broker (delivery tag %d)", e.DeliveryTag)
+}
+
+// confirmTracker implements confirm-select sequence tracking for one channel.
+// Delivery tags are taken from GetNextPublishSeqNo before each publish, so
+// they increase monotonically and pending stays sorted.
+type confirmTracker struct {
+	pending  []uint64
+	nacked   []uint64
+	returned []*ReturnedError // mandatory publishes the broker could not route
+}
+
+// track records a published delivery tag awaiting confirmation
+func (t *confirmTracker) track(tag uint64) {
+	t.pending = append(t.pending, tag)
+}
+
+// resolve applies a broker confirmation. With multiple set, it settles every
+// outstanding tag up to and including the confirmation's delivery tag.
+// Confirmations for tags that are not pending are ignored.
+func (t *confirmTracker) resolve(c amqp.Confirmation, multiple bool) {
+	if multiple {
+		n := sort.Search(len(t.pending), func(i int) bool { return t.pending[i] > c.DeliveryTag })
+		if !c.Ack {
+			t.nacked = append(t.nacked, t.pending[:n]...)
+		}
+		t.pending = t.pending[n:]
+		return
+	}
+
+	i := sort.Search(len(t.pending), func(i int) bool { return t.pending[i] >= c.DeliveryTag })
+	if i == len(t.pending) || t.pending[i] != c.DeliveryTag {
+		return
+	}
+	if !c.Ack {
+		t.nacked = append(t.nacked, c.DeliveryTag)
+	}
+	t.pending = append(t.pending[:i], t.pending[i+1:]...)
+}
+
+// markReturned records the return of a pending tag and reports whether the
+// tag was pending. The broker acks a returned message, so it only fails
+// once the ack is resolved.
+func (t *confirmTracker) markReturned(tag uint64, ret amqp.Return) bool {
+	i := sort.Search(len(t.pending), func(i int) bool { return t.pending[i] >= tag })
+	if i == len(t.pending) || t.pending[i] != tag {
+		return false
+	}
+	t.returned = append(t.returned, &ReturnedError{
+		DeliveryTag: tag,
+		Exchange:    ret.Exchange,
+		RoutingKey:  ret.RoutingKey,
+		ReplyCode:   ret.ReplyCode,
+		ReplyText:   ret.ReplyText,
+	})
+	return true
+}
+
+// failed returns the nacked and returned tags in publish order
+func (t *confirmTracker) failed() []uint64 {
+	tags := slices.Clone(t.nacked)
+	for _, r := range t.returned {
+		tags = append(tags, r.DeliveryTag)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// settled reports whether every tracked tag has been confirmed
+func (t *confirmTracker) settled() bool {
+	return len(t.pending) == 0
+}
+
+// err returns a NackError for the first nacked tag, or else a
+// ReturnedError for the first returned one, if any
+func (t *confirmTracker) err() error {
+	if len(t.nacked) > 0 {
+		return &NackError{DeliveryTag: t.nacked[0]}
+	}
+	if len(t.returned) > 0 {
+		return t.returned[0]
+	}
+	return nil
+}
+
+// reset clears all tracking state
+func (t *confirmTracker) reset() {
+	t.pending = t.pending[:0]
+	t.nacked = t.nacked[:0]
+	t.returned = t.returned[:0]
+}
+
+// waitConfirms blocks until every tag tracked on pc is confirmed, returning
+// the first nack, or with mandatory publishing the first return. The client
+// library resequences confirmations and expands multiple acks into one
+// Confirmation per tag before delivering them.
+func (p *Publisher) waitConfirms(ctx context.Context, pc *pooledChannel, routingKey string) error {
+	logger := logging.FromContext(ctx, p.logger)
+	limit := p.confirmTimeout(len(pc.tracker.pending))
+	timeout := time.NewTimer(limit)
+	defer timeout.Stop()
+
+	for !pc.tracker.settled() {
+		select {
+		case confirm, ok := <-pc.confirms:
+			// A closed stream yields zero-value confirmations, which would
+			// otherwise read as nacks
+			if !ok {
+				logger.Warn("Channel closed while waiting for confirmations",
+					zap.String("routing_key", routingKey),
+					zap.Int("pending", len(pc.tracker.pending)),
+				)
+				return ErrConfirmChannelClosed
+			}
+			// A return precedes the ack of its message; record it before
+			// the ack settles the tag
+			p.drainReturns(logger, pc)
+			pc.tracker.resolve(confirm, false)
+		case ret, ok := <-pc.returns: // nil, so never ready, unless mandatory
+			if !ok {
+				return ErrConfirmChannelClosed
+			}
+			p.recordReturn(logger, pc, ret)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			confirmTimeouts.Inc()
+			logger.Warn("Publish confirmation timed out",
+				zap.String("routing_key", routingKey),
+				zap.Duration("timeout", limit),
+				zap.Int("pending", len(pc.tracker.pending)),
+			)
+			return ErrConfirmTimeout
+		}
+	}
+
+	return pc.tracker.err()
+}
+
+// confirmTimeout is how long waitConfirms waits for n outstanding
+// confirmations: the base timeout, extended per message after the first and
+// capped at the maximum. The cap never shortens the base timeout.
+func (p *Publisher) confirmTimeout(n int) time.Duration {
+	if n <= 1 || p.confirmTimeoutPerMsg <= 0 {
+		return p.publishConfirmTimeout
+	}
+	timeout := p.publishConfirmTimeout + time.Duration(n-1)*p.confirmTimeoutPerMsg
+	if p.maxConfirmTimeout > 0 && timeout > p.maxConfirmTimeout {
+		timeout = max(p.maxConfirmTimeout, p.publishConfirmTimeout)
+	}
+	return timeout
+}
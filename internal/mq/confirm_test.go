@@ -0,0 +1,137 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestConfirmTrackerResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		confirms []amqp.Confirmation
+		multiple bool
+		pending  []uint64
+		nacked   []uint64
+	}{
+		{
+			name:     "acks settle their own tag only",
+			confirms: []amqp.Confirmation{{DeliveryTag: 2, Ack: true}},
+			pending:  []uint64{1, 3},
+		},
+		{
+			name:     "nack is recorded",
+			confirms: []amqp.Confirmation{{DeliveryTag: 1, Ack: true}, {DeliveryTag: 3, Ack: false}},
+			pending:  []uint64{2},
+			nacked:   []uint64{3},
+		},
+		{
+			name:     "unknown tags are ignored",
+			confirms: []amqp.Confirmation{{DeliveryTag: 7, Ack: true}},
+			pending:  []uint64{1, 2, 3},
+		},
+		{
+			name:     "multiple ack settles every tag up to it",
+			confirms: []amqp.Confirmation{{DeliveryTag: 2, Ack: true}},
+			multiple: true,
+			pending:  []uint64{3},
+		},
+		{
+			name:     "multiple nack fails every tag up to it",
+			confirms: []amqp.Confirmation{{DeliveryTag: 2, Ack: false}},
+			multiple: true,
+			pending:  []uint64{3},
+			nacked:   []uint64{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tr confirmTracker
+			for tag := uint64(1); tag <= 3; tag++ {
+				tr.track(tag)
+			}
+			for _, c := range tt.confirms {
+				tr.resolve(c, tt.multiple)
+			}
+			if !slices.Equal(tr.pending, tt.pending) {
+				t.Errorf("pending = %v, want %v", tr.pending, tt.pending)
+			}
+			if !slices.Equal(tr.nacked, tt.nacked) {
+				t.Errorf("nacked = %v, want %v", tr.nacked, tt.nacked)
+			}
+			if (tr.err() != nil) != (len(tt.nacked) > 0) {
+				t.Errorf("err() = %v with nacked %v", tr.err(), tt.nacked)
+			}
+		})
+	}
+}
+
+func TestConfirmTrackerReset(t *testing.T) {
+	var tr confirmTracker
+	tr.track(1)
+	tr.resolve(amqp.Confirmation{DeliveryTag: 1}, false)
+	tr.track(2)
+	tr.reset()
+	if !tr.settled() || tr.err() != nil {
+		t.Fatalf("after reset settled=%v err=%v, want a clean tracker", tr.settled(), tr.err())
+	}
+}
+
+func TestConfirmChannelClosedWhileWaiting(t *testing.T) {
+	// Every publish but the last is withheld, and the last one fails the
+	// channel it is on, or the connection all of them share
+	tests := []struct {
+		name    string
+		action  fakeAction
+		waiters int
+	}{
+		{"channel closed", fakeCloseChannel, 1},
+		{"connection dropped", fakeCloseConnection, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBroker(t)
+			p := newTestPublisher(t, b, func(o *Options) {
+				o.MaxRetries = 1
+				// Far longer than the test may take, so a timeout cannot pass for the close
+				o.PublishConfirmTimeout = 10 * time.Second
+			})
+			var mu sync.Mutex
+			arrived := 0
+			b.setOnPublish(func(fakeMessage) fakeAction {
+				mu.Lock()
+				defer mu.Unlock()
+				arrived++
+				if arrived < tt.waiters {
+					return fakeWithhold
+				}
+				return tt.action
+			})
+
+			waiters := tt.waiters
+			errs := make(chan error, waiters)
+			for i := 0; i < waiters; i++ {
+				go func(i int) {
+					errs <- p.Publish(context.Background(), "meter", map[string]int{"n": i}, PublishOptions{})
+				}(i)
+				// The last publish goes out once the others are waiting
+				waitFor(t, time.Second, func() bool { return len(b.messages()) > i || i == waiters-1 })
+			}
+			for i := 0; i < waiters; i++ {
+				select {
+				case err := <-errs:
+					if !errors.Is(err, ErrDeliveryUnknown) {
+						t.Errorf("Publish = %v, want ErrDeliveryUnknown", err)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatal("a publish waiting for its confirm hung after the channel closed")
+				}
+			}
+		})
+	}
+}
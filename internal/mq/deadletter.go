@@ -0,0 +1,84 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"go.uber.org/zap"
+)
+
+// Headers set on dead-lettered messages besides OriginalExchangeHeader and
+// OriginalRoutingKeyHeader. The body is left exactly as it would have been
+// published, so a message can be replayed by republishing it unchanged.
+const (
+	DeadLetterHeader      = "x-dead-lettered"
+	PublishAttemptsHeader = "x-publish-attempts"
+	LastErrorHeader       = "x-last-error"
+)
+
+// ErrNoDeadLetter is returned by PublishDeadLetter when no dead-letter
+// target is configured
+var ErrNoDeadLetter = errors.New("no dead-letter target configured")
+
+// AttemptsError reports how many attempts Publish made before failing with Err
+type AttemptsError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *AttemptsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AttemptsError) Unwrap() error {
+	return e.Err
+}
+
+// PublishDeadLetter makes one attempt to publish message, which could not be
+// published to routingKey because of cause, to the dead-letter target. It is
+// tagged with the original exchange and routing key, the last error and, when
+// cause is an *AttemptsError, the number of attempts made. Unlike Publish it
+// is never retried, sent to the fallback or spooled.
+func (p *Publisher) PublishDeadLetter(ctx context.Context, routingKey string, message interface{}, cause error) error {
+	if p.deadLetter == nil {
+		return ErrNoDeadLetter
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	headers := amqp.Table{
+		DeadLetterHeader:         true,
+		OriginalExchangeHeader:   p.exchange,
+		OriginalRoutingKeyHeader: routingKey,
+		LastErrorHeader:          cause.Error(),
+	}
+	var attemptsErr *AttemptsError
+	if errors.As(cause, &attemptsErr) {
+		headers[PublishAttemptsHeader] = int32(attemptsErr.Attempts)
+	}
+
+	logger := logging.FromContext(ctx, p.logger)
+	if err := p.publishTo(ctx, []Target{*p.deadLetter}, headers, routingKey, body, PublishOptions{}); err != nil {
+		deadLetterPublishes.WithLabelValues("failed").Inc()
+		logger.Error("Dead-letter publish failed",
+			zap.String("dead_letter_exchange", p.deadLetter.Exchange),
+			zap.String("routing_key", routingKey),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	deadLetterPublishes.WithLabelValues("published").Inc()
+	logger.Warn("Message published to dead-letter exchange",
+		zap.String("dead_letter_exchange", p.deadLetter.Exchange),
+		zap.String("routing_key", routingKey),
+		zap.Error(cause),
+	)
+	return nil
+}
@@ -0,0 +1,112 @@
+package mq_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+	"go.uber.org/zap"
+)
+
+func TestPublishDeadLetterHeaders(t *testing.T) {
+	message := map[string]string{"request_id": "req-1"}
+	tests := []struct {
+		name         string
+		cause        error
+		wantAttempts interface{} // nil when the header must be absent
+	}{
+		{"after retries", &mq.AttemptsError{Attempts: 3, Err: errors.New("broker nacked")}, int32(3)},
+		{"plain error", errors.New("broker nacked"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := mq.NewFakeBroker(t)
+			p := mq.NewTestPublisher(t, b, func(o *mq.Options) {
+				o.DeadLetter = &mq.Target{Exchange: "meter.dlx"}
+			})
+
+			if err := p.PublishDeadLetter(context.Background(), "meter.reading.ingested", message, tt.cause); err != nil {
+				t.Fatalf("PublishDeadLetter = %v", err)
+			}
+			routed := b.Routed()
+			if len(routed) != 1 {
+				t.Fatalf("broker routed %d messages, want the dead-letter copy", len(routed))
+			}
+			m := routed[0]
+			if m.Exchange != "meter.dlx" || m.RoutingKey != "meter.reading.ingested" {
+				t.Errorf("dead letter went to %s/%s, want meter.dlx/meter.reading.ingested", m.Exchange, m.RoutingKey)
+			}
+			if m.Headers[mq.DeadLetterHeader] != true ||
+				m.Headers[mq.OriginalExchangeHeader] != "meter" ||
+				m.Headers[mq.OriginalRoutingKeyHeader] != "meter.reading.ingested" ||
+				m.Headers[mq.LastErrorHeader] != "broker nacked" {
+				t.Errorf("headers = %v, want the dead-letter tag, original destination and last error", m.Headers)
+			}
+			if got, ok := m.Headers[mq.PublishAttemptsHeader]; got != tt.wantAttempts || ok != (tt.wantAttempts != nil) {
+				t.Errorf("%s = %v (present %t), want %v", mq.PublishAttemptsHeader, got, ok, tt.wantAttempts)
+			}
+			want, _ := json.Marshal(message)
+			if string(m.Body) != string(want) {
+				t.Errorf("body = %s, want the message unchanged: %s", m.Body, want)
+			}
+		})
+	}
+}
+
+func TestPublishDeadLetterNotConfigured(t *testing.T) {
+	b := mq.NewFakeBroker(t)
+	p := mq.NewTestPublisher(t, b, nil)
+
+	err := p.PublishDeadLetter(context.Background(), "meter.reading.ingested", map[string]string{}, errors.New("nacked"))
+	if !errors.Is(err, mq.ErrNoDeadLetter) {
+		t.Fatalf("PublishDeadLetter = %v, want ErrNoDeadLetter", err)
+	}
+	if n := len(b.Routed()); n != 0 {
+		t.Errorf("broker routed %d messages, want none", n)
+	}
+}
+
+func TestAsyncFailureIsDeadLettered(t *testing.T) {
+	b := mq.NewFakeBroker(t)
+	b.NackExchanges("meter")
+	p := mq.NewTestPublisher(t, b, func(o *mq.Options) {
+		o.DeadLetter = &mq.Target{Exchange: "meter.dlx"}
+	})
+	r := newIngestRouter(p, zap.NewNop(), func(o *service.Options) {
+		o.AsyncWorkers = 1
+		o.AsyncQueueSize = 1
+		o.DeadLetter = p
+	})
+
+	w := ingest(r, nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202 before the async publish: %s", w.Code, w.Body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(b.Routed()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	routed := b.Routed()
+	if len(routed) != 1 {
+		t.Fatalf("broker routed %d messages, want only the dead-letter copy", len(routed))
+	}
+	m := routed[0]
+	if m.Exchange != "meter.dlx" {
+		t.Errorf("message went to %s, want meter.dlx", m.Exchange)
+	}
+	if m.Headers[mq.DeadLetterHeader] != true || m.Headers[mq.PublishAttemptsHeader] != int32(3) {
+		t.Errorf("headers = %v, want the dead-letter tag after 3 attempts", m.Headers)
+	}
+	var published struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(m.Body, &published); err != nil || published.RequestID != w.Header().Get("X-Request-ID") {
+		t.Errorf("dead-lettered request_id = %q (%v), want the accepted request's %q", published.RequestID, err, w.Header().Get("X-Request-ID"))
+	}
+}
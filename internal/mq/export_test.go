@@ -0,0 +1,28 @@
+package mq
+
+import (
+	"slices"
+	"testing"
+)
+
+// The fake broker is exported to the external tests of this package, which
+// exercise the publisher behind the service and HTTP handler
+
+func NewFakeBroker(t testing.TB) *fakeBroker { return newFakeBroker(t) }
+
+func NewTestPublisher(t testing.TB, b *fakeBroker, configure func(*Options)) *Publisher {
+	return newTestPublisher(t, b, configure)
+}
+
+// NackExchanges makes the broker nack every message published to exchanges
+func (b *fakeBroker) NackExchanges(exchanges ...string) {
+	b.setOnPublish(func(m fakeMessage) fakeAction {
+		if slices.Contains(exchanges, m.Exchange) {
+			return fakeNack
+		}
+		return fakeAck
+	})
+}
+
+// Routed returns the messages the broker routed
+func (b *fakeBroker) Routed() []fakeMessage { return b.messages() }
@@ -0,0 +1,44 @@
+package mq
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"go.uber.org/zap"
+)
+
+// Headers set on messages published to the fallback target, so consumers can
+// tell them apart and reconcile them with the primary stream
+const (
+	FallbackHeader           = "x-fallback"
+	OriginalExchangeHeader   = "x-original-exchange"
+	OriginalRoutingKeyHeader = "x-original-routing-key"
+)
+
+// publishFallback makes one attempt to publish body to the fallback target
+// after every attempt on the primary exchange failed. It is waited for and
+// confirmed like a primary publish.
+func (p *Publisher) publishFallback(ctx context.Context, routingKey string, body []byte, opts PublishOptions) error {
+	headers := amqp.Table{
+		FallbackHeader:           true,
+		OriginalExchangeHeader:   p.exchange,
+		OriginalRoutingKeyHeader: routingKey,
+	}
+	if err := p.publishTo(ctx, []Target{*p.fallback}, headers, routingKey, body, opts); err != nil {
+		fallbackPublishes.WithLabelValues("failed").Inc()
+		logging.FromContext(ctx, p.logger).Error("Fallback publish failed",
+			zap.String("fallback_exchange", p.fallback.Exchange),
+			zap.String("routing_key", routingKey),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	fallbackPublishes.WithLabelValues("published").Inc()
+	logging.FromContext(ctx, p.logger).Warn("Message published to fallback exchange after primary failure",
+		zap.String("fallback_exchange", p.fallback.Exchange),
+		zap.String("routing_key", routingKey),
+	)
+	return nil
+}
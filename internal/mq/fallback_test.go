@@ -0,0 +1,90 @@
+package mq_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/septivank/energy-metering-ingest-api/internal/handler"
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+	"github.com/septivank/energy-metering-ingest-api/tools/requestid"
+	"go.uber.org/zap"
+)
+
+// newIngestRouter serves the ingest route with a service publishing to p,
+// logging to logger; configure adjusts the service options first
+func newIngestRouter(p *mq.Publisher, logger *zap.Logger, configure func(*service.Options)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	opts := service.Options{
+		RoutingKey:      "meter.reading.ingested",
+		DateLayout:      service.DefaultDateLayout,
+		PublishDeadline: 5 * time.Second,
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	svc := service.NewIngestService(p, logger, opts)
+	h := handler.NewMeterHandler(svc, handler.PublishFailureConfig{Status: http.StatusServiceUnavailable}, logger)
+	r := gin.New()
+	r.Use(middleware.RequestContext(logger, requestid.FormatUUID))
+	r.POST("/api/v1/meter/readings", h.IngestReading)
+	return r
+}
+
+// ingest posts one reading to r with extra headers
+func ingest(r http.Handler, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/meter/readings",
+		bytes.NewBufferString(`{"PM":[{"date":"01/03/2024 12:00:00","data":"1","name":"meter-1"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestFallbackExchangeKeepsIngestAccepted(t *testing.T) {
+	b := mq.NewFakeBroker(t)
+	b.NackExchanges("meter")
+	p := mq.NewTestPublisher(t, b, func(o *mq.Options) {
+		o.Fallback = &mq.Target{Exchange: "meter.backup", RoutingKey: "meter.reading.fallback"}
+	})
+
+	if w := ingest(newIngestRouter(p, zap.NewNop(), nil), nil); w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202 once the fallback confirmed: %s", w.Code, w.Body)
+	}
+	routed := b.Routed()
+	if len(routed) != 1 {
+		t.Fatalf("broker routed %d messages, want only the fallback copy", len(routed))
+	}
+	m := routed[0]
+	if m.Exchange != "meter.backup" || m.RoutingKey != "meter.reading.fallback" {
+		t.Errorf("fallback went to %s/%s, want meter.backup/meter.reading.fallback", m.Exchange, m.RoutingKey)
+	}
+	if m.Headers[mq.FallbackHeader] != true ||
+		m.Headers[mq.OriginalExchangeHeader] != "meter" ||
+		m.Headers[mq.OriginalRoutingKeyHeader] != "meter.reading.ingested" {
+		t.Errorf("headers = %v, want the fallback tag and the original destination", m.Headers)
+	}
+}
+
+func TestFallbackFailureFailsIngest(t *testing.T) {
+	b := mq.NewFakeBroker(t)
+	b.NackExchanges("meter", "meter.backup")
+	p := mq.NewTestPublisher(t, b, func(o *mq.Options) {
+		o.Fallback = &mq.Target{Exchange: "meter.backup"}
+	})
+
+	if w := ingest(newIngestRouter(p, zap.NewNop(), nil), nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503 when the fallback fails too: %s", w.Code, w.Body)
+	}
+	if routed := b.Routed(); len(routed) != 0 {
+		t.Errorf("broker routed %d messages, want none", len(routed))
+	}
+}
@@ -0,0 +1,65 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// keepalive periodically pings the broker so a connection that looks open
+// but no longer works is torn down and replaced before a publish stumbles on
+// it and waits out the confirm timeout
+func (p *Publisher) keepalive() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkConnection()
+		}
+	}
+}
+
+// checkConnection pings the broker once and reconnects if the ping fails
+func (p *Publisher) checkConnection() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.publishConfirmTimeout)
+	defer cancel()
+
+	start := time.Now()
+	connectedAt := p.connectedAt.Load()
+	err := p.Ping(ctx)
+	if err == nil {
+		p.logger.Debug("RabbitMQ keepalive ok", zap.Duration("latency", time.Since(start)))
+		return
+	}
+	// A blocked connection is healthy; replacing it would be blocked as well
+	if errors.Is(err, ErrBlocked) {
+		return
+	}
+	// The ping may have hit connections a rotation has just replaced
+	if p.connectedAt.Load() != connectedAt {
+		return
+	}
+
+	staleConnections.Inc()
+	p.logger.Warn("RabbitMQ keepalive failed, replacing connection",
+		zap.Duration("elapsed", time.Since(start)),
+		zap.Error(err),
+	)
+
+	// connect tears down the old connection and its pooled channels first
+	reconnectAttempts.Inc()
+	if err := p.connect(); err != nil {
+		reconnectFailures.Inc()
+		p.logger.Error("RabbitMQ reconnect after failed keepalive failed", zap.Error(err))
+		return
+	}
+	p.logger.Info("RabbitMQ connection recovered after failed keepalive")
+}
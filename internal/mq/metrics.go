@@ -0,0 +1,92 @@
+package mq
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Broker connection metrics. Names are part of the alerting contract and must
+// stay stable:
+//
+//	mq_reconnect_attempts_total  - reconnects started after the connection was found unhealthy
+//	mq_reconnect_failures_total  - reconnects that failed to establish a connection
+//	mq_confirm_timeouts_total    - publishes that got no broker confirmation within the timeout
+//	mq_connection_up             - 1 while the broker connection is open, 0 otherwise
+//	mq_stale_connections_total   - connections replaced after a failed keepalive ping
+//	mq_connection_blocked        - 1 while the broker blocks a publisher connection, 0 otherwise
+//	mq_lock_held_seconds         - how long the publisher lock has been held, sampled by the watchdog
+//	mq_lock_stalls_total         - times the publisher lock was held past the watchdog threshold
+//	mq_fallback_publishes_total  - publishes to the fallback exchange after the primary failed, by outcome
+//	mq_connection_rotations_total - connection rotations at the maximum lifetime, by outcome
+//	mq_returned_messages_total   - mandatory publishes returned by the broker as unroutable
+//	mq_pool_channels_total       - open pooled channels, idle or in use
+//	mq_pool_channels_in_use      - pooled channels held by a publish
+//	mq_pool_wait_duration_seconds - time publishes waited for a free pool slot
+//	mq_pool_channels_reaped_total - idle channels closed after the idle timeout
+var (
+	reconnectAttempts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_reconnect_attempts_total",
+		Help: "Number of RabbitMQ reconnect attempts.",
+	})
+	reconnectFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_reconnect_failures_total",
+		Help: "Number of failed RabbitMQ reconnect attempts.",
+	})
+	confirmTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_confirm_timeouts_total",
+		Help: "Number of publishes that timed out waiting for a broker confirmation.",
+	})
+	connectionUp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mq_connection_up",
+		Help: "Whether the RabbitMQ connection is open (1) or not (0).",
+	})
+	staleConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_stale_connections_total",
+		Help: "Number of RabbitMQ connections replaced after a failed keepalive ping.",
+	})
+	connectionBlocked = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mq_connection_blocked",
+		Help: "Whether RabbitMQ is blocking a publisher connection (1) or not (0).",
+	})
+	lockHeldSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mq_lock_held_seconds",
+		Help: "How long the RabbitMQ publisher lock has currently been held, sampled by the watchdog.",
+	})
+	lockStalls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_lock_stalls_total",
+		Help: "Number of times the RabbitMQ publisher lock was held past the watchdog threshold.",
+	})
+	fallbackPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mq_fallback_publishes_total",
+		Help: "Number of publishes to the fallback exchange after the primary exchange failed, by outcome.",
+	}, []string{"outcome"})
+	deadLetterPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mq_dead_letter_publishes_total",
+		Help: "Number of publishes to the dead-letter exchange, by outcome.",
+	}, []string{"outcome"})
+	connectionRotations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mq_connection_rotations_total",
+		Help: "Number of RabbitMQ connection rotations at the maximum lifetime, by outcome (rotated, deferred, failed).",
+	}, []string{"outcome"})
+	returnedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_returned_messages_total",
+		Help: "Number of mandatory publishes returned by the broker as unroutable.",
+	})
+	poolChannels = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mq_pool_channels_total",
+		Help: "Number of open pooled publisher channels, idle or in use.",
+	})
+	poolChannelsInUse = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mq_pool_channels_in_use",
+		Help: "Number of pooled publisher channels currently held by a publish.",
+	})
+	poolWaitSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mq_pool_wait_duration_seconds",
+		Help:    "Time publishes waited for a free publisher pool slot.",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	})
+	reapedChannels = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mq_pool_channels_reaped_total",
+		Help: "Number of idle publisher channels closed after the idle timeout.",
+	})
+)
@@ -0,0 +1,54 @@
+package mq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNoConfirmsDoesNotWaitForAcks(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(func(fakeMessage) fakeAction { return fakeWithhold })
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.NoConfirms = true
+		o.PublishConfirmTimeout = 50 * time.Millisecond
+	})
+
+	if err := p.Publish(context.Background(), "meter", map[string]int{"n": 1}, PublishOptions{}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if !waitFor(t, time.Second, func() bool { return len(b.messages()) == 1 }) {
+		t.Fatal("the broker did not receive the message")
+	}
+}
+
+// BenchmarkPublishModes compares the throughput of a single publisher in
+// each mode against a broker with a realistic confirm round-trip
+func BenchmarkPublishModes(b *testing.B) {
+	modes := []struct {
+		name      string
+		configure func(*Options)
+	}{
+		{"confirm", func(*Options) {}},
+		{"no_confirm", func(o *Options) { o.NoConfirms = true }},
+		{"tx", func(o *Options) { o.Atomic = true }},
+	}
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			broker := newFakeBroker(b)
+			broker.setAckDelay(200 * time.Microsecond)
+			p := newTestPublisher(b, broker, func(o *Options) {
+				o.PoolSize = 1
+				mode.configure(o)
+			})
+			message := map[string]string{"request_id": "bench"}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := p.Publish(context.Background(), "meter", message, PublishOptions{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
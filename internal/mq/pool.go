@@ -0,0 +1,218 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// channelMode selects how the broker acknowledges publishes on a channel
+type channelMode int
+
+const (
+	// modeConfirm waits for a publisher confirm per message
+	modeConfirm channelMode = iota
+	// modeNoConfirm does not wait for the broker at all
+	modeNoConfirm
+	// modeTx publishes in AMQP transactions, committed or rolled back as a whole
+	modeTx
+)
+
+// pooledChannel is an AMQP channel, normally in confirm mode with its own
+// confirmation stream. A pooled channel is used by at most one publisher at a
+// time, so its confirmations can never be observed by another publish.
+type pooledChannel struct {
+	ch       *amqp.Channel
+	confirms <-chan amqp.Confirmation
+	returns  <-chan amqp.Return // nil unless publishing is mandatory
+	tracker  confirmTracker
+	conn     *amqp.Connection
+	idleAt   time.Time // when the channel was last returned to the pool
+}
+
+// openChannel opens a new channel on conn in the given mode. confirms is nil
+// on channels without confirm mode. With mandatory set, which requires
+// confirm mode, the returns of unroutable messages are delivered on returns.
+func openChannel(conn *amqp.Connection, mode channelMode, mandatory bool) (*pooledChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	switch mode {
+	case modeNoConfirm:
+		return &pooledChannel{ch: ch, conn: conn}, nil
+	case modeTx:
+		if err := ch.Tx(); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("failed to enable transaction mode: %w", err)
+		}
+		return &pooledChannel{ch: ch, conn: conn}, nil
+	}
+
+	// Enable publish confirms
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to enable confirm mode: %w", err)
+	}
+
+	pc := &pooledChannel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		conn:     conn,
+	}
+	if mandatory {
+		pc.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	return pc, nil
+}
+
+// channelPool bounds the number of concurrent publishes and keeps idle
+// channels around for reuse. A slot must be acquired before a channel is
+// taken from (or opened for) the pool. Idle channels are reused most
+// recently returned first, so under light load the surplus ones stay idle
+// long enough for reap to close them.
+type channelPool struct {
+	slots chan struct{}
+
+	mu    sync.Mutex
+	idle  []*pooledChannel // least recently returned first
+	inUse int              // channels checked out by a publish
+}
+
+func newChannelPool(size int) *channelPool {
+	if size < 1 {
+		size = 1
+	}
+	return &channelPool{
+		slots: make(chan struct{}, size),
+		idle:  make([]*pooledChannel, 0, size),
+	}
+}
+
+// acquire blocks until a publish slot is free or ctx is done
+func (cp *channelPool) acquire(ctx context.Context) error {
+	select {
+	case cp.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a publish slot
+func (cp *channelPool) release() {
+	<-cp.slots
+}
+
+// acquireAll holds every publish slot, so no publish is in flight until
+// releaseAll. On failure it releases the slots it got and returns ctx's error.
+func (cp *channelPool) acquireAll(ctx context.Context) error {
+	for held := 0; held < cap(cp.slots); held++ {
+		if err := cp.acquire(ctx); err != nil {
+			for ; held > 0; held-- {
+				cp.release()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseAll frees the slots held by acquireAll
+func (cp *channelPool) releaseAll() {
+	for i := 0; i < cap(cp.slots); i++ {
+		cp.release()
+	}
+}
+
+// takeIdle returns the most recently returned idle channel, or nil if none
+// is available
+func (cp *channelPool) takeIdle() *pooledChannel {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	n := len(cp.idle)
+	if n == 0 {
+		return nil
+	}
+	pc := cp.idle[n-1]
+	cp.idle[n-1] = nil
+	cp.idle = cp.idle[:n-1]
+	cp.report()
+	return pc
+}
+
+// putIdle returns a channel to the pool, closing it if the pool is full
+func (cp *channelPool) putIdle(pc *pooledChannel) {
+	cp.mu.Lock()
+	if len(cp.idle) == cap(cp.slots) {
+		cp.mu.Unlock()
+		pc.ch.Close()
+		return
+	}
+	pc.idleAt = time.Now()
+	cp.idle = append(cp.idle, pc)
+	cp.report()
+	cp.mu.Unlock()
+}
+
+// checkedOut and checkedIn count the channels held by publishes
+func (cp *channelPool) checkedOut() {
+	cp.mu.Lock()
+	cp.inUse++
+	cp.report()
+	cp.mu.Unlock()
+}
+
+func (cp *channelPool) checkedIn() {
+	cp.mu.Lock()
+	cp.inUse--
+	cp.report()
+	cp.mu.Unlock()
+}
+
+// reap closes the channels idle for longer than timeout and returns how
+// many it closed. Only idle channels are considered, so a channel is never
+// closed while a publish holds it.
+func (cp *channelPool) reap(timeout time.Duration) int {
+	cutoff := time.Now().Add(-timeout)
+
+	cp.mu.Lock()
+	n := 0
+	for n < len(cp.idle) && cp.idle[n].idleAt.Before(cutoff) {
+		n++
+	}
+	stale := make([]*pooledChannel, n)
+	copy(stale, cp.idle[:n])
+	cp.idle = append(cp.idle[:0], cp.idle[n:]...)
+	cp.report()
+	cp.mu.Unlock()
+
+	for _, pc := range stale {
+		pc.ch.Close()
+	}
+	return n
+}
+
+// drain closes all idle channels
+func (cp *channelPool) drain() {
+	cp.mu.Lock()
+	idle := cp.idle
+	cp.idle = make([]*pooledChannel, 0, cap(cp.slots))
+	cp.report()
+	cp.mu.Unlock()
+
+	for _, pc := range idle {
+		pc.ch.Close()
+	}
+}
+
+// report updates the pool gauges; cp.mu must be held
+func (cp *channelPool) report() {
+	poolChannels.Set(float64(cp.inUse + len(cp.idle)))
+	poolChannelsInUse.Set(float64(cp.inUse))
+}
@@ -0,0 +1,241 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestConcurrentPublishesUseSeparateChannels(t *testing.T) {
+	b := newFakeBroker(t)
+	// Slow acks keep publishes in flight long enough to overlap
+	b.setAckDelay(5 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) { o.PoolSize = 4 })
+
+	var maxInFlight atomic.Int64
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if n := int64(p.InFlight()); n > maxInFlight.Load() {
+				maxInFlight.Store(n)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	const publishes = 40
+	var wg sync.WaitGroup
+	errs := make(chan error, publishes)
+	for i := 0; i < publishes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- p.Publish(context.Background(), "meter", map[string]int{"n": i}, PublishOptions{})
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	// Every message is confirmed exactly once, however the confirms interleave
+	seen := make(map[string]bool)
+	for _, m := range b.messages() {
+		if seen[string(m.Body)] {
+			t.Errorf("message %s delivered twice", m.Body)
+		}
+		seen[string(m.Body)] = true
+	}
+	if len(seen) != publishes {
+		t.Errorf("broker received %d distinct messages, want %d", len(seen), publishes)
+	}
+	if n := maxInFlight.Load(); n < 2 || n > 4 {
+		t.Errorf("max in-flight publishes = %d, want parallel publishes bounded by the pool size 4", n)
+	}
+}
+
+// BenchmarkPublishPoolSize compares a single channel, which serializes every
+// publish behind the previous confirm, with pooled channels
+func BenchmarkPublishPoolSize(b *testing.B) {
+	for _, size := range []int{1, 8} {
+		b.Run(fmt.Sprintf("pool=%d", size), func(b *testing.B) {
+			broker := newFakeBroker(b)
+			broker.setAckDelay(100 * time.Microsecond)
+			p := newTestPublisher(b, broker, func(o *Options) { o.PoolSize = size })
+			message := map[string]string{"request_id": "bench"}
+
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := p.Publish(context.Background(), "meter", message, PublishOptions{}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestCloseDrainsPooledChannels(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setAckDelay(5 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) { o.PoolSize = 4 })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := p.Publish(context.Background(), "meter", map[string]int{"n": i}, PublishOptions{}); err != nil {
+				t.Errorf("Publish: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	open := b.openChannels()
+	if open < 2 {
+		t.Fatalf("%d channels open after concurrent publishes, want the pool to have grown", open)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := b.closedChannels(); got != open {
+		t.Errorf("Close closed %d channels, want all %d pooled channels closed", got, open)
+	}
+	if !waitFor(t, time.Second, func() bool { return b.openChannels() == 0 }) {
+		t.Errorf("%d channels still open after Close", b.openChannels())
+	}
+	if err := p.Publish(context.Background(), "meter", map[string]int{"n": 0}, PublishOptions{}); err == nil {
+		t.Error("Publish succeeded after Close")
+	}
+}
+
+// histogramCount returns how many observations h has recorded, and their sum
+func histogramCount(t *testing.T, h prometheus.Histogram) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestCheckoutContention(t *testing.T) {
+	const size, publishes = 2, 8
+	b := newFakeBroker(t)
+	b.setAckDelay(20 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) { o.PoolSize = size })
+	waitsBefore, waitedBefore := histogramCount(t, poolWaitSeconds.(prometheus.Histogram))
+
+	var peak atomic.Int64
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if n := int64(testutil.ToFloat64(poolChannelsInUse)); n > peak.Load() {
+				peak.Store(n)
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, publishes)
+	for i := 0; i < publishes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- p.Publish(context.Background(), "meter", map[string]int{"n": i}, PublishOptions{})
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(stop)
+	<-sampled
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	// Two channels confirming 20ms apart need at least four rounds
+	if elapsed < publishes/size*20*time.Millisecond {
+		t.Errorf("%d publishes took %v, faster than %d channels allow", publishes, elapsed, size)
+	}
+	if n := peak.Load(); n < 1 || n > size {
+		t.Errorf("peak channels in use = %d, want 1 to %d", n, size)
+	}
+	waits, waited := histogramCount(t, poolWaitSeconds.(prometheus.Histogram))
+	if waits-waitsBefore != publishes {
+		t.Errorf("pool wait recorded %d checkouts, want %d", waits-waitsBefore, publishes)
+	}
+	// Publishes beyond the first two waited for a slot to free up
+	if waited-waitedBefore < 0.02 {
+		t.Errorf("pool wait totals %.3fs, want the queued publishes' wait recorded", waited-waitedBefore)
+	}
+	if inUse, total := testutil.ToFloat64(poolChannelsInUse), testutil.ToFloat64(poolChannels); inUse != 0 || total > size {
+		t.Errorf("after publishing %v channels in use of %v, want 0 of at most %d", inUse, total, size)
+	}
+}
+
+func TestIdleChannelsReaped(t *testing.T) {
+	b := newFakeBroker(t)
+	// Longer than one reaper tick, so the reaper runs while a publish holds
+	// the channel
+	b.setAckDelay(1200 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.PoolSize = 1
+		o.ChannelIdleTimeout = 10 * time.Millisecond
+		o.PublishConfirmTimeout = 3 * time.Second
+	})
+	reapedBefore := testutil.ToFloat64(reapedChannels)
+
+	if err := p.Publish(context.Background(), "meter", "held", PublishOptions{}); err != nil {
+		t.Fatalf("Publish while the reaper runs: %v", err)
+	}
+	if got := testutil.ToFloat64(reapedChannels) - reapedBefore; got != 0 {
+		t.Fatalf("%v channels reaped while a publish held them", got)
+	}
+
+	if !waitFor(t, 3*time.Second, func() bool { return testutil.ToFloat64(reapedChannels)-reapedBefore == 1 }) {
+		t.Fatal("the idle channel was not reaped")
+	}
+	if total := testutil.ToFloat64(poolChannels); total != 0 {
+		t.Errorf("mq_pool_channels_total = %v after reaping, want 0", total)
+	}
+
+	// The next publish reopens a channel on demand
+	b.setAckDelay(0)
+	if err := p.Publish(context.Background(), "meter", "reopened", PublishOptions{}); err != nil {
+		t.Fatalf("Publish after reaping: %v", err)
+	}
+	if n := len(b.messages()); n != 2 {
+		t.Errorf("broker received %d messages, want 2", n)
+	}
+}
@@ -3,138 +3,634 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
 	"go.uber.org/zap"
 )
 
+// defaultStartupConnectMaxWait bounds how long the first connection is
+// retried when Options.StartupConnectMaxWait is unset
+const defaultStartupConnectMaxWait = 30 * time.Second
+
+// ErrNotConnected is matched by errors returned when no broker connection is
+// available to publish on
+var ErrNotConnected = errors.New("not connected to RabbitMQ")
+
+// errStillConnecting is returned until the first broker connection is established
+var errStillConnecting = fmt.Errorf("%w: still connecting", ErrNotConnected)
+
+// errClosed is returned when the publisher is closed before it connected, and
+// instead of reconnecting once it is closed, so a late publish cannot dial a
+// connection nothing would close
+var errClosed = errors.New("publisher closed")
+
+// Target is an additional exchange every message is published to
+type Target struct {
+	Exchange string
+	// RoutingKey overrides the message's routing key when non-empty
+	RoutingKey string
+}
+
+// PublishOptions are per-message publish settings
+type PublishOptions struct {
+	// Priority is the AMQP message priority. It only takes effect on queues
+	// declared with x-max-priority; 0 is the lowest priority.
+	Priority uint8
+	// NoSpool fails the publish after its retries instead of spooling it
+	NoSpool bool
+}
+
+// Options configures a Publisher
+type Options struct {
+	URL                   string
+	Exchange              string
+	MaxRetries            int
+	RetryBaseDelay        time.Duration
+	PublishConfirmTimeout time.Duration
+	// ConfirmTimeoutPerMessage extends PublishConfirmTimeout for every
+	// message after the first awaiting confirmation on a channel, so a large
+	// batch is not held to a timeout tuned for single messages. The extended
+	// timeout is capped at MaxConfirmTimeout, when positive.
+	ConfirmTimeoutPerMessage time.Duration
+	MaxConfirmTimeout        time.Duration
+	// NoConfirms publishes without confirm mode: a publish succeeds once the
+	// message is written to the socket, so messages the broker drops or loses
+	// (e.g. on a crash or an internal error) are lost silently. Only for
+	// best-effort streams that favour throughput over durability.
+	NoConfirms bool
+	// Atomic publishes the copies of a message (see AdditionalTargets) in one
+	// AMQP transaction instead of confirm mode, so either every target gets
+	// the message or none does. Each commit is a synchronous broker round-trip
+	// that also fsyncs persistent messages, so throughput drops noticeably.
+	// Takes precedence over NoConfirms.
+	Atomic bool
+	// LockWatchdogThreshold is how long the publisher lock may be held before
+	// the watchdog logs a stall and Wedged fails; zero disables the watchdog
+	LockWatchdogThreshold time.Duration
+	// LockWatchdogReconnect force-closes the connections on a stall, so a
+	// holder waiting on the broker fails and a fresh connection is dialed
+	LockWatchdogReconnect bool
+	// ConfirmMode is ConfirmModeSync (default) or ConfirmModeBatch. In batch
+	// mode spool replay publishes each replay batch before waiting for its
+	// confirms, instead of waiting after every message.
+	ConfirmMode string
+	// MaxBackoff caps the exponential retry delay; zero means uncapped
+	MaxBackoff time.Duration
+	// Heartbeat is the AMQP heartbeat interval negotiated with the broker
+	Heartbeat time.Duration
+	// DialTimeout bounds the TCP connect and the TLS/AMQP handshake
+	DialTimeout time.Duration
+	// Vhost overrides the virtual host from the URL when non-empty
+	Vhost string
+	// KeepaliveInterval is how often the broker is pinged in the background to
+	// detect stale connections; zero disables the keepalive
+	KeepaliveInterval time.Duration
+	// MaxConnectionLifetime, when positive, replaces the connections once they
+	// are this old, waiting for a moment with no publish in flight; see
+	// rotateConnections
+	MaxConnectionLifetime time.Duration
+	// Transient publishes with non-persistent delivery mode, trading durability
+	// across broker restarts for less broker disk I/O
+	Transient bool
+	// MessageTTL sets a per-message expiration; zero means messages never expire
+	MessageTTL time.Duration
+	// Compress gzips message bodies at CompressionLevel and marks them with
+	// content-encoding "gzip"
+	Compress         bool
+	CompressionLevel int
+	// ContentType is the content_type property of every message; empty means "application/json"
+	ContentType string
+	// Topology selects which broker objects are declared on every (re)connect
+	Topology Topology
+	// AdditionalTargets receive a copy of every message besides Exchange. A
+	// publish succeeds only once every copy is confirmed.
+	AdditionalTargets []Target
+	// Fallback, when set, receives a message once every attempt on the
+	// primary exchange failed, tagged with FallbackHeader and the original
+	// exchange and routing key. An empty routing key keeps the original one.
+	Fallback *Target
+	// DeadLetter is where PublishDeadLetter sends messages the caller gave up
+	// on; an empty routing key keeps the original one
+	DeadLetter *Target
+	// PoolSize bounds how many publishes may be in flight at once, each on its own channel
+	PoolSize int
+	// Connections is how many broker connections the pooled channels are
+	// spread over; more connections avoid one socket becoming the bottleneck
+	Connections int
+	// Mandatory publishes with the mandatory flag, so a message no queue is
+	// bound for is returned and the publish fails with a *ReturnedError
+	// instead of the broker acking and dropping it. Only effective in confirm
+	// mode. Messages routed to an alternate exchange count as routed.
+	Mandatory bool
+	// ChannelIdleTimeout, when positive, closes pooled channels no publish
+	// has used for this long, freeing broker resources in quiet periods;
+	// they are reopened on demand
+	ChannelIdleTimeout time.Duration
+	// FailFastWhenBlocked fails publishes with ErrBlocked while the broker
+	// blocks a connection, instead of letting them wait out the confirm timeout
+	FailFastWhenBlocked bool
+
+	// StartupConnectMaxWait bounds how long the first connection is retried
+	// before Started reports failure
+	StartupConnectMaxWait time.Duration
+
+	// SpoolDir enables the on-disk spool when non-empty
+	SpoolDir             string
+	SpoolMaxBytes        int64
+	SpoolReplayBatchSize int
+	SpoolReplayInterval  time.Duration
+}
+
 // Publisher handles message publishing to RabbitMQ
 type Publisher struct {
-	conn                  *amqp.Connection
-	channel               *amqp.Channel
-	confirms              <-chan amqp.Confirmation
+	conns                 []*amqp.Connection
+	connections           int
+	nextConn              atomic.Uint64
+	pool                  *channelPool
 	exchange              string
 	logger                *zap.Logger
 	maxRetries            int
 	retryBaseDelay        time.Duration
+	maxBackoff            time.Duration
 	publishConfirmTimeout time.Duration
+	confirmTimeoutPerMsg  time.Duration
+	maxConfirmTimeout     time.Duration
+	mode                  channelMode
+	mandatory             bool
+	confirmMode           string
 	rabbitMQURL           string
-	mu                    sync.Mutex
+	dialConfig            amqp.Config
+	topology              Topology
+	additionalTargets     []Target
+	fallback              *Target
+	deadLetter            *Target
+	deliveryMode          uint8
+	expiration            string
+	contentType           string
+	compress              bool
+	compressionLevel      int
+	mu                    sync.Mutex // taken via lock/unlock so the watchdog can see how long it is held
+	lockedAt              atomic.Int64
+	dialedConns           atomic.Pointer[[]*amqp.Connection]
+
+	lockWatchdogThreshold time.Duration
+	lockWatchdogReconnect bool
+
+	blockedMu           sync.Mutex
+	blocked             map[*amqp.Connection]string // blocked connections and the broker's reason
+	failFastWhenBlocked bool
+
+	spool                *Spool
+	spoolReplayBatchSize int
+	spoolReplayInterval  time.Duration
+	keepaliveInterval    time.Duration
+	maxConnLifetime      time.Duration
+	channelIdleTimeout   time.Duration
+	connectedAt          atomic.Int64 // unix nanoseconds when the current connections were installed
+	starting             atomic.Bool
+	confirmed            atomic.Uint64 // publishes that succeeded, for the shutdown summary
+	lastConfirmedAt      atomic.Int64  // unix nanoseconds of the last successful publish, 0 before the first
+	started              chan error
+	done                 chan struct{}
+	closeOnce            sync.Once
+	wg                   sync.WaitGroup
 }
 
 // NewPublisher creates a new RabbitMQ publisher with durable exchange
-func NewPublisher(rabbitMQURL, exchange string, maxRetries, retryBaseDelayMs, confirmTimeoutSec int, logger *zap.Logger) (*Publisher, error) {
+func NewPublisher(opts Options, logger *zap.Logger) (*Publisher, error) {
 	p := &Publisher{
-		exchange:              exchange,
+		exchange:              opts.Exchange,
 		logger:                logger,
-		maxRetries:            maxRetries,
-		retryBaseDelay:        time.Duration(retryBaseDelayMs) * time.Millisecond,
-		publishConfirmTimeout: time.Duration(confirmTimeoutSec) * time.Second,
-		rabbitMQURL:           rabbitMQURL,
+		maxRetries:            opts.MaxRetries,
+		retryBaseDelay:        opts.RetryBaseDelay,
+		maxBackoff:            opts.MaxBackoff,
+		publishConfirmTimeout: opts.PublishConfirmTimeout,
+		confirmTimeoutPerMsg:  opts.ConfirmTimeoutPerMessage,
+		maxConfirmTimeout:     opts.MaxConfirmTimeout,
+		mode:                  modeConfirm,
+		confirmMode:           opts.ConfirmMode,
+		rabbitMQURL:           opts.URL,
+		dialConfig: amqp.Config{
+			Heartbeat: opts.Heartbeat,
+			Vhost:     opts.Vhost,
+			Dial:      amqp.DefaultDial(opts.DialTimeout),
+		},
+		topology:             opts.Topology,
+		additionalTargets:    opts.AdditionalTargets,
+		fallback:             opts.Fallback,
+		deadLetter:           opts.DeadLetter,
+		deliveryMode:         amqp.Persistent,
+		contentType:          "application/json",
+		compress:             opts.Compress,
+		compressionLevel:     opts.CompressionLevel,
+		connections:          max(opts.Connections, 1),
+		pool:                 newChannelPool(opts.PoolSize),
+		spoolReplayBatchSize: opts.SpoolReplayBatchSize,
+		spoolReplayInterval:  opts.SpoolReplayInterval,
+		keepaliveInterval:    opts.KeepaliveInterval,
+		maxConnLifetime:      opts.MaxConnectionLifetime,
+		channelIdleTimeout:   opts.ChannelIdleTimeout,
+		started:              make(chan error, 1),
+		done:                 make(chan struct{}),
+		blocked:              make(map[*amqp.Connection]string),
+		failFastWhenBlocked:  opts.FailFastWhenBlocked,
+
+		lockWatchdogThreshold: opts.LockWatchdogThreshold,
+		lockWatchdogReconnect: opts.LockWatchdogReconnect,
 	}
 
-	if err := p.connect(); err != nil {
-		return nil, err
+	if opts.Atomic {
+		p.mode = modeTx
+	} else if opts.NoConfirms {
+		p.mode = modeNoConfirm
+	}
+	p.mandatory = opts.Mandatory && p.mode == modeConfirm
+	if opts.ContentType != "" {
+		p.contentType = opts.ContentType
+	}
+	if opts.Transient {
+		p.deliveryMode = amqp.Transient
+	}
+	if opts.MessageTTL > 0 {
+		p.expiration = strconv.FormatInt(opts.MessageTTL.Milliseconds(), 10)
+	}
+
+	if opts.SpoolDir != "" {
+		spool, err := NewSpool(opts.SpoolDir, opts.SpoolMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		p.spool = spool
+	}
+
+	window := opts.StartupConnectMaxWait
+	if window <= 0 {
+		window = defaultStartupConnectMaxWait
+	}
+	p.starting.Store(true)
+	p.wg.Add(1)
+	go p.startup(window)
+
+	// Started before the first connect, which holds the lock while dialing
+	if p.lockWatchdogThreshold > 0 {
+		p.wg.Add(1)
+		go p.lockWatchdog()
+	}
+
+	if p.spool != nil {
+		p.logger.Info("RabbitMQ publish spool enabled",
+			zap.String("dir", opts.SpoolDir),
+			zap.Int64("max_bytes", opts.SpoolMaxBytes),
+		)
 	}
 
 	return p, nil
 }
 
-// connect establishes connection and channel to RabbitMQ
+// startup connects in the background, retrying for up to window, and starts
+// the background workers once connected. Until then the publisher is not
+// ready and publishes fail without dialing the broker themselves.
+func (p *Publisher) startup(window time.Duration) {
+	defer p.wg.Done()
+
+	err := p.connectWithRetry(window)
+	p.starting.Store(false)
+	if err == nil {
+		if p.keepaliveInterval > 0 {
+			p.wg.Add(1)
+			go p.keepalive()
+		}
+		if p.maxConnLifetime > 0 {
+			p.wg.Add(1)
+			go p.rotateConnections()
+		}
+		if p.channelIdleTimeout > 0 {
+			p.wg.Add(1)
+			go p.reapIdleChannels()
+		}
+		if p.spool != nil {
+			p.wg.Add(1)
+			go p.replaySpool()
+		}
+	}
+	p.started <- err
+}
+
+// Started delivers the outcome of the initial connection once: nil when
+// connected, or an error when the startup window elapsed without success
+func (p *Publisher) Started() <-chan error {
+	return p.started
+}
+
+// connect establishes the broker connections and seeds the channel pool
 func (p *Publisher) connect() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.lock()
+	defer p.unlock()
+
+	// Close existing channels and connections if any
+	p.pool.drain()
+	closeConns(p.conns)
+	p.conns = nil
+	p.setDialedConns(nil)
+
+	conns, pc, err := p.dial(p.setDialedConns)
+	if err != nil {
+		connectionUp.Set(0)
+		return err
+	}
+
+	p.conns = conns
+	p.pool.putIdle(pc)
+	p.connectedAt.Store(time.Now().UnixNano())
+	connectionUp.Set(1)
+
+	p.logger.Info("RabbitMQ publisher connected",
+		zap.String("exchange", p.exchange),
+		zap.Bool("confirms", p.mode == modeConfirm),
+		zap.Bool("transactional", p.mode == modeTx),
+		zap.Bool("mandatory", p.mandatory),
+		zap.Int("pool_size", cap(p.pool.slots)),
+		zap.Int("connections", len(conns)),
+	)
 
-	// Close existing connections if any
-	if p.channel != nil {
-		p.channel.Close()
+	return nil
+}
+
+// dial opens the broker connections, declares the topology and opens a
+// first channel. onDialed, when set, is called with the connections dialed so
+// far after each one. On error every connection dialed is closed again.
+func (p *Publisher) dial(onDialed func([]*amqp.Connection)) ([]*amqp.Connection, *pooledChannel, error) {
+	conns := make([]*amqp.Connection, 0, p.connections)
+	for i := 0; i < p.connections; i++ {
+		conn, err := amqp.DialConfig(p.rabbitMQURL, p.dialConfig)
+		if err != nil {
+			closeConns(conns)
+			return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		}
+		conns = append(conns, conn)
+		if onDialed != nil {
+			onDialed(conns)
+		}
+		go p.watchBlocked(conn, conn.NotifyBlocked(make(chan amqp.Blocking, 1)))
 	}
-	if p.conn != nil {
-		p.conn.Close()
+
+	if err := p.declareTopology(conns[0]); err != nil {
+		closeConns(conns)
+		return nil, nil, err
 	}
 
-	conn, err := amqp.Dial(p.rabbitMQURL)
+	// Open the first channel eagerly so a broken broker fails fast
+	pc, err := openChannel(conns[0], p.mode, p.mandatory)
 	if err != nil {
-		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		closeConns(conns)
+		return nil, nil, err
 	}
+	return conns, pc, nil
+}
 
-	channel, err := conn.Channel()
-	if err != nil {
+// closeConns closes every connection in conns
+func closeConns(conns []*amqp.Connection) {
+	for _, conn := range conns {
 		conn.Close()
-		return fmt.Errorf("failed to open channel: %w", err)
 	}
+}
 
-	// Enable publish confirms
-	if err := channel.Confirm(false); err != nil {
-		channel.Close()
-		conn.Close()
-		return fmt.Errorf("failed to enable confirm mode: %w", err)
+// currentConns returns the live connections, or nil if any of them is closed
+func (p *Publisher) currentConns() []*amqp.Connection {
+	p.lock()
+	defer p.unlock()
+
+	for _, conn := range p.conns {
+		if conn.IsClosed() {
+			return nil
+		}
 	}
+	return p.conns
+}
 
-	// Setup confirmation channel
-	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+// connectWithRetry retries connect with the publish backoff until it succeeds
+// or the next attempt would start after window has elapsed
+func (p *Publisher) connectWithRetry(window time.Duration) error {
+	deadline := time.Now().Add(window)
+	for attempt := 1; ; attempt++ {
+		err := p.connect()
+		if err == nil {
+			return nil
+		}
 
-	p.conn = conn
-	p.channel = channel
-	p.confirms = confirms
+		delay := p.backoff(attempt)
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("giving up after %d connection attempts: %w", attempt, err)
+		}
+		p.logger.Warn("Initial RabbitMQ connection failed, retrying",
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+		select {
+		case <-p.done:
+			return errClosed
+		case <-time.After(delay):
+		}
+	}
+}
 
-	p.logger.Info("RabbitMQ publisher connected",
-		zap.String("exchange", p.exchange),
-	)
+// Ping performs a cheap broker round-trip: it opens a short-lived channel and
+// passively declares the publish exchange, which fails if the exchange is
+// gone. The AMQP calls cannot be canceled, so on ctx expiry Ping returns
+// early and leaves them to finish in the background. Ping also fails with
+// ErrBlocked while the broker blocks a connection.
+func (p *Publisher) Ping(ctx context.Context) error {
+	if p.starting.Load() {
+		return errStillConnecting
+	}
+	conns := p.currentConns()
+	if conns == nil {
+		return fmt.Errorf("%w: connection is closed", ErrNotConnected)
+	}
+	if err := p.blockedErr(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// Every connection is checked, since any of them may have gone stale
+		for _, conn := range conns {
+			if err := p.pingConn(conn); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pingConn opens a channel on conn and passively declares the publish exchange
+func (p *Publisher) pingConn(conn *amqp.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
 
+	// The default exchange always exists and cannot be declared
+	if p.exchange != "" {
+		if err := ch.ExchangeDeclarePassive(p.exchange, "topic", true, false, false, false, nil); err != nil {
+			return fmt.Errorf("exchange %q is not available: %w", p.exchange, err)
+		}
+	}
 	return nil
 }
 
-// isHealthy checks if connection and channel are open
+// isHealthy checks if every connection is open
 func (p *Publisher) isHealthy() bool {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	return p.currentConns() != nil
+}
+
+// checkout acquires a publish slot and returns a usable channel, reusing an
+// idle one when possible. The caller must return it with checkin.
+func (p *Publisher) checkout(ctx context.Context) (*pooledChannel, error) {
+	start := time.Now()
+	if err := p.pool.acquire(ctx); err != nil {
+		return nil, err
+	}
+	poolWaitSeconds.Observe(time.Since(start).Seconds())
+
+	conns := p.currentConns()
+	if conns == nil {
+		p.pool.release()
+		return nil, fmt.Errorf("%w: connection is closed", ErrNotConnected)
+	}
 
-	if p.conn == nil || p.conn.IsClosed() {
-		return false
+	for pc := p.pool.takeIdle(); pc != nil; pc = p.pool.takeIdle() {
+		if slices.Contains(conns, pc.conn) && !pc.ch.IsClosed() {
+			p.pool.checkedOut()
+			return pc, nil
+		}
+		pc.ch.Close()
 	}
-	if p.channel == nil {
-		return false
+
+	// New channels are spread round-robin over the connections
+	conn := conns[p.nextConn.Add(1)%uint64(len(conns))]
+	pc, err := openChannel(conn, p.mode, p.mandatory)
+	if err != nil {
+		p.pool.release()
+		return nil, err
 	}
-	return true
+	p.pool.checkedOut()
+	return pc, nil
+}
+
+// checkin returns a channel to the pool and frees its slot. Channels that
+// failed a publish are closed instead, since a late confirmation could
+// otherwise be mistaken for the next publish's.
+func (p *Publisher) checkin(pc *pooledChannel, ok bool) {
+	p.lock()
+	current := slices.Contains(p.conns, pc.conn)
+	p.unlock()
+
+	p.pool.checkedIn()
+	if ok && current && !pc.ch.IsClosed() {
+		p.pool.putIdle(pc)
+	} else {
+		pc.ch.Close()
+	}
+	p.pool.release()
 }
 
 // reconnect attempts to reconnect to RabbitMQ
 func (p *Publisher) reconnect() error {
+	// Another concurrent publish may already have reconnected
+	if p.isHealthy() {
+		return nil
+	}
+	select {
+	case <-p.done:
+		return errClosed
+	default:
+	}
+	// The first connection is owned by startup
+	if p.starting.Load() {
+		return errStillConnecting
+	}
 	p.logger.Warn("Attempting to reconnect to RabbitMQ")
-	return p.connect()
+	reconnectAttempts.Inc()
+	if err := p.connect(); err != nil {
+		reconnectFailures.Inc()
+		return err
+	}
+	return nil
 }
 
-// Publish publishes a message with retry logic and confirmation
-func (p *Publisher) Publish(ctx context.Context, routingKey string, message interface{}) error {
+// Publish publishes a message with retry logic and confirmation. Its logs
+// go to the logger carried by ctx (see logging.WithLogger), if any, so they
+// carry the fields of the request being published. Once an attempt was
+// made, a failure is an *AttemptsError.
+func (p *Publisher) Publish(ctx context.Context, routingKey string, message interface{}, opts PublishOptions) (err error) {
 	body, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
+	attempts := 0
+	defer func() {
+		if err != nil && attempts > 0 {
+			err = &AttemptsError{Attempts: attempts, Err: err}
+		}
+	}()
+
+	logger := logging.FromContext(ctx, p.logger)
 	var lastErr error
+	uncertain := false // set once an attempt may have been delivered
 	for attempt := 1; attempt <= p.maxRetries; attempt++ {
+		attempts = attempt
+		// Stop retrying once the caller's deadline has passed
+		if err := ctx.Err(); err != nil {
+			return withDeliveryOutcome(err, uncertain)
+		}
+
+		// A blocked connection would only hang until the confirm timeout
+		if p.failFastWhenBlocked {
+			if err := p.blockedErr(); err != nil {
+				return p.spoolOrFail(logger, routingKey, body, opts, withDeliveryOutcome(err, uncertain))
+			}
+		}
+
 		// Check connection health before publishing
 		if !p.isHealthy() {
-			p.logger.Warn("Connection unhealthy, attempting reconnect",
+			connectionUp.Set(0)
+			logger.Warn("Connection unhealthy, attempting reconnect",
 				zap.Int("attempt", attempt),
 			)
 			if err := p.reconnect(); err != nil {
-				lastErr = fmt.Errorf("reconnect failed: %w", err)
-				p.logger.Error("Reconnection failed",
+				lastErr = fmt.Errorf("%w: reconnect failed: %w", ErrNotConnected, err)
+				logger.Error("Reconnection failed",
 					zap.Int("attempt", attempt),
 					zap.Error(err),
 				)
 
 				if attempt < p.maxRetries {
-					delay := p.retryBaseDelay * time.Duration(1<<uint(attempt-1))
 					select {
 					case <-ctx.Done():
-						return ctx.Err()
-					case <-time.After(delay):
+						return withDeliveryOutcome(ctx.Err(), uncertain)
+					case <-time.After(p.backoff(attempt)):
 						continue
 					}
 				}
@@ -142,94 +638,286 @@ func (p *Publisher) Publish(ctx context.Context, routingKey string, message inte
 			}
 		}
 
-		if err := p.publishWithConfirm(ctx, routingKey, body); err != nil {
+		if err := p.publishWithConfirm(ctx, routingKey, body, opts); err != nil {
 			lastErr = err
-			p.logger.Warn("Publish attempt failed",
+			if errors.Is(err, ErrDeliveryUnknown) {
+				uncertain = true
+			}
+			logger.Warn("Publish attempt failed",
 				zap.Int("attempt", attempt),
 				zap.Int("max_retries", p.maxRetries),
 				zap.Error(err),
 			)
 
 			if attempt < p.maxRetries {
-				delay := p.retryBaseDelay * time.Duration(1<<uint(attempt-1)) // exponential backoff
 				select {
 				case <-ctx.Done():
-					return ctx.Err()
-				case <-time.After(delay):
+					return withDeliveryOutcome(ctx.Err(), uncertain)
+				case <-time.After(p.backoff(attempt)):
 					// Continue to next retry
 				}
 			}
 			continue
 		}
 
-		p.logger.Debug("Message published successfully",
+		logger.Debug("Message published successfully",
 			zap.String("routing_key", routingKey),
 			zap.Int("attempt", attempt),
 		)
+		p.recordConfirmed()
 		return nil
 	}
 
-	return fmt.Errorf("failed to publish after %d attempts: %w", p.maxRetries, lastErr)
+	publishErr := withDeliveryOutcome(fmt.Errorf("failed to publish after %d attempts: %w", p.maxRetries, lastErr), uncertain)
+
+	// Last resort before the spool: keep data flowing through the fallback
+	if p.fallback != nil && ctx.Err() == nil {
+		fallbackErr := p.publishFallback(ctx, routingKey, body, opts)
+		if fallbackErr == nil {
+			p.recordConfirmed()
+			return nil
+		}
+		publishErr = withDeliveryOutcome(fmt.Errorf("%w; fallback publish failed: %v", publishErr, fallbackErr), errors.Is(fallbackErr, ErrDeliveryUnknown))
+	}
+
+	return p.spoolOrFail(logger, routingKey, body, opts, publishErr)
+}
+
+// spoolOrFail handles a publish that failed with publishErr, logging to
+// logger. It returns nil if the message could be spooled, and publishErr
+// otherwise.
+func (p *Publisher) spoolOrFail(logger *zap.Logger, routingKey string, body []byte, opts PublishOptions, publishErr error) error {
+	// Fall back to the on-disk spool so the message is replayed once the broker recovers
+	if p.spool != nil && !opts.NoSpool {
+		if err := p.spool.Append(routingKey, body, opts.Priority); err != nil {
+			logger.Error("Failed to spool message",
+				zap.String("routing_key", routingKey),
+				zap.Error(err),
+			)
+			return publishErr
+		}
+		logger.Warn("Message spooled to disk after publish failure",
+			zap.String("routing_key", routingKey),
+			zap.Error(publishErr),
+		)
+		return nil
+	}
+
+	return publishErr
 }
 
-func (p *Publisher) publishWithConfirm(ctx context.Context, routingKey string, body []byte) error {
-	p.mu.Lock()
-	channel := p.channel
-	confirms := p.confirms
-	p.mu.Unlock()
+// backoff returns the delay before the retry following attempt, using
+// exponential backoff capped at maxBackoff with full jitter
+func (p *Publisher) backoff(attempt int) time.Duration {
+	return jitteredBackoff(p.retryBaseDelay, p.maxBackoff, attempt)
+}
 
-	if channel == nil {
-		return fmt.Errorf("channel is nil")
+// jitteredBackoff picks a uniformly random delay in [0, min(base*2^(attempt-1), ceiling)]
+func jitteredBackoff(base, ceiling time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
 	}
 
-	err := channel.PublishWithContext(
-		ctx,
-		p.exchange,
-		routingKey,
-		false, // mandatory
-		false, // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-			Timestamp:    time.Now(),
-		},
-	)
+	delay := base
+	for i := 1; i < attempt; i++ {
+		if ceiling > 0 && delay >= ceiling {
+			break
+		}
+		if delay > math.MaxInt64/2 {
+			delay = math.MaxInt64
+			break
+		}
+		delay *= 2
+	}
+	if ceiling > 0 && delay > ceiling {
+		delay = ceiling
+	}
+
+	// The range [0, MaxInt64] cannot be passed to Int64N, which would overflow
+	if delay == math.MaxInt64 {
+		return time.Duration(rand.Int64())
+	}
+	return time.Duration(rand.Int64N(int64(delay) + 1))
+}
+
+// publishWithConfirm publishes body to the primary exchange and every
+// additional target, waiting for the confirms
+func (p *Publisher) publishWithConfirm(ctx context.Context, routingKey string, body []byte, opts PublishOptions) error {
+	targets := append([]Target{{Exchange: p.exchange}}, p.additionalTargets...)
+	return p.publishTo(ctx, targets, nil, routingKey, body, opts)
+}
+
+// publishTo publishes body with headers to targets on a pooled channel
+func (p *Publisher) publishTo(ctx context.Context, targets []Target, headers amqp.Table, routingKey string, body []byte, opts PublishOptions) error {
+	// Compress here rather than in Publish so spooled records stay plain JSON
+	contentEncoding := ""
+	if p.compress {
+		compressed, err := gzipBody(body, p.compressionLevel)
+		if err != nil {
+			return err
+		}
+		body, contentEncoding = compressed, "gzip"
+	}
+
+	pc, err := p.checkout(ctx)
 	if err != nil {
-		return fmt.Errorf("publish failed: %w", err)
+		return err
 	}
 
-	// Wait for confirmation
-	select {
-	case confirm := <-confirms:
-		if !confirm.Ack {
-			return fmt.Errorf("publish not acknowledged by broker")
+	err = p.publishOn(ctx, pc, targets, headers, routingKey, body, contentEncoding, opts)
+	p.checkin(pc, err == nil)
+	return err
+}
+
+// publishOn publishes body to every target (the primary exchange and every
+// additional target, or the fallback) on a checked-out channel, then waits
+// until all copies are confirmed.
+// The copies are not atomic unless the channel is transactional: if any of
+// them fails the whole publish fails and is retried, so targets that already
+// confirmed may receive duplicates.
+func (p *Publisher) publishOn(ctx context.Context, pc *pooledChannel, targets []Target, headers amqp.Table, routingKey string, body []byte, contentEncoding string, opts PublishOptions) error {
+	pc.tracker.reset()
+
+	for _, target := range targets {
+		key := routingKey
+		if target.RoutingKey != "" {
+			key = target.RoutingKey
+		}
+
+		tag := pc.ch.GetNextPublishSeqNo()
+		msgHeaders := headers
+		if p.mandatory {
+			msgHeaders = withPublishTag(headers, tag)
+		}
+		err := pc.ch.PublishWithContext(
+			ctx,
+			target.Exchange,
+			key,
+			p.mandatory,
+			false, // immediate
+			amqp.Publishing{
+				DeliveryMode:    p.deliveryMode,
+				Priority:        opts.Priority,
+				Expiration:      p.expiration,
+				ContentType:     p.contentType,
+				Headers:         msgHeaders,
+				ContentEncoding: contentEncoding,
+				Body:            body,
+				Timestamp:       time.Now(),
+			},
+		)
+		if err != nil {
+			if p.mode == modeTx {
+				pc.ch.TxRollback()
+			}
+			return fmt.Errorf("publish to exchange %q failed: %w", target.Exchange, err)
+		}
+		pc.tracker.track(tag)
+	}
+
+	switch p.mode {
+	case modeNoConfirm:
+		return nil
+	case modeTx:
+		// Nothing is routed until the commit, which fails as a whole; the
+		// broker may still have applied a commit whose reply was lost
+		if err := pc.ch.TxCommit(); err != nil {
+			return fmt.Errorf("%w: transaction commit failed: %w", ErrDeliveryUnknown, err)
 		}
 		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-time.After(p.publishConfirmTimeout):
-		return fmt.Errorf("confirmation timeout")
 	}
+
+	// Wait for every confirmation
+	return deliveryOutcome(p.waitConfirms(ctx, pc, routingKey))
 }
 
-// Close closes the RabbitMQ connection
-func (p *Publisher) Close() error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// deliveryOutcome marks a failed confirm wait as ErrDeliveryUnknown unless
+// the broker nacked or returned the message, which means it was definitely
+// not routed
+func deliveryOutcome(err error) error {
+	var nackErr *NackError
+	var returnedErr *ReturnedError
+	if err == nil || errors.As(err, &nackErr) || errors.As(err, &returnedErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrDeliveryUnknown, err)
+}
 
-	if p.channel != nil {
-		if err := p.channel.Close(); err != nil {
-			p.logger.Error("Failed to close channel", zap.Error(err))
-		}
-		p.channel = nil
+// withDeliveryOutcome marks err as ErrDeliveryUnknown when an earlier publish
+// attempt may have been delivered, even if the last one definitely was not
+func withDeliveryOutcome(err error, uncertain bool) error {
+	if !uncertain || errors.Is(err, ErrDeliveryUnknown) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrDeliveryUnknown, err)
+}
+
+// Drain waits until no publish is in flight, so pending confirms are
+// received before Close tears the channels down. It gives up when ctx is done.
+func (p *Publisher) Drain(ctx context.Context) error {
+	if err := p.pool.acquireAll(ctx); err != nil {
+		return fmt.Errorf("publishes still in flight: %w", err)
+	}
+	p.pool.releaseAll()
+	return nil
+}
+
+// InFlight returns the number of publishes currently holding a pool slot,
+// which includes those still waiting for a broker confirm
+func (p *Publisher) InFlight() int {
+	return len(p.pool.slots)
+}
+
+// Confirmed returns the number of publishes that have succeeded since the
+// publisher was created
+func (p *Publisher) Confirmed() uint64 {
+	return p.confirmed.Load()
+}
+
+// recordConfirmed counts a successful publish and records when it happened
+func (p *Publisher) recordConfirmed() {
+	p.confirmed.Add(1)
+	p.lastConfirmedAt.Store(time.Now().UnixNano())
+}
+
+// LastConfirmed returns when a publish, or a spool replay, last succeeded,
+// or the zero time if none has yet
+func (p *Publisher) LastConfirmed() time.Time {
+	lastConfirmedAt := p.lastConfirmedAt.Load()
+	if lastConfirmedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lastConfirmedAt)
+}
+
+// Close closes the RabbitMQ connection. Calls after the first return nil.
+func (p *Publisher) Close() error {
+	first := false
+	p.closeOnce.Do(func() {
+		first = true
+		close(p.done)
+	})
+	if !first {
+		return nil
 	}
-	if p.conn != nil {
-		if err := p.conn.Close(); err != nil {
+	p.wg.Wait()
+
+	p.lock()
+	defer p.unlock()
+
+	p.pool.drain()
+	var closeErr error
+	for _, conn := range p.conns {
+		if err := conn.Close(); err != nil {
 			p.logger.Error("Failed to close connection", zap.Error(err))
-			return err
+			closeErr = err
 		}
-		p.conn = nil
+	}
+	p.conns = nil
+	p.setDialedConns(nil)
+	connectionUp.Set(0)
+	if closeErr != nil {
+		return closeErr
 	}
 	p.logger.Info("RabbitMQ publisher closed")
 	return nil
@@ -0,0 +1,127 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJitteredBackoffBounds(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    time.Duration
+		ceiling time.Duration
+		attempt int
+		max     time.Duration
+	}{
+		{name: "first attempt", base: 100 * time.Millisecond, ceiling: 5 * time.Second, attempt: 1, max: 100 * time.Millisecond},
+		{name: "doubles per attempt", base: 100 * time.Millisecond, ceiling: 5 * time.Second, attempt: 4, max: 800 * time.Millisecond},
+		{name: "capped", base: 100 * time.Millisecond, ceiling: 5 * time.Second, attempt: 10, max: 5 * time.Second},
+		{name: "uncapped", base: 100 * time.Millisecond, attempt: 8, max: 12800 * time.Millisecond},
+		{name: "no overflow", base: time.Second, attempt: 200, max: time.Duration(1<<63 - 1)},
+		{name: "zero base", base: 0, ceiling: time.Second, attempt: 3, max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seenLow, seenHigh bool
+			for i := 0; i < 2000; i++ {
+				d := jitteredBackoff(tt.base, tt.ceiling, tt.attempt)
+				if d < 0 || d > tt.max {
+					t.Fatalf("jitteredBackoff = %s, want within [0, %s]", d, tt.max)
+				}
+				seenLow = seenLow || d <= tt.max/4
+				seenHigh = seenHigh || d >= tt.max*3/4
+			}
+			// Full jitter spreads delays over the whole range
+			if tt.max > 0 && (!seenLow || !seenHigh) {
+				t.Errorf("delays did not spread over [0, %s]: low=%t high=%t", tt.max, seenLow, seenHigh)
+			}
+		})
+	}
+}
+
+func TestPublishStopsRetryingWhenContextCanceled(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(func(fakeMessage) fakeAction { return fakeNack })
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.MaxRetries = 5
+		o.RetryBaseDelay = time.Hour
+		o.MaxBackoff = time.Hour
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := p.Publish(ctx, "meter", map[string]string{}, PublishOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Publish = %v, want the context's deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Publish returned after %s, want it to stop waiting for the backoff once canceled", elapsed)
+	}
+}
+
+func TestPublishAttemptsAreCounted(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(func(fakeMessage) fakeAction { return fakeNack })
+	p := newTestPublisher(t, b, func(o *Options) { o.MaxRetries = 3 })
+
+	err := p.Publish(context.Background(), "meter", map[string]string{}, PublishOptions{})
+	var attemptsErr *AttemptsError
+	if !errors.As(err, &attemptsErr) || attemptsErr.Attempts != 3 {
+		t.Fatalf("Publish = %v, want an *AttemptsError after 3 attempts", err)
+	}
+	var nackErr *NackError
+	if !errors.As(err, &nackErr) {
+		t.Errorf("Publish = %v, want the nack as the cause", err)
+	}
+	if n := len(b.messages()); n != 0 {
+		t.Errorf("broker routed %d messages, want none", n)
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	p := newTestPublisher(t, newFakeBroker(t), nil)
+	if err := p.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("second Close = %v, want nil", err)
+	}
+}
+
+func TestPublishSetsPriority(t *testing.T) {
+	b := newFakeBroker(t)
+	p := newTestPublisher(t, b, nil)
+
+	for _, priority := range []uint8{0, 7} {
+		if err := p.Publish(context.Background(), "meter", map[string]uint8{"priority": priority}, PublishOptions{Priority: priority}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	msgs := b.messages()
+	if len(msgs) != 2 || msgs[0].Priority != 0 || msgs[1].Priority != 7 {
+		t.Errorf("broker received priorities %v, want 0 and 7", msgs)
+	}
+}
+
+func TestPriorityQueueDeclaration(t *testing.T) {
+	for _, maxPriority := range []uint8{0, 10} {
+		b := newFakeBroker(t)
+		newTestPublisher(t, b, func(o *Options) {
+			o.Topology = Topology{DeclareQueue: true, QueueName: "readings", ExchangeType: "topic", BindingKey: "#", MaxPriority: maxPriority}
+		})
+		args, ok := b.declaredQueue("readings")
+		if !ok {
+			t.Fatal("queue was not declared")
+		}
+		got, set := args["x-max-priority"]
+		if maxPriority == 0 && set {
+			t.Errorf("x-max-priority = %v, want none without a maximum priority", got)
+		}
+		if maxPriority > 0 && got != int32(maxPriority) {
+			t.Errorf("x-max-priority = %v, want %d", got, maxPriority)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package mq
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// reapIdleChannels closes the pooled channels left idle for longer than the
+// idle timeout. The connections stay open, so the next publish that needs a
+// channel reopens one at the cost of a channel.open round-trip.
+func (p *Publisher) reapIdleChannels() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(max(p.channelIdleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if n := p.pool.reap(p.channelIdleTimeout); n > 0 {
+				reapedChannels.Add(float64(n))
+				p.logger.Debug("Closed idle publisher channels",
+					zap.Int("closed", n),
+					zap.Duration("idle_timeout", p.channelIdleTimeout),
+				)
+			}
+		}
+	}
+}
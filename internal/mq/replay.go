@@ -0,0 +1,116 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// replaySpool periodically drains the spool back to RabbitMQ, in order.
+// Records are only removed from the spool after the broker confirms them,
+// so a crash mid-replay results in redelivery (at-least-once).
+func (p *Publisher) replaySpool() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.spoolReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if !p.isHealthy() {
+				continue
+			}
+			for p.replayBatch() {
+				select {
+				case <-p.done:
+					return
+				default:
+				}
+			}
+		}
+	}
+}
+
+// replayBatch publishes one batch of spooled records and reports whether the
+// whole batch was replayed and more records may remain
+func (p *Publisher) replayBatch() bool {
+	records, err := p.spool.Peek(p.spoolReplayBatchSize)
+	if err != nil {
+		p.logger.Error("Failed to read spool", zap.Error(err))
+		return false
+	}
+	if len(records) == 0 {
+		return false
+	}
+
+	replayed := 0
+	if p.confirmMode == ConfirmModeBatch {
+		replayed = p.replayConfirmBatch(records)
+	} else {
+		for _, rec := range records {
+			ctx, cancel := context.WithTimeout(context.Background(), p.publishConfirmTimeout)
+			err := p.publishWithConfirm(ctx, rec.RoutingKey, rec.Body, PublishOptions{Priority: rec.Priority})
+			cancel()
+			if err != nil {
+				p.logger.Warn("Spool replay attempt failed",
+					zap.String("routing_key", rec.RoutingKey),
+					zap.Error(err),
+				)
+				break
+			}
+			replayed++
+		}
+	}
+
+	if replayed > 0 {
+		p.lastConfirmedAt.Store(time.Now().UnixNano())
+		if err := p.spool.Commit(records[replayed-1].line + 1); err != nil {
+			p.logger.Error("Failed to commit spool replay", zap.Error(err))
+			return false
+		}
+		p.logger.Info("Replayed spooled messages",
+			zap.Int("count", replayed),
+		)
+	}
+
+	return replayed == len(records) && len(records) == p.spoolReplayBatchSize
+}
+
+// replayConfirmBatch publishes records as one batch with a single confirm
+// wait and returns how many leading records were confirmed. The spool only
+// commits a prefix, so records confirmed after the first nack are replayed
+// again and may be delivered twice.
+func (p *Publisher) replayConfirmBatch(records []spoolRecord) int {
+	msgs := make([]BatchMessage, len(records))
+	for i, rec := range records {
+		msgs[i] = BatchMessage{RoutingKey: rec.RoutingKey, Body: rec.Body, Opts: PublishOptions{Priority: rec.Priority}}
+	}
+
+	// The batch waits for all of its confirms at once, so it gets the
+	// timeout scaled to its size
+	ctx, cancel := context.WithTimeout(context.Background(), p.confirmTimeout(len(msgs)))
+	defer cancel()
+	err := p.PublishBatch(ctx, msgs)
+	if err == nil {
+		return len(records)
+	}
+
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		p.logger.Warn("Spool replay batch partially nacked",
+			zap.Int("batch_size", len(records)),
+			zap.Ints("failed", batchErr.Failed),
+		)
+		return batchErr.Failed[0]
+	}
+	p.logger.Warn("Spool replay batch failed",
+		zap.Int("batch_size", len(records)),
+		zap.Error(err),
+	)
+	return 0
+}
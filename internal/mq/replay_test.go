@@ -0,0 +1,86 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSpoolReplaysAfterRestart(t *testing.T) {
+	b := newFakeBroker(t)
+	dir := t.TempDir()
+	configure := func(o *Options) {
+		o.SpoolDir = dir
+		o.MaxRetries = 2
+	}
+
+	p := newTestPublisher(t, b, configure)
+	b.stop()
+
+	// With the broker down the publish fails and lands in the spool
+	message := map[string]string{"request_id": "r1"}
+	if err := p.Publish(context.Background(), "meter.readings", message, PublishOptions{Priority: 2}); err != nil {
+		t.Fatalf("Publish with the broker down = %v, want nil once spooled", err)
+	}
+	records, err := p.spool.Peek(10)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("spool holds %d records (%v), want 1", len(records), err)
+	}
+
+	// The process restarts, and so does the broker
+	p.Close()
+	b.restart()
+	p = newTestPublisher(t, b, configure)
+
+	if !waitFor(t, 5*time.Second, func() bool { return len(b.messages()) == 1 }) {
+		t.Fatalf("broker received %d messages, want the spooled one replayed", len(b.messages()))
+	}
+	got := b.messages()[0]
+	if got.RoutingKey != "meter.readings" || got.Priority != 2 || string(got.Body) != `{"request_id":"r1"}` {
+		t.Errorf("replayed message = %+v, want the spooled routing key, priority and body", got)
+	}
+	if !waitFor(t, time.Second, func() bool {
+		records, _ := p.spool.Peek(10)
+		return len(records) == 0
+	}) {
+		t.Error("spool still holds the replayed record")
+	}
+}
+
+func TestLargeReplayBatchGetsScaledConfirmTimeout(t *testing.T) {
+	const records = 20
+	dir := t.TempDir()
+	spool, err := NewSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < records; i++ {
+		if err := spool.Append("meter.readings", []byte(fmt.Sprintf(`{"n":%d}`, i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := newFakeBroker(t)
+	// Acks trail each other by 10ms, so the last of the batch arrives after
+	// ~200ms: past the 100ms base timeout, within the scaled 290ms
+	b.setAckDelay(10 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.SpoolDir = dir
+		o.ConfirmMode = ConfirmModeBatch
+		o.PublishConfirmTimeout = 100 * time.Millisecond
+		o.ConfirmTimeoutPerMessage = 10 * time.Millisecond
+		o.PoolSize = 1
+	})
+
+	if !waitFor(t, 5*time.Second, func() bool {
+		remaining, _ := p.spool.Peek(records)
+		return len(remaining) == 0
+	}) {
+		t.Fatal("spool still holds records after replay")
+	}
+	// A batch timed out at the base timeout would be replayed again
+	if n := len(b.messages()); n != records {
+		t.Errorf("broker received %d messages, want each of the %d records once", n, records)
+	}
+}
@@ -0,0 +1,51 @@
+package mq_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/middleware"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/internal/service"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogFieldsReachPublisher(t *testing.T) {
+	b := mq.NewFakeBroker(t)
+	// Nacks make the publisher log each failed attempt
+	b.NackExchanges("meter")
+	p := mq.NewTestPublisher(t, b, nil)
+	core, logs := observer.New(zapcore.DebugLevel)
+	r := newIngestRouter(p, zap.New(core), func(o *service.Options) {
+		o.MultiTenancy = true
+		o.AllowedTenants = []string{"acme"}
+		o.TenantRoutingKeyTemplate = "meter.reading.{tenant}"
+	})
+
+	w := ingest(r, map[string]string{"X-Tenant-ID": "acme", "User-Agent": "collector/1.0"})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503: %s", w.Code, w.Body)
+	}
+	requestID := w.Header().Get(middleware.RequestIDHeader)
+
+	entries := logs.All()
+	publisherLogs := 0
+	for _, e := range entries {
+		fields := e.ContextMap()
+		if fields["request_id"] != requestID {
+			t.Errorf("%q logged with request_id %v, want %s", e.Message, fields["request_id"], requestID)
+		}
+		if e.Message != "Publish attempt failed" {
+			continue
+		}
+		publisherLogs++
+		if fields["tenant_id"] != "acme" || fields["client_fingerprint"] == "" || fields["client_fingerprint"] == nil {
+			t.Errorf("publisher logged %v, want the tenant and client fingerprint", fields)
+		}
+	}
+	if publisherLogs == 0 {
+		t.Fatalf("no publisher log among %d entries", len(entries))
+	}
+}
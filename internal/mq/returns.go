@@ -0,0 +1,77 @@
+package mq
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// PublishTagHeader carries the delivery tag of a mandatory publish. A
+// basic.return has no delivery tag of its own, so the header is how a return
+// is matched to the publish it belongs to.
+const PublishTagHeader = "x-publish-tag"
+
+// ReturnedError reports a mandatory publish the broker returned because no
+// queue was bound to route it to. The broker still acks such a message, so
+// without mandatory publishing it would have been dropped silently.
+type ReturnedError struct {
+	DeliveryTag uint64
+	Exchange    string
+	RoutingKey  string
+	ReplyCode   uint16
+	ReplyText   string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("publish to exchange %q with routing key %q returned by broker: %d %s (delivery tag %d)",
+		e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText, e.DeliveryTag)
+}
+
+// withPublishTag returns a copy of headers with PublishTagHeader set to tag
+func withPublishTag(headers amqp.Table, tag uint64) amqp.Table {
+	tagged := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		tagged[k] = v
+	}
+	tagged[PublishTagHeader] = int64(tag)
+	return tagged
+}
+
+// recordReturn marks the publish a return belongs to as failed. Returns
+// without a tag, or for a tag that is no longer pending, are only logged.
+func (p *Publisher) recordReturn(logger *zap.Logger, pc *pooledChannel, ret amqp.Return) {
+	returnedMessages.Inc()
+	tag, ok := ret.Headers[PublishTagHeader].(int64)
+	if !ok || !pc.tracker.markReturned(uint64(tag), ret) {
+		logger.Warn("Ignoring return of an unknown publish",
+			zap.String("exchange", ret.Exchange),
+			zap.String("routing_key", ret.RoutingKey),
+		)
+		return
+	}
+	logger.Warn("Publish returned as unroutable",
+		zap.String("exchange", ret.Exchange),
+		zap.String("routing_key", ret.RoutingKey),
+		zap.Uint16("reply_code", ret.ReplyCode),
+		zap.String("reply_text", ret.ReplyText),
+	)
+}
+
+// drainReturns records the returns already delivered on pc. The client
+// library dispatches the basic.return of a message before its basic.ack, on
+// the same goroutine, so by the time an ack is received any return for that
+// message is queued here, even if a select picked the ack first.
+func (p *Publisher) drainReturns(logger *zap.Logger, pc *pooledChannel) {
+	for {
+		select {
+		case ret, ok := <-pc.returns:
+			if !ok {
+				return
+			}
+			p.recordReturn(logger, pc, ret)
+		default:
+			return
+		}
+	}
+}
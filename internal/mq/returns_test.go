@@ -0,0 +1,88 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// returnUnroutable returns messages whose body contains "unroutable" and
+// acks the rest
+func returnUnroutable(m fakeMessage) fakeAction {
+	if strings.Contains(string(m.Body), "unroutable") {
+		return fakeReturn
+	}
+	return fakeAck
+}
+
+func TestReturnBeforeAckFailsPublish(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(returnUnroutable)
+	// The return is sent at once and the ack well after it, so the
+	// publisher sees the return while the tag is still pending
+	b.setAckDelay(50 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.Mandatory = true
+		o.MaxRetries = 1
+	})
+
+	err := p.Publish(context.Background(), "meter.reading.unbound", "unroutable", PublishOptions{})
+	var returned *ReturnedError
+	if !errors.As(err, &returned) {
+		t.Fatalf("Publish = %v, want a *ReturnedError", err)
+	}
+	if returned.Exchange != "meter" || returned.RoutingKey != "meter.reading.unbound" {
+		t.Errorf("returned from %s/%s, want meter/meter.reading.unbound", returned.Exchange, returned.RoutingKey)
+	}
+	if msgs := b.returnedMessages(); len(msgs) != 1 || !msgs[0].Mandatory {
+		t.Errorf("returned %+v, want one message with the mandatory flag", msgs)
+	}
+}
+
+func TestReturnDoesNotLeakIntoNextPublish(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(returnUnroutable)
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.Mandatory = true
+		o.PoolSize = 1 // every publish shares the channel
+	})
+
+	var returned *ReturnedError
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(context.Background(), "meter.reading.unbound", "unroutable", PublishOptions{}); !errors.As(err, &returned) {
+			t.Fatalf("returned publish %d = %v, want a *ReturnedError", i, err)
+		}
+	}
+	if err := p.Publish(context.Background(), "meter.reading.ingested", "routable", PublishOptions{}); err != nil {
+		t.Fatalf("Publish after two returns = %v, want nil", err)
+	}
+}
+
+func TestTwoReturnsInARowOnOneChannel(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(returnUnroutable)
+	b.setAckDelay(10 * time.Millisecond)
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.Mandatory = true
+		o.PoolSize = 1
+	})
+
+	// Both returns arrive back to back, before any ack, while the client
+	// library buffers only one of them
+	err := p.PublishBatch(context.Background(), []BatchMessage{
+		{RoutingKey: "meter.reading.ingested", Body: []byte(`"routable"`)},
+		{RoutingKey: "meter.reading.unbound", Body: []byte(`"unroutable 1"`)},
+		{RoutingKey: "meter.reading.unbound", Body: []byte(`"unroutable 2"`)},
+		{RoutingKey: "meter.reading.ingested", Body: []byte(`"routable"`)},
+	})
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("PublishBatch = %v, want a *BatchError", err)
+	}
+	if !reflect.DeepEqual(batchErr.Failed, []int{1, 2}) {
+		t.Errorf("failed indexes %v, want [1 2]", batchErr.Failed)
+	}
+}
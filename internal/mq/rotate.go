@@ -0,0 +1,103 @@
+package mq
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rotationQuietWait bounds how long a rotation holds back new publishes while
+// waiting for the ones in flight to finish
+const rotationQuietWait = time.Second
+
+// rotateConnections replaces the connections once they reach the maximum
+// lifetime. Each rotation is brought forward by a random fraction of up to a
+// tenth of the lifetime, so instances started together do not all reconnect
+// at once.
+func (p *Publisher) rotateConnections() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(max(p.maxConnLifetime/10, time.Second))
+	defer ticker.Stop()
+
+	jitter := rand.N(p.maxConnLifetime/10 + 1)
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			connectedAt := p.connectedAt.Load()
+			if connectedAt == 0 || time.Since(time.Unix(0, connectedAt)) < p.maxConnLifetime-jitter {
+				continue
+			}
+			if p.rotate() {
+				jitter = rand.N(p.maxConnLifetime/10 + 1)
+			}
+		}
+	}
+}
+
+// rotate dials replacement connections first, then swaps them in at a
+// moment when no publish is in flight and closes the old ones, so no publish
+// or pending confirm is cut off. Publishes arriving meanwhile wait for a pool
+// slot for at most rotationQuietWait. It reports whether the connections
+// were replaced; otherwise the next check tries again.
+func (p *Publisher) rotate() bool {
+	// New connections would be blocked just the same
+	if p.blockedErr() != nil {
+		return false
+	}
+
+	age := time.Since(time.Unix(0, p.connectedAt.Load()))
+	conns, pc, err := p.dial(nil)
+	if err != nil {
+		connectionRotations.WithLabelValues("failed").Inc()
+		p.logger.Warn("RabbitMQ connection rotation failed, keeping the current connections",
+			zap.Duration("age", age),
+			zap.Error(err),
+		)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rotationQuietWait)
+	err = p.pool.acquireAll(ctx)
+	cancel()
+	if err != nil {
+		closeConns(conns)
+		connectionRotations.WithLabelValues("deferred").Inc()
+		p.logger.Debug("RabbitMQ connection rotation deferred, publishes still in flight",
+			zap.Duration("age", age),
+		)
+		return false
+	}
+	defer p.pool.releaseAll()
+
+	// Close may already be waiting for this goroutine
+	select {
+	case <-p.done:
+		closeConns(conns)
+		return false
+	default:
+	}
+
+	p.lock()
+	old := p.conns
+	p.pool.drain()
+	p.conns = conns
+	p.setDialedConns(conns)
+	p.pool.putIdle(pc)
+	p.connectedAt.Store(time.Now().UnixNano())
+	connectionUp.Set(1)
+	p.unlock()
+
+	closeConns(old)
+	connectionRotations.WithLabelValues("rotated").Inc()
+	p.logger.Info("RabbitMQ connections rotated",
+		zap.Duration("age", age),
+		zap.Duration("max_lifetime", p.maxConnLifetime),
+		zap.Int("connections", len(conns)),
+	)
+	return true
+}
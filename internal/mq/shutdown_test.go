@@ -0,0 +1,90 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// shutdownInOrder stops srv and then p the way the server does: stop
+// accepting and let handlers drain within drainTimeout, wait for pending
+// confirms, then close the publisher
+func shutdownInOrder(ctx context.Context, srv *http.Server, p *Publisher, drainTimeout time.Duration) error {
+	drainCtx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err := p.Drain(ctx); err != nil {
+		return err
+	}
+	return p.Close()
+}
+
+func TestShutdownLetsInFlightPublishesFinish(t *testing.T) {
+	tests := []struct {
+		name         string
+		drainTimeout time.Duration
+	}{
+		// The handler finishes within the HTTP drain period
+		{"handlers drain", 2 * time.Second},
+		// The handler outlives it, and Drain waits for its confirm
+		{"publisher drains", 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newFakeBroker(t)
+			b.setAckDelay(200 * time.Millisecond)
+			p := newTestPublisher(t, b, nil)
+
+			published := make(chan error, 1)
+			handlerStarted := make(chan struct{})
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(handlerStarted)
+				err := p.Publish(context.Background(), "meter", map[string]string{"request_id": "req-1"}, PublishOptions{})
+				published <- err
+				if err != nil {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusAccepted)
+			})}
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(ln)
+
+			status := make(chan int, 1)
+			go func() {
+				resp, err := http.Post("http://"+ln.Addr().String(), "application/json", nil)
+				if err != nil {
+					status <- 0
+					return
+				}
+				resp.Body.Close()
+				status <- resp.StatusCode
+			}()
+			<-handlerStarted
+			if !waitFor(t, time.Second, func() bool { return p.InFlight() == 1 }) {
+				t.Fatal("the publish never started")
+			}
+
+			if err := shutdownInOrder(context.Background(), srv, p, tt.drainTimeout); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+			if err := <-published; err != nil {
+				t.Fatalf("in-flight publish failed during shutdown: %v", err)
+			}
+			if len(b.messages()) != 1 {
+				t.Errorf("broker received %d messages, want 1", len(b.messages()))
+			}
+			if code := <-status; code != http.StatusAccepted {
+				t.Errorf("status = %d, want 202 for the request in flight at shutdown", code)
+			}
+		})
+	}
+}
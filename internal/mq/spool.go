@@ -0,0 +1,189 @@
+package mq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const spoolFileName = "spool.jsonl"
+
+// ErrSpoolFull is returned when appending would grow the spool past its size limit
+var ErrSpoolFull = errors.New("spool is full")
+
+// spoolRecord is a single spooled message, stored as one JSON line
+type spoolRecord struct {
+	RoutingKey string          `json:"routing_key"`
+	Body       json.RawMessage `json:"body"`
+	Priority   uint8           `json:"priority,omitempty"`
+	SpooledAt  time.Time       `json:"spooled_at"`
+
+	// line is the zero-based line index of the record within the spool file
+	line int
+}
+
+// Spool is an append-only, file-backed write-ahead log for messages that
+// could not be published. Records are stored as JSON lines and survive
+// process restarts.
+type Spool struct {
+	path     string
+	maxBytes int64
+	mu       sync.Mutex
+}
+
+// NewSpool opens (or creates) the spool file inside dir. A last line
+// without a trailing newline is a record torn by a crash mid-append; it is
+// truncated, so the next Append starts on a line of its own instead of
+// being glued onto it.
+func NewSpool(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		path:     filepath.Join(dir, spoolFileName),
+		maxBytes: maxBytes,
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool file: %w", err)
+	}
+	defer f.Close()
+
+	if err := truncateTornTail(f); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// truncateTornTail cuts f after its last newline, dropping a trailing
+// partial record
+func truncateTornTail(f *os.File) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("failed to read spool file: %w", err)
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	if err := f.Truncate(int64(bytes.LastIndexByte(data, '\n') + 1)); err != nil {
+		return fmt.Errorf("failed to truncate torn spool record: %w", err)
+	}
+	return f.Sync()
+}
+
+// Append writes a record to the end of the spool and syncs it to disk
+func (s *Spool) Append(routingKey string, body []byte, priority uint8) error {
+	line, err := json.Marshal(spoolRecord{
+		RoutingKey: routingKey,
+		Body:       body,
+		Priority:   priority,
+		SpooledAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal spool record: %w", err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to stat spool file: %w", err)
+		}
+		if info.Size()+int64(len(line)) > s.maxBytes {
+			return ErrSpoolFull
+		}
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open spool file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+	return f.Sync()
+}
+
+// Peek returns up to n records from the head of the spool without removing
+// them. Lines that cannot be decoded (e.g. a torn write after a crash) are
+// skipped, but still counted so Commit removes them.
+func (s *Spool) Peek(n int) ([]spoolRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool file: %w", err)
+	}
+	defer f.Close()
+
+	var records []spoolRecord
+	reader := bufio.NewReader(f)
+	for line := 0; len(records) < n; line++ {
+		raw, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(raw)) > 0 && err == nil {
+			var rec spoolRecord
+			if json.Unmarshal(raw, &rec) == nil {
+				rec.line = line
+				records = append(records, rec)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool file: %w", err)
+		}
+	}
+
+	return records, nil
+}
+
+// Commit removes the first lines lines from the spool. Records appended after
+// the corresponding Peek are preserved.
+func (s *Spool) Commit(lines int) error {
+	if lines <= 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read spool file: %w", err)
+	}
+
+	rest := data
+	for i := 0; i < lines && len(rest) > 0; i++ {
+		idx := bytes.IndexByte(rest, '\n')
+		if idx < 0 {
+			rest = nil
+			break
+		}
+		rest = rest[idx+1:]
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, rest, 0o640); err != nil {
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace spool file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package mq
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpoolAppendPeekCommit(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := s.Append(key, []byte(`{"k":"`+key+`"}`), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := s.Peek(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].RoutingKey != "a" || records[1].RoutingKey != "b" {
+		t.Fatalf("Peek(2) = %+v, want records a and b", records)
+	}
+	if records[0].Priority != 1 || string(records[0].Body) != `{"k":"a"}` {
+		t.Errorf("record = %+v, want priority 1 and the original body", records[0])
+	}
+
+	if err := s.Commit(records[1].line + 1); err != nil {
+		t.Fatal(err)
+	}
+	records, err = s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].RoutingKey != "c" {
+		t.Fatalf("after commit Peek = %+v, want only record c", records)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	s, err := NewSpool(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append("a", []byte(`{}`), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append("b", []byte(`{}`), 0); !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("Append over the limit = %v, want ErrSpoolFull", err)
+	}
+}
+
+func TestSpoolSkipsUndecodableLines(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, spoolFileName), []byte("garbage\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append("a", []byte(`{}`), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].line != 1 {
+		t.Fatalf("Peek = %+v, want one record on line 1", records)
+	}
+	// Committing the record also removes the garbage line before it
+	if err := s.Commit(records[0].line + 1); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, spoolFileName))
+	if len(data) != 0 {
+		t.Errorf("spool after commit = %q, want empty", data)
+	}
+}
+
+func TestSpoolTruncatesTornTailOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append("a", []byte(`{"n":1}`), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of appending a second record
+	f, err := os.OpenFile(filepath.Join(dir, spoolFileName), os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"routing_key":"b","bo`)
+	f.Close()
+
+	s, err = NewSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append("c", []byte(`{"n":3}`), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := s.Peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].RoutingKey != "a" || records[1].RoutingKey != "c" {
+		t.Fatalf("Peek = %+v, want records a and c with the torn one dropped", records)
+	}
+}
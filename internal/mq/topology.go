@@ -0,0 +1,122 @@
+package mq
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// Topology describes the broker objects the publisher declares on connect.
+// Declarations are idempotent, so they are safely repeated on every reconnect.
+type Topology struct {
+	// DeclareExchange declares the durable publish exchange
+	DeclareExchange bool
+	ExchangeType    string
+
+	// DeclareQueue declares a queue bound to the exchange so messages are
+	// retained until a consumer attaches; it implies DeclareExchange
+	DeclareQueue bool
+	QueueName    string
+	QueueDurable bool
+	BindingKey   string
+	// MaxPriority declares the queue with x-max-priority when non-zero, making
+	// it a priority queue. The broker rejects redeclaring an existing queue
+	// with a different value, so it must be deleted (or recreated) first.
+	MaxPriority uint8
+
+	// AlternateExchange, when set, is declared as a durable fanout exchange and
+	// attached to the publish exchange via the alternate-exchange argument, so
+	// unroutable messages land there instead of being dropped. It implies
+	// DeclareExchange. AlternateQueue optionally binds a catch-all queue to it.
+	//
+	// Note on mandatory publishing: the broker treats a message delivered to
+	// the alternate exchange as routed, so a basic.return (NotifyReturn) is
+	// only raised if the alternate exchange cannot route it either.
+	AlternateExchange string
+	AlternateQueue    string
+}
+
+// declareTopology declares the configured exchange, queue and binding on a
+// short-lived channel, since a failed declaration closes the channel it ran on
+func (p *Publisher) declareTopology(conn *amqp.Connection) error {
+	t := p.topology
+	if !t.DeclareExchange && !t.DeclareQueue && t.AlternateExchange == "" {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open topology channel: %w", err)
+	}
+	defer ch.Close()
+
+	var exchangeArgs amqp.Table
+	if t.AlternateExchange != "" {
+		if err := declareAlternateExchange(ch, t.AlternateExchange, t.AlternateQueue); err != nil {
+			return err
+		}
+		exchangeArgs = amqp.Table{"alternate-exchange": t.AlternateExchange}
+	}
+
+	if err := ch.ExchangeDeclare(
+		p.exchange,
+		t.ExchangeType,
+		true,  // durable
+		false, // auto-delete
+		false, // internal
+		false, // no-wait
+		exchangeArgs,
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %q: %w", p.exchange, err)
+	}
+
+	if !t.DeclareQueue {
+		return nil
+	}
+
+	var queueArgs amqp.Table
+	if t.MaxPriority > 0 {
+		queueArgs = amqp.Table{"x-max-priority": int32(t.MaxPriority)}
+	}
+
+	if _, err := ch.QueueDeclare(
+		t.QueueName,
+		t.QueueDurable,
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		queueArgs,
+	); err != nil {
+		return fmt.Errorf("failed to declare queue %q: %w", t.QueueName, err)
+	}
+
+	if err := ch.QueueBind(t.QueueName, t.BindingKey, p.exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %q to exchange %q: %w", t.QueueName, p.exchange, err)
+	}
+
+	p.logger.Info("RabbitMQ queue declared and bound",
+		zap.String("queue", t.QueueName),
+		zap.String("exchange", p.exchange),
+		zap.String("binding_key", t.BindingKey),
+	)
+	return nil
+}
+
+// declareAlternateExchange declares the fanout exchange receiving unroutable
+// messages and, if queue is set, a durable catch-all queue bound to it
+func declareAlternateExchange(ch *amqp.Channel, exchange, queue string) error {
+	if err := ch.ExchangeDeclare(exchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare alternate exchange %q: %w", exchange, err)
+	}
+	if queue == "" {
+		return nil
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare alternate queue %q: %w", queue, err)
+	}
+	if err := ch.QueueBind(queue, "", exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind alternate queue %q: %w", queue, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestAtomicBatchFailingMidwayPublishesNothing(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(func(m fakeMessage) fakeAction {
+		if string(m.Body) == `{"n":2}` {
+			return fakeCloseChannel
+		}
+		return fakeAck
+	})
+	p := newTestPublisher(t, b, func(o *Options) { o.Atomic = true })
+
+	msgs := make([]BatchMessage, 5)
+	for i := range msgs {
+		msgs[i] = BatchMessage{RoutingKey: "meter", Body: []byte(fmt.Sprintf(`{"n":%d}`, i))}
+	}
+	if err := p.PublishBatch(context.Background(), msgs); err == nil {
+		t.Fatal("PublishBatch succeeded although the channel failed mid-batch")
+	}
+	if got := b.messages(); len(got) != 0 {
+		t.Errorf("broker routed %d messages of a failed transaction, want none", len(got))
+	}
+
+	// The publisher recovers, and a later batch lands as a whole
+	b.setOnPublish(nil)
+	if err := p.PublishBatch(context.Background(), msgs); err != nil {
+		t.Fatalf("PublishBatch after recovery: %v", err)
+	}
+	if got := b.messages(); len(got) != len(msgs) {
+		t.Errorf("broker routed %d messages, want %d", len(got), len(msgs))
+	}
+}
+
+func TestAtomicPublishToAdditionalTargets(t *testing.T) {
+	b := newFakeBroker(t)
+	b.setOnPublish(func(m fakeMessage) fakeAction {
+		if m.Exchange == "audit" {
+			return fakeCloseChannel
+		}
+		return fakeAck
+	})
+	p := newTestPublisher(t, b, func(o *Options) {
+		o.Atomic = true
+		o.MaxRetries = 1
+		o.AdditionalTargets = []Target{{Exchange: "audit"}}
+	})
+
+	if err := p.Publish(context.Background(), "meter", map[string]int{"n": 1}, PublishOptions{}); err == nil {
+		t.Fatal("Publish succeeded although one target failed")
+	}
+	if got := b.messages(); len(got) != 0 {
+		t.Errorf("broker routed %d copies, want none when any target fails", len(got))
+	}
+}
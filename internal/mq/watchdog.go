@@ -0,0 +1,99 @@
+package mq
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// lock acquires p.mu and records when, so the watchdog can tell how long it
+// has been held without contending for it
+func (p *Publisher) lock() {
+	p.mu.Lock()
+	p.lockedAt.Store(time.Now().UnixNano())
+}
+
+// unlock clears the hold timestamp and releases p.mu
+func (p *Publisher) unlock() {
+	p.lockedAt.Store(0)
+	p.mu.Unlock()
+}
+
+// lockHeld returns how long p.mu has been held, or zero if it is free
+func (p *Publisher) lockHeld() time.Duration {
+	lockedAt := p.lockedAt.Load()
+	if lockedAt == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, lockedAt))
+}
+
+// setDialedConns publishes the connections currently dialed by connect, so
+// the watchdog can close them without taking p.mu
+func (p *Publisher) setDialedConns(conns []*amqp.Connection) {
+	snapshot := slices.Clone(conns)
+	p.dialedConns.Store(&snapshot)
+}
+
+// Wedged reports an error when the publisher lock has been held longer than
+// the watchdog threshold. It never blocks on the publisher, so it is safe to
+// call from a liveness probe. It always returns nil when the watchdog is off.
+func (p *Publisher) Wedged() error {
+	if p.lockWatchdogThreshold <= 0 {
+		return nil
+	}
+	if held := p.lockHeld(); held >= p.lockWatchdogThreshold {
+		return fmt.Errorf("publisher lock held for %s", held.Round(time.Second))
+	}
+	return nil
+}
+
+// lockWatchdog periodically checks how long the publisher lock has been held
+func (p *Publisher) lockWatchdog() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(max(p.lockWatchdogThreshold/4, time.Second))
+	defer ticker.Stop()
+
+	var reported int64 // lockedAt of the last stall reported, so each is reported once
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			lockedAt := p.lockedAt.Load()
+			held := p.lockHeld()
+			lockHeldSeconds.Set(held.Seconds())
+			if held < p.lockWatchdogThreshold || lockedAt == reported {
+				continue
+			}
+			reported = lockedAt
+
+			lockStalls.Inc()
+			p.logger.Error("RabbitMQ publisher lock held past watchdog threshold",
+				zap.Duration("held", held),
+				zap.Duration("threshold", p.lockWatchdogThreshold),
+				zap.Bool("force_reconnect", p.lockWatchdogReconnect),
+			)
+			if p.lockWatchdogReconnect {
+				p.forceClose()
+			}
+		}
+	}
+}
+
+// forceClose closes the dialed connections without taking p.mu, so whatever
+// holds the lock while waiting on the broker fails and lets go. The keepalive
+// or the next publish then reconnects as after any connection loss.
+func (p *Publisher) forceClose() {
+	conns := p.dialedConns.Load()
+	if conns == nil {
+		return
+	}
+	for _, conn := range *conns {
+		conn.Close()
+	}
+}
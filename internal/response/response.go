@@ -0,0 +1,87 @@
+package response
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stable, machine-readable error codes. Clients switch on these, so existing
+// codes must never be renamed.
+const (
+	CodeValidationFailed     = "VALIDATION_FAILED"
+	CodeBrokerUnavailable    = "BROKER_UNAVAILABLE"
+	CodeBrokerTimeout        = "BROKER_TIMEOUT"
+	CodeBrokerBlocked        = "BROKER_BLOCKED"
+	CodeRateLimited          = "RATE_LIMITED"
+	CodeRequestTimeout       = "REQUEST_TIMEOUT"
+	CodeBodyReadTimeout      = "BODY_READ_TIMEOUT"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeNotFound             = "NOT_FOUND"
+	CodeClientClosed         = "CLIENT_CLOSED_REQUEST"
+	CodeReplayedRequest      = "REPLAYED_REQUEST"
+	CodeShuttingDown         = "SHUTTING_DOWN"
+	CodeInternal             = "INTERNAL"
+)
+
+// Envelope is the shape of every API response
+type Envelope struct {
+	Success bool        `json:"success"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   *ErrorBody  `json:"error,omitempty"`
+}
+
+// ErrorBody describes a failed request
+type ErrorBody struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+var legacy atomic.Bool
+
+// SetLegacy switches rendering to the pre-envelope response shapes, where
+// success bodies are returned bare and errors as {"error", "message"|"details"}
+func SetLegacy(enabled bool) {
+	legacy.Store(enabled)
+}
+
+// OK writes a successful response carrying data
+func OK(c *gin.Context, status int, data gin.H) {
+	if legacy.Load() {
+		c.JSON(status, data)
+		return
+	}
+	c.JSON(status, Envelope{Success: true, Data: data})
+}
+
+// Error writes a failed response
+func Error(c *gin.Context, status int, code, message string, details ...string) {
+	c.JSON(status, body(code, message, details))
+}
+
+// Abort writes a failed response and stops the handler chain
+func Abort(c *gin.Context, status int, code, message string, details ...string) {
+	c.AbortWithStatusJSON(status, body(code, message, details))
+}
+
+func body(code, message string, details []string) interface{} {
+	if !legacy.Load() {
+		return Envelope{Error: &ErrorBody{Code: code, Message: message, Details: details}}
+	}
+
+	// Validation errors used to carry their details under "details", all
+	// other errors under "message"
+	legacyBody := gin.H{"error": message}
+	if len(details) > 0 {
+		key := "message"
+		if code == CodeValidationFailed {
+			key = "details"
+		}
+		legacyBody[key] = strings.Join(details, "; ")
+	}
+	return legacyBody
+}
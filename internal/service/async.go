@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"go.uber.org/zap"
+)
+
+// publishJob is an accepted request waiting to be published
+type publishJob struct {
+	requestID         string
+	clientFingerprint string
+	tenantID          string
+	messages          []outgoing
+	opts              mq.PublishOptions
+	readings          []MeterReading
+	duplicates        int
+	receivedAt        time.Time
+	logger            *zap.Logger // carries the request's ID, fingerprint and tenant
+}
+
+// outgoing is one message of a publishJob
+type outgoing struct {
+	routingKey string
+	message    interface{}
+}
+
+// asyncQueue hands accepted requests to the publish workers. closed guards
+// jobs so handlers still running during shutdown never send on a closed channel.
+type asyncQueue struct {
+	jobs chan publishJob
+	wg   sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// startWorkers enables async ingest with workers publishing from a queue of
+// size jobs
+func (s *IngestService) startWorkers(workers, size int) {
+	s.async = &asyncQueue{jobs: make(chan publishJob, size)}
+	for i := 0; i < workers; i++ {
+		s.async.wg.Add(1)
+		go s.worker()
+	}
+}
+
+// enqueue queues job without blocking, failing with ErrQueueFull when the
+// queue is at capacity and ErrShuttingDown once it is closed
+func (q *asyncQueue) enqueue(job publishJob) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrShuttingDown
+	}
+	select {
+	case q.jobs <- job:
+		asyncQueueDepth.Set(float64(len(q.jobs)))
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// worker publishes queued jobs until the queue is closed and drained
+func (s *IngestService) worker() {
+	defer s.async.wg.Done()
+
+	for job := range s.async.jobs {
+		asyncQueueDepth.Set(float64(len(s.async.jobs)))
+
+		ctx, cancel := logging.WithLogger(context.Background(), job.logger), func() {}
+		if s.deadline > 0 {
+			ctx, cancel = context.WithTimeout(ctx, s.deadline)
+		}
+		err := s.publishAll(ctx, job)
+		cancel()
+
+		if err != nil {
+			asyncPublishes.WithLabelValues("failed").Inc()
+			s.recordStatus(job.requestID, StatusFailed)
+			job.logger.Error("Failed to publish queued message",
+				zap.Error(err),
+				zap.Bool("dead_letter", s.deadLetter != nil),
+			)
+			s.deadLetterJob(job, err)
+			continue
+		}
+		asyncPublishes.WithLabelValues("published").Inc()
+		s.published(job)
+	}
+}
+
+// deadLetterJob sends every message of job, whose publish failed with
+// cause, to the dead-letter publisher so it can be inspected and replayed.
+// All of them are sent, even when some shard messages were published, since
+// which ones is unknown; cause then says how many were.
+func (s *IngestService) deadLetterJob(job publishJob, cause error) {
+	if s.deadLetter == nil {
+		return
+	}
+	ctx, cancel := logging.WithLogger(context.Background(), job.logger), func() {}
+	if s.deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.deadline)
+	}
+	defer cancel()
+	for _, m := range job.messages {
+		// Failures are logged and counted by the publisher; the message is lost
+		_ = s.deadLetter.PublishDeadLetter(ctx, m.routingKey, m.message, cause)
+	}
+}
+
+// Pending returns the number of accepted requests still waiting in the async
+// queue. It is always 0 in sync mode.
+func (s *IngestService) Pending() int {
+	if s.async == nil {
+		return 0
+	}
+	return len(s.async.jobs)
+}
+
+// Close stops accepting async requests and waits until the queued ones are
+// published, then sends the open batches, or until ctx is done
+func (s *IngestService) Close(ctx context.Context) error {
+	if err := s.closeAsync(ctx); err != nil {
+		return err
+	}
+	if s.batches != nil {
+		return s.batches.close(ctx)
+	}
+	return nil
+}
+
+// closeAsync stops accepting async requests and waits until the queued ones
+// are published, or ctx is done. It is a no-op in sync mode.
+func (s *IngestService) closeAsync(ctx context.Context) error {
+	if s.async == nil {
+		return nil
+	}
+
+	s.async.mu.Lock()
+	if !s.async.closed {
+		s.async.closed = true
+		close(s.async.jobs)
+	}
+	s.async.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.async.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
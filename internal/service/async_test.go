@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+)
+
+// deadLetterCall is one PublishDeadLetter call received by fakeDeadLetter
+type deadLetterCall struct {
+	routingKey string
+	message    interface{}
+	cause      error
+}
+
+// fakeDeadLetter records the messages sent to the dead-letter publisher
+type fakeDeadLetter struct {
+	mu    sync.Mutex
+	calls []deadLetterCall
+}
+
+func (f *fakeDeadLetter) PublishDeadLetter(ctx context.Context, routingKey string, message interface{}, cause error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, deadLetterCall{routingKey: routingKey, message: message, cause: cause})
+	return nil
+}
+
+func (f *fakeDeadLetter) received() []deadLetterCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]deadLetterCall(nil), f.calls...)
+}
+
+func TestAsyncPublishFailure(t *testing.T) {
+	errBroker := errors.New("broker nacked")
+	tests := []struct {
+		name       string
+		shardCount int
+		deadLetter bool
+		wantDead   int
+	}{
+		{"dead-lettered", 0, true, 1},
+		{"every shard dead-lettered", 4, true, -1}, // one per shard message, even unattempted ones
+		{"no dead-letter target", 0, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{fail: func(int) error { return errBroker }}
+			dead := &fakeDeadLetter{}
+			s := newTestService(t, pub, func(o *Options) {
+				o.AsyncWorkers = 1
+				o.AsyncQueueSize = 4
+				o.ShardCount = tt.shardCount
+				o.StatusStore = NewStatusStore(time.Minute, 10)
+				if tt.deadLetter {
+					o.DeadLetter = dead
+				}
+			})
+
+			req, shards := shardedRequest(20, 4)
+			ctx := logging.WithRequestID(context.Background(), "req-1")
+			if _, err := s.ProcessReading(ctx, req, ClientMetadata{}); err != nil {
+				t.Fatalf("ProcessReading = %v, want the request queued", err)
+			}
+			if err := s.Close(context.Background()); err != nil {
+				t.Fatalf("Close = %v", err)
+			}
+
+			if entry, ok := s.Status("req-1"); !ok || entry.Status != StatusFailed {
+				t.Errorf("status = %+v (found %t), want %s", entry, ok, StatusFailed)
+			}
+			calls := dead.received()
+			want := tt.wantDead
+			if want < 0 {
+				want = shards
+			}
+			if len(calls) != want {
+				t.Fatalf("dead-lettered %d messages, want %d", len(calls), want)
+			}
+			for _, c := range calls {
+				if !errors.Is(c.cause, errBroker) {
+					t.Errorf("dead-letter cause = %v, want the publish error", c.cause)
+				}
+				if c.message == nil || c.routingKey == "" {
+					t.Errorf("dead-lettered %v to %q, want the message and its routing key", c.message, c.routingKey)
+				}
+			}
+		})
+	}
+}
+
+func TestEnqueueAfterClose(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) {
+		o.AsyncWorkers = 1
+		o.AsyncQueueSize = 4
+	})
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("Close = %v", err)
+	}
+
+	_, err := s.ProcessReading(context.Background(), readings("m1", "01/03/2024 12:00:00", "1"), ClientMetadata{})
+	if !errors.Is(err, ErrShuttingDown) || errors.Is(err, ErrQueueFull) {
+		t.Fatalf("ProcessReading after Close = %v, want ErrShuttingDown", err)
+	}
+	if n := len(pub.published()); n != 0 {
+		t.Errorf("published %d messages, want none", n)
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+)
+
+// IngestBatch is the message published when batching combines the messages
+// of several requests. Each element of Batch is an IngestMessage exactly as
+// it would have been published on its own.
+type IngestBatch struct {
+	SchemaVersion string        `json:"schema_version"`
+	Source        string        `json:"source"`
+	Batch         []interface{} `json:"batch"`
+}
+
+// Batch flush triggers, used as the trigger label of ingest_batches_total
+const (
+	flushSize     = "size"
+	flushWindow   = "window"
+	flushShutdown = "shutdown"
+)
+
+// batchKey groups messages that can share one AMQP message
+type batchKey struct {
+	routingKey string
+	opts       mq.PublishOptions
+}
+
+// batchEntry is one message waiting in a batch
+type batchEntry struct {
+	batch     *pendingBatch
+	message   interface{}
+	withdrawn bool // removed before the batch was sent
+}
+
+// pendingBatch collects messages until it is full or its window elapses.
+// done is closed once the batch was published, err holding the outcome.
+type pendingBatch struct {
+	key     batchKey
+	entries []*batchEntry
+	timer   *time.Timer
+	done    chan struct{}
+	err     error
+}
+
+// batcher combines messages published within a window into one message per
+// routing key and publish options, trading latency for fewer, larger
+// messages and confirms
+type batcher struct {
+	publisher   Publisher
+	source      string
+	window      time.Duration
+	maxMessages int
+	timeout     time.Duration // bounds publishing a batch; 0 leaves it to the publisher
+
+	mu      sync.Mutex
+	pending map[batchKey]*pendingBatch
+	sending sync.WaitGroup
+}
+
+func newBatcher(publisher Publisher, source string, window time.Duration, maxMessages int, timeout time.Duration) *batcher {
+	return &batcher{
+		publisher:   publisher,
+		source:      source,
+		window:      window,
+		maxMessages: maxMessages,
+		timeout:     timeout,
+		pending:     make(map[batchKey]*pendingBatch),
+	}
+}
+
+// add puts message in the open batch for its routing key and options,
+// opening one if needed, and sends the batch once it is full
+func (b *batcher) add(routingKey string, message interface{}, opts mq.PublishOptions) *batchEntry {
+	key := batchKey{routingKey: routingKey, opts: opts}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch := b.pending[key]
+	if batch == nil {
+		batch = &pendingBatch{key: key, done: make(chan struct{})}
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(batch, flushWindow) })
+		b.pending[key] = batch
+	}
+	entry := &batchEntry{batch: batch, message: message}
+	batch.entries = append(batch.entries, entry)
+	if len(batch.entries) >= b.maxMessages {
+		b.detach(batch)
+		go b.send(batch, flushSize)
+	}
+	return entry
+}
+
+// wait returns the outcome of the batch holding entry. If ctx ends while the
+// batch is still open, the entry is withdrawn and was never published;
+// once the batch is being sent, the outcome is unknown.
+func (b *batcher) wait(ctx context.Context, entry *batchEntry) error {
+	select {
+	case <-entry.batch.done:
+		return entry.batch.err
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	select {
+	case <-entry.batch.done: // published just as ctx ended
+		return entry.batch.err
+	default:
+	}
+	if b.pending[entry.batch.key] == entry.batch {
+		entry.withdrawn = true
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w: batch was being published: %w", mq.ErrDeliveryUnknown, ctx.Err())
+}
+
+// flush sends batch for trigger unless it was already sent
+func (b *batcher) flush(batch *pendingBatch, trigger string) {
+	b.mu.Lock()
+	if b.pending[batch.key] != batch {
+		b.mu.Unlock()
+		return
+	}
+	b.detach(batch)
+	b.mu.Unlock()
+	b.send(batch, trigger)
+}
+
+// detach closes batch to new messages; b.mu must be held
+func (b *batcher) detach(batch *pendingBatch) {
+	delete(b.pending, batch.key)
+	batch.timer.Stop()
+	b.sending.Add(1)
+}
+
+// send publishes the messages of a detached batch that were not withdrawn
+func (b *batcher) send(batch *pendingBatch, trigger string) {
+	defer b.sending.Done()
+	defer close(batch.done)
+
+	messages := make([]interface{}, 0, len(batch.entries))
+	for _, entry := range batch.entries {
+		if !entry.withdrawn {
+			messages = append(messages, entry.message)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	batchesPublished.WithLabelValues(trigger).Inc()
+	batchMessages.Observe(float64(len(messages)))
+
+	ctx, cancel := context.Background(), func() {}
+	if b.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+	}
+	defer cancel()
+	batch.err = b.publisher.Publish(ctx, batch.key.routingKey, IngestBatch{
+		SchemaVersion: SchemaVersion,
+		Source:        b.source,
+		Batch:         messages,
+	}, batch.key.opts)
+}
+
+// close sends every open batch now and waits until all batches were
+// published, or ctx is done
+func (b *batcher) close(ctx context.Context) error {
+	b.mu.Lock()
+	for _, batch := range b.pending {
+		b.detach(batch)
+		go b.send(batch, flushShutdown)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.sending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+)
+
+// batchOf returns the IngestBatch published by call
+func batchOf(t *testing.T, call publishCall) IngestBatch {
+	t.Helper()
+	batch, ok := call.message.(IngestBatch)
+	if !ok {
+		t.Fatalf("published %T, want IngestBatch", call.message)
+	}
+	return batch
+}
+
+func TestBatcherFlushesOnWindow(t *testing.T) {
+	pub := &fakePublisher{}
+	b := newBatcher(pub, "ingest-test", 20*time.Millisecond, 100, 0)
+	before := testutil.ToFloat64(batchesPublished.WithLabelValues(flushWindow))
+
+	start := time.Now()
+	first := b.add("meter.reading.ingested", "a", mq.PublishOptions{})
+	second := b.add("meter.reading.ingested", "b", mq.PublishOptions{})
+	for _, entry := range []*batchEntry{first, second} {
+		if err := b.wait(context.Background(), entry); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("batch sent after %v, before its window", elapsed)
+	}
+
+	calls := pub.published()
+	if len(calls) != 1 {
+		t.Fatalf("published %d messages, want one batch", len(calls))
+	}
+	if batch := batchOf(t, calls[0]); len(batch.Batch) != 2 || batch.Batch[0] != "a" || batch.Batch[1] != "b" {
+		t.Errorf("batch %v, want [a b]", batch.Batch)
+	}
+	if got := testutil.ToFloat64(batchesPublished.WithLabelValues(flushWindow)) - before; got != 1 {
+		t.Errorf("window flushes rose by %v, want 1", got)
+	}
+}
+
+func TestBatcherFlushesOnSize(t *testing.T) {
+	pub := &fakePublisher{}
+	b := newBatcher(pub, "ingest-test", time.Hour, 3, 0)
+
+	var entries []*batchEntry
+	for _, m := range []string{"a", "b", "c", "d"} {
+		entries = append(entries, b.add("meter.reading.ingested", m, mq.PublishOptions{}))
+	}
+	for _, entry := range entries[:3] {
+		if err := b.wait(context.Background(), entry); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+	}
+	calls := pub.published()
+	if len(calls) != 1 || len(batchOf(t, calls[0]).Batch) != 3 {
+		t.Fatalf("published %d messages, want one batch of 3 without waiting for the window", len(calls))
+	}
+
+	// The fourth message opened a new batch, sent by close
+	if err := b.close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := b.wait(context.Background(), entries[3]); err != nil {
+		t.Fatalf("wait after close: %v", err)
+	}
+	if calls := pub.published(); len(calls) != 2 || len(batchOf(t, calls[1]).Batch) != 1 {
+		t.Errorf("published %d messages, want the open batch flushed on close", len(calls))
+	}
+}
+
+func TestBatcherSeparatesRoutingKeys(t *testing.T) {
+	pub := &fakePublisher{}
+	b := newBatcher(pub, "ingest-test", time.Hour, 100, 0)
+	b.add("meter.reading.ingested.shard.0", "a", mq.PublishOptions{})
+	b.add("meter.reading.ingested.shard.1", "b", mq.PublishOptions{})
+	b.add("meter.reading.ingested.shard.0", "c", mq.PublishOptions{Priority: 5})
+	if err := b.close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if n := len(pub.published()); n != 3 {
+		t.Errorf("published %d batches, want one per routing key and options", n)
+	}
+}
+
+func TestBatcherWithdrawsOnCancel(t *testing.T) {
+	pub := &fakePublisher{}
+	b := newBatcher(pub, "ingest-test", 20*time.Millisecond, 100, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gone := b.add("meter.reading.ingested", "gone", mq.PublishOptions{})
+	kept := b.add("meter.reading.ingested", "kept", mq.PublishOptions{})
+	cancel()
+	if err := b.wait(ctx, gone); !errors.Is(err, context.Canceled) || errors.Is(err, mq.ErrDeliveryUnknown) {
+		t.Fatalf("wait = %v, want a plain cancellation for an open batch", err)
+	}
+	if err := b.wait(context.Background(), kept); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	calls := pub.published()
+	if len(calls) != 1 {
+		t.Fatalf("published %d messages, want 1", len(calls))
+	}
+	if batch := batchOf(t, calls[0]); len(batch.Batch) != 1 || batch.Batch[0] != "kept" {
+		t.Errorf("batch %v, want only the message still waiting", batch.Batch)
+	}
+}
+
+// slowPublisher confirms one message at a time, each after latency, like a
+// channel waiting for broker confirms
+type slowPublisher struct {
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+func (p *slowPublisher) Publish(ctx context.Context, routingKey string, message interface{}, opts mq.PublishOptions) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	time.Sleep(p.latency)
+	return nil
+}
+
+func BenchmarkBatchedPublishing(b *testing.B) {
+	req := readings("m1", "01/03/2024 12:00:00", "1")
+	for _, bc := range []struct {
+		name   string
+		window time.Duration
+	}{
+		{"per_request", 0},
+		{"batched", 2 * time.Millisecond},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := newTestService(b, &slowPublisher{latency: 200 * time.Microsecond}, func(o *Options) {
+				o.BatchWindow = bc.window
+				o.BatchMaxMessages = 100
+			})
+			defer s.Close(context.Background())
+			b.SetParallelism(32)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package service
+
+import "time"
+
+// Clock supplies the current time. It can be replaced by a fake in tests so
+// received-at timestamps are deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Received-at layouts. Timestamps are always rendered in UTC, so the zone
+// is serialized as "Z"; the fixed-width fractional seconds keep them
+// lexicographically sortable across shards.
+const (
+	ReceivedAtSeconds = time.RFC3339
+	ReceivedAtMillis  = "2006-01-02T15:04:05.000Z07:00"
+	ReceivedAtNanos   = "2006-01-02T15:04:05.000000000Z07:00"
+)
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReceivedAtFormat(t *testing.T) {
+	// A non-UTC clock proves the timestamp is converted, not just formatted
+	local := time.FixedZone("UTC+7", 7*3600)
+	now := time.Date(2024, 3, 1, 19, 30, 45, 123456789, local)
+
+	tests := []struct {
+		name   string
+		layout string
+		want   string
+	}{
+		{"default is UTC milliseconds", "", "2024-03-01T12:30:45.123Z"},
+		{"seconds", ReceivedAtSeconds, "2024-03-01T12:30:45Z"},
+		{"milliseconds", ReceivedAtMillis, "2024-03-01T12:30:45.123Z"},
+		{"nanoseconds", ReceivedAtNanos, "2024-03-01T12:30:45.123456789Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) {
+				o.Clock = fixedClock{now}
+				o.ReceivedAtLayout = tt.layout
+			})
+			if _, err := s.ProcessReading(context.Background(), readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err != nil {
+				t.Fatalf("ProcessReading: %v", err)
+			}
+			if got := nativeMessage(t, pub.published()[0]).ReceivedAt; got != tt.want {
+				t.Errorf("received_at = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceivedAtMillisKeepsTrailingZeros(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.Clock = fixedClock{time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)} })
+	if _, err := s.ProcessReading(context.Background(), readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	// Fixed-width timestamps compare correctly as strings
+	if got := nativeMessage(t, pub.published()[0]).ReceivedAt; got != "2024-03-01T12:00:00.000Z" {
+		t.Errorf("received_at = %q, want fixed-width milliseconds", got)
+	}
+}
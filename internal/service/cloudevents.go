@@ -0,0 +1,59 @@
+package service
+
+import "strconv"
+
+// Message formats of published messages
+const (
+	MessageFormatNative      = "native"
+	MessageFormatCloudEvents = "cloudevents"
+)
+
+// CloudEvents constants, see https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/spec.md
+const (
+	CloudEventsSpecVersion = "1.0"
+	CloudEventsContentType = "application/cloudevents+json"
+	CloudEventType         = "com.septivank.energy-metering.readings.ingested"
+)
+
+// CloudEvent is an ingest request in the CloudEvents 1.0 structured JSON
+// format. Fields of IngestMessage without a CloudEvents counterpart are
+// carried as extension attributes, whose names must be lowercase alphanumeric.
+type CloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	Type            string         `json:"type"`
+	Source          string         `json:"source"`
+	ID              string         `json:"id"`
+	Time            string         `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            []MeterReading `json:"data"`
+
+	ClientFingerprint string `json:"clientfingerprint"`
+	TenantID          string `json:"tenantid,omitempty"`
+	Shard             *int   `json:"shard,omitempty"`
+	// Warnings holds the warning messages joined by "; ", since extension
+	// attributes must be scalar
+	Warnings string `json:"warnings,omitempty"`
+}
+
+// cloudEvent converts a native message to a CloudEvent. The messages of a
+// sharded request get distinct IDs ("<request_id>.<shard>"), as CloudEvents
+// requires for events from one source.
+func cloudEvent(msg IngestMessage) CloudEvent {
+	id := msg.RequestID
+	if msg.Shard != nil {
+		id += "." + strconv.Itoa(*msg.Shard)
+	}
+	return CloudEvent{
+		SpecVersion:       CloudEventsSpecVersion,
+		Type:              CloudEventType,
+		Source:            msg.Source,
+		ID:                id,
+		Time:              msg.ReceivedAt,
+		DataContentType:   "application/json",
+		Data:              msg.Payload.PM,
+		ClientFingerprint: msg.ClientFingerprint,
+		TenantID:          msg.TenantID,
+		Shard:             msg.Shard,
+		Warnings:          joinWarnings(msg.Warnings),
+	}
+}
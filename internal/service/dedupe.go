@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// dedupeReadings removes exact duplicates, keeping the first occurrence of
+// each reading and the original order. Readings are duplicates only when
+// name, date and data are all equal; the same name and date with different
+// data is kept, since silently picking one value would lose information.
+// It returns how many were dropped.
+func dedupeReadings(sc *screening) int {
+	seen := make(map[MeterReading]struct{}, len(sc.readings))
+	return sc.retain(func(_ int, r MeterReading) bool {
+		if _, ok := seen[r]; ok {
+			return false
+		}
+		seen[r] = struct{}{}
+		return true
+	})
+}
+
+// Policies for readings sharing a name within one request, see
+// Options.DuplicateNames
+const (
+	DuplicateNamesReject   = "reject"
+	DuplicateNamesKeepLast = "keep_last"
+)
+
+// checkUniqueNames reports every reading repeating the name of an earlier
+// one, whatever its date and data. index maps readings to their request
+// index. Each finding lists all duplicated names, so whichever is returned
+// tells the client everything to fix.
+func checkUniqueNames(readings []MeterReading, index []int) []*FieldError {
+	first := make(map[string]int, len(readings))
+	var repeats []int
+	var duplicated []string
+	for i, reading := range readings {
+		if _, ok := first[reading.Name]; !ok {
+			first[reading.Name] = i
+			continue
+		}
+		if !slices.ContainsFunc(repeats, func(j int) bool { return readings[j].Name == reading.Name }) {
+			duplicated = append(duplicated, strconv.Quote(reading.Name))
+		}
+		repeats = append(repeats, i)
+	}
+	if len(repeats) == 0 {
+		return nil
+	}
+
+	names := strings.Join(duplicated, ", ")
+	findings := make([]*FieldError, 0, len(repeats))
+	for _, i := range repeats {
+		findings = append(findings, &FieldError{Index: i, Field: "name", Reason: fmt.Sprintf("repeats PM[%d].name: meter names must be unique within a request (duplicated: %s)", index[first[readings[i].Name]], names), Rule: RejectDuplicateName})
+	}
+	return findings
+}
+
+// keepLastByName keeps only the last reading of each name, taken as the
+// meter's latest snapshot, and returns how many were dropped
+func keepLastByName(sc *screening) int {
+	last := make(map[string]int, len(sc.readings))
+	for i, r := range sc.readings {
+		last[r.Name] = sc.index[i]
+	}
+	return sc.retain(func(index int, r MeterReading) bool {
+		return last[r.Name] == index
+	})
+}
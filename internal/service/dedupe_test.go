@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDedupeWithinRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         IngestRequest
+		wantDropped int
+		wantData    []string
+	}{
+		{
+			name:        "exact duplicates",
+			req:         readings("m", "01/03/2024 12:00:00", "1", "m", "01/03/2024 12:00:00", "1", "m", "01/03/2024 12:00:00", "1"),
+			wantDropped: 2,
+			wantData:    []string{"1"},
+		},
+		{
+			name:        "duplicates after normalization",
+			req:         readings("m", "01/03/2024 12:00:00", "1", " m ", "01/03/2024 12:00:00", "1 "),
+			wantDropped: 1,
+			wantData:    []string{"1"},
+		},
+		{
+			name:     "same name and date with different data",
+			req:      readings("m", "01/03/2024 12:00:00", "1", "m", "01/03/2024 12:00:00", "2"),
+			wantData: []string{"1", "2"},
+		},
+		{
+			name:     "no duplicates",
+			req:      readings("m", "01/03/2024 12:00:00", "1", "m", "01/03/2024 12:15:00", "1"),
+			wantData: []string{"1", "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) { o.DedupeWithinRequest = true })
+
+			result, err := s.ProcessReading(context.Background(), tt.req, ClientMetadata{})
+			if err != nil {
+				t.Fatalf("ProcessReading: %v", err)
+			}
+			if result.DuplicatesDropped != tt.wantDropped {
+				t.Errorf("DuplicatesDropped = %d, want %d", result.DuplicatesDropped, tt.wantDropped)
+			}
+			if result.ReadingsAccepted != len(tt.wantData) {
+				t.Errorf("ReadingsAccepted = %d, want %d", result.ReadingsAccepted, len(tt.wantData))
+			}
+			pm := nativeMessage(t, pub.published()[0]).Payload.PM
+			if len(pm) != len(tt.wantData) {
+				t.Fatalf("published %d readings, want %d", len(pm), len(tt.wantData))
+			}
+			for i, data := range tt.wantData {
+				if pm[i].Data != data {
+					t.Errorf("PM[%d].Data = %q, want %q", i, pm[i].Data, data)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicatesPublishedWhenDedupeDisabled(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, nil)
+
+	req := readings("m", "01/03/2024 12:00:00", "1", "m", "01/03/2024 12:00:00", "1")
+	result, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+	if err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if result.DuplicatesDropped != 0 || len(nativeMessage(t, pub.published()[0]).Payload.PM) != 2 {
+		t.Errorf("duplicates were dropped with deduplication disabled")
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import "slices"
+
+// EchoResult describes how the service would interpret a request, without
+// publishing it
+type EchoResult struct {
+	// Normalized is the request after normalization and deduplication, as it
+	// would be published; it is empty when validation failed
+	Normalized        IngestRequest
+	ClientFingerprint string
+	TenantID          string
+	// RoutingKeys has one entry per published message: several when
+	// sharding splits the request
+	RoutingKeys       []string
+	Priority          uint8
+	DuplicatesDropped int
+	Warnings          []Warning
+	// AcceptedIndices and Rejected are set with partial acceptance, as in
+	// IngestResult
+	AcceptedIndices []int
+	Rejected        []Rejection
+	// ValidationError is the error ProcessReading would have returned, if any
+	ValidationError error
+}
+
+// Echo runs req through the same normalization, validation and routing as
+// ProcessReading and reports the outcome. Nothing is published and no status
+// is recorded. req is not modified.
+func (s *IngestService) Echo(req IngestRequest, metadata ClientMetadata) EchoResult {
+	req.PM = slices.Clone(req.PM)
+
+	result := EchoResult{ClientFingerprint: s.fingerprint(metadata)}
+	p, err := s.prepare(req, metadata)
+	if err != nil {
+		result.ValidationError = err
+		return result
+	}
+	result.Normalized = p.req
+	result.TenantID = p.tenantID
+	for _, part := range s.splitShards(p.routingKey, p.req.PM) {
+		result.RoutingKeys = append(result.RoutingKeys, part.routingKey)
+	}
+	result.Priority = p.priority
+	result.DuplicatesDropped = p.duplicates
+	result.Warnings = p.warnings
+	result.AcceptedIndices = p.accepted
+	result.Rejected = p.rejected
+	return result
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+)
+
+// Errors returned by ProcessReading. Callers match them with errors.Is to
+// choose a response; the wrapped message carries the details.
+var (
+	// ErrValidation is returned when the request content is invalid
+	ErrValidation = errors.New("invalid request")
+
+	// ErrEmptyBatch is returned when the request carries no readings. It
+	// matches ErrValidation.
+	ErrEmptyBatch = fmt.Errorf("%w: PM array cannot be empty", ErrValidation)
+
+	// ErrInvalidPriority is returned when the X-Priority header is not a valid
+	// priority. It matches ErrValidation.
+	ErrInvalidPriority = fmt.Errorf("%w: invalid X-Priority", ErrValidation)
+
+	// ErrInvalidField is matched by every *FieldError
+	ErrInvalidField = errors.New("invalid field")
+
+	// ErrInvalidTenant is returned when multi-tenancy is enabled and the request
+	// carries no tenant or one that is not on the allow-list
+	ErrInvalidTenant = errors.New("missing or unknown tenant")
+
+	// ErrBrokerUnavailable is returned when the message could not be published
+	ErrBrokerUnavailable = errors.New("broker unavailable")
+
+	// ErrBrokerBlocked is returned when the broker is blocking publishes after a
+	// resource alarm and the publisher fails fast instead of waiting
+	ErrBrokerBlocked = errors.New("broker is blocking publishes")
+
+	// ErrQueueFull is returned in async mode when the publish queue is full
+	ErrQueueFull = errors.New("publish queue is full")
+
+	// ErrShuttingDown is returned in async mode once Close has stopped the
+	// publish queue from accepting requests
+	ErrShuttingDown = errors.New("service is shutting down")
+
+	// ErrPublishTimeout is returned when the broker did not confirm the
+	// message within the confirm timeout on the last attempt
+	ErrPublishTimeout = errors.New("publish confirmation timed out")
+
+	// ErrPublishDeadline is returned when publishing (including retries) did not
+	// complete within the service's publish deadline
+	ErrPublishDeadline = errors.New("publish deadline exceeded")
+)
+
+// Rejection reasons name the validation rule a request failed. They are the
+// complete set returned by RejectionReason, so they can be used as a metric
+// label.
+const (
+	RejectEmptyBatch      = "empty_batch"
+	RejectMissingDate     = "missing_date"
+	RejectMissingData     = "missing_data"
+	RejectMissingName     = "missing_name"
+	RejectInvalidDate     = "invalid_date"
+	RejectInvalidInterval = "invalid_interval"
+	RejectUnordered       = "unordered"
+	RejectTooOld          = "too_old"
+	RejectTooLarge        = "too_large"
+	RejectDuplicateName   = "duplicate_name"
+	RejectUnknownMeter    = "unknown_meter"
+	RejectInvalidName     = "invalid_name"
+	RejectInvalidPriority = "invalid_priority"
+	RejectInvalidTenant   = "invalid_tenant"
+	RejectOther           = "other"
+)
+
+// FieldError reports a reading field that failed validation. It matches
+// ErrInvalidField and ErrValidation.
+type FieldError struct {
+	Index  int    // position of the reading in PM
+	Field  string // date, data, name or interval
+	Reason string // why the value was rejected, e.g. "cannot be empty"
+	Rule   string // the rule that failed, one of the Reject* reasons
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrValidation, e.detail())
+}
+
+// Is makes errors.Is match ErrInvalidField and ErrValidation
+func (e *FieldError) Is(target error) bool {
+	return target == ErrInvalidField || target == ErrValidation
+}
+
+// RejectionReason returns the Reject* reason for a validation or tenant
+// error, and RejectOther for any other error
+func RejectionReason(err error) string {
+	var fieldErr *FieldError
+	switch {
+	case errors.As(err, &fieldErr) && fieldErr.Rule != "":
+		return fieldErr.Rule
+	case errors.Is(err, ErrEmptyBatch):
+		return RejectEmptyBatch
+	case errors.Is(err, ErrInvalidPriority):
+		return RejectInvalidPriority
+	case errors.Is(err, ErrInvalidTenant):
+		return RejectInvalidTenant
+	default:
+		return RejectOther
+	}
+}
+
+// brokerError classifies a publish failure from the mq package. The cause
+// stays wrapped, so mq errors such as *mq.NackError can still be matched.
+func brokerError(err error) error {
+	switch {
+	case errors.Is(err, mq.ErrBlocked):
+		return fmt.Errorf("%w: %w", ErrBrokerBlocked, err)
+	case errors.Is(err, mq.ErrConfirmTimeout):
+		return fmt.Errorf("%w: %w", ErrPublishTimeout, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrBrokerUnavailable, err)
+	}
+}
+
+// DeliveryUnknown reports whether a failed publish may still have reached
+// the broker, so retrying it risks a duplicate. It is false when the message
+// was definitely not delivered (no connection, rejected by the broker).
+func DeliveryUnknown(err error) bool {
+	return errors.Is(err, mq.ErrDeliveryUnknown)
+}
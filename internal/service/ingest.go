@@ -2,20 +2,33 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/septivank/energy-metering-ingest-api/internal/audit"
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
 	"github.com/septivank/energy-metering-ingest-api/internal/mq"
 	"github.com/septivank/energy-metering-ingest-api/tools/fingerprint"
+	"github.com/septivank/energy-metering-ingest-api/tools/requestid"
 	"go.uber.org/zap"
 )
 
-// MeterReading represents a single meter reading
+// MeterReading represents a single meter reading. Data is kept as the string
+// the collector sent and is never parsed, so large cumulative registers
+// (beyond 2^53) reach consumers digit for digit; anything that needs the
+// value numerically must decode it with json.Number or a decimal type.
+// Interval is optional: an ISO 8601 duration such as "PT15M" marks a
+// reading of consumption over that window rather than at a point in time.
 type MeterReading struct {
-	Date string `json:"date" binding:"required"`
-	Data string `json:"data" binding:"required"`
-	Name string `json:"name" binding:"required"`
+	Date     string `json:"date" binding:"required"`
+	Data     string `json:"data" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Interval string `json:"interval,omitempty"`
 }
 
 // IngestRequest represents the incoming request payload
@@ -28,82 +41,810 @@ type ClientMetadata struct {
 	IPAddress     string
 	UserAgent     string
 	HasAuthHeader bool
+	TenantID      string
+	// KeyID, DeviceID and AcceptLanguage only feed the fingerprint, and only
+	// when selected by Options.FingerprintInputs
+	KeyID          string
+	DeviceID       string
+	AcceptLanguage string
+	// Priority is the raw X-Priority header; empty derives the priority from
+	// the meter names
+	Priority string
 }
 
+// Optional fingerprint inputs, see Options.FingerprintInputs
+const (
+	FingerprintKeyID          = "key_id"
+	FingerprintTenantID       = "tenant_id"
+	FingerprintDeviceID       = "device_id"
+	FingerprintAcceptLanguage = "accept_language"
+)
+
+// SchemaVersion is the version of the IngestMessage envelope. Bump it whenever
+// the envelope changes so consumers can branch on schema_version.
+const SchemaVersion = "1.0"
+
 // IngestMessage represents the message to be published to RabbitMQ
 type IngestMessage struct {
+	SchemaVersion     string        `json:"schema_version"`
+	Source            string        `json:"source"`
 	RequestID         string        `json:"request_id"`
 	ClientFingerprint string        `json:"client_fingerprint"`
 	IPAddress         string        `json:"ip_address"`
 	UserAgent         string        `json:"user_agent"`
+	TenantID          string        `json:"tenant_id,omitempty"`
 	ReceivedAt        string        `json:"received_at"`
 	Payload           IngestRequest `json:"payload"`
+	// SelfTest marks synthetic messages that consumers should ignore
+	SelfTest bool `json:"selftest,omitempty"`
+	// Warnings lists the validation findings accepted as warnings, see
+	// Options.WarnRules
+	Warnings []Warning `json:"warnings,omitempty"`
+	// Shard is set when the request was split by meter name; Payload then
+	// holds only the readings of this shard
+	Shard *int `json:"shard,omitempty"`
+}
+
+// IngestResult describes an accepted request
+type IngestResult struct {
+	RequestID         string
+	ClientFingerprint string
+	ReadingsAccepted  int
+	DuplicatesDropped int
+	// Warnings lists the validation findings accepted as warnings
+	Warnings []Warning
+	// PreviouslySeen counts readings skipped because an earlier request
+	// published them within the RecentReadings window
+	PreviouslySeen int
+	// AcceptedIndices and Rejected are set with Options.PartialAccept: the
+	// request indices of the readings that passed validation, and the
+	// readings dropped as invalid
+	AcceptedIndices []int
+	Rejected        []Rejection
+	// Queued is set in async mode, where the request has been accepted but
+	// not yet published
+	Queued bool
+}
+
+// Publisher publishes messages to the message broker. It is satisfied by
+// *mq.Publisher and can be replaced by a fake in tests.
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, message interface{}, opts mq.PublishOptions) error
+}
+
+// BatchPublisher publishes several messages and then waits for all of their
+// confirms at once. It is satisfied by *mq.Publisher.
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, msgs []mq.BatchMessage) error
+}
+
+// DeadLetterPublisher receives messages that could not be published. It is
+// satisfied by *mq.Publisher.
+type DeadLetterPublisher interface {
+	PublishDeadLetter(ctx context.Context, routingKey string, message interface{}, cause error) error
+}
+
+// Options configures an IngestService
+type Options struct {
+	RoutingKey string
+	// Source identifies this ingest service instance in published messages
+	Source string
+	// SelfTestRoutingKey is where SelfTest publishes its synthetic messages
+	SelfTestRoutingKey string
+	// RequestIDFormat is the requestid format of IDs the service generates
+	// itself: self-test IDs, and request IDs when ctx carries none. Requests
+	// through middleware.RequestContext carry its ID, either a well-formed
+	// client X-Request-ID in the same format or one it generated
+	RequestIDFormat string
+	// PublishDeadline caps publishing, retries included, independently of the
+	// caller's context; zero leaves publishing bounded by the caller only
+	PublishDeadline time.Duration
+	// LowercaseMeterNames lowercases reading names during normalization
+	LowercaseMeterNames bool
+	// DateLayout is the time.Parse layout of MeterReading.Date
+	DateLayout string
+	// RequireOrderedReadings rejects batches whose dates are not non-decreasing
+	RequireOrderedReadings bool
+	// MaxReadingAge rejects readings dated more than this before now; zero
+	// disables the check. Dates without a zone in DateLayout are taken as UTC.
+	MaxReadingAge time.Duration
+	// DedupeWithinRequest drops exact-duplicate readings before publishing
+	DedupeWithinRequest bool
+	// DuplicateNames, when set, requires meter names to be unique within a
+	// request: DuplicateNamesReject rejects repeated names, and
+	// DuplicateNamesKeepLast keeps only the last reading of each name
+	DuplicateNames string
+	// FieldLimits caps the length of each reading field
+	FieldLimits FieldLimits
+	// AllowedMeterNames rejects readings with any other name; empty allows all
+	AllowedMeterNames []string
+	// MeterNamePattern must fully match every reading name; nil allows all
+	MeterNamePattern *regexp.Regexp
+	// StatusStore records the publish outcome of each request; nil disables tracking
+	StatusStore *StatusStore
+	// MaxPriority is the highest accepted publish priority; 0 disables priorities
+	MaxPriority uint8
+	// MeterPriorities maps meter names to the publish priority of requests
+	// containing them; the highest match wins
+	MeterPriorities map[string]uint8
+	// MeterCounter counts accepted readings per meter name; nil disables counting
+	MeterCounter *MeterCounter
+	// RecentReadings skips readings whose name and date were published by an
+	// earlier request within its window; nil disables cross-request dedup
+	RecentReadings *RecentReadings
+	// AsyncWorkers, when positive, makes ProcessReading return as soon as a
+	// request is queued; the workers publish from a queue of AsyncQueueSize
+	AsyncWorkers   int
+	AsyncQueueSize int
+	// DeadLetter receives the messages of queued requests that failed to
+	// publish; nil drops them once the failure is logged
+	DeadLetter DeadLetterPublisher
+	// Audit receives an event for every accepted request; nil disables auditing
+	Audit *audit.Logger
+	// Clock stamps received_at; nil uses the system clock
+	Clock Clock
+	// ReceivedAtLayout formats received_at in UTC; empty uses ReceivedAtMillis
+	ReceivedAtLayout string
+	// MessageFormat selects the published envelope: MessageFormatNative
+	// (IngestMessage, the default) or MessageFormatCloudEvents (CloudEvent)
+	MessageFormat string
+	// FingerprintInputs adds client attributes to the IP and User-Agent the
+	// fingerprint is derived from: any of FingerprintKeyID, FingerprintTenantID,
+	// FingerprintDeviceID and FingerprintAcceptLanguage
+	FingerprintInputs []string
+
+	// MultiTenancy requires every request to carry an allow-listed tenant,
+	// which selects the routing key via TenantRoutingKeyTemplate
+	MultiTenancy             bool
+	AllowedTenants           []string
+	TenantRoutingKeyTemplate string // "{tenant}" is replaced with the tenant ID
+
+	// WarnRules lists rules whose violations are accepted as warnings,
+	// returned to the client and embedded in the message, instead of
+	// rejecting the request: any of RejectUnknownMeter, RejectInvalidName,
+	// RejectInvalidDate, RejectUnordered and RejectTooOld. Rules guarding the
+	// shape or size of a reading always reject.
+	WarnRules []string
+
+	// ShardCount, when above 1, splits each request into one message per
+	// shard, chosen by hashing the meter name, and appends ".shard.<n>" to
+	// the routing key
+	ShardCount int
+	// BatchConfirms publishes the shard messages of a request with
+	// PublishBatch, waiting once for all of their confirms instead of once
+	// per message, when the publisher is a BatchPublisher. Messages the
+	// batch could not confirm are resent one by one with Publish.
+	BatchConfirms bool
+
+	// PartialAccept publishes the valid readings of a request and reports
+	// the invalid ones, instead of rejecting the whole request; it is only
+	// rejected when no reading is valid
+	PartialAccept bool
+
+	// BatchWindow, when positive, combines the messages published within
+	// this long for the same routing key into one IngestBatch message, sent
+	// once BatchMaxMessages are collected or the window elapses. Requests
+	// wait for their batch, so latency grows by up to the window.
+	BatchWindow      time.Duration
+	BatchMaxMessages int
+
+	// SampleOut is the fraction of valid requests dropped instead of
+	// published, for load testing the HTTP layer without a broker
+	// bottleneck; 0 publishes everything. Dropped requests still get 202.
+	SampleOut float64
 }
 
 // IngestService handles meter reading ingestion
 type IngestService struct {
-	publisher  *mq.Publisher
+	publisher  Publisher
 	logger     *zap.Logger
 	routingKey string
+	source     string
+	selfTestRK string
+	idFormat   string
+	deadline   time.Duration
+
+	lowercaseMeterNames    bool
+	dateLayout             string
+	requireOrderedReadings bool
+	maxReadingAge          time.Duration
+	dedupeWithinRequest    bool
+	duplicateNames         string
+	fieldLimits            FieldLimits
+	allowedMeterNames      map[string]struct{}
+	meterNamePattern       *regexp.Regexp
+	statuses               *StatusStore
+	maxPriority            uint8
+	meterPriorities        map[string]uint8
+	meterCounter           *MeterCounter
+	recent                 *RecentReadings
+	audit                  *audit.Logger
+	async                  *asyncQueue
+	deadLetter             DeadLetterPublisher
+	clock                  Clock
+	receivedAtLayout       string
+	fingerprintInputs      map[string]struct{}
+	cloudEvents            bool
+
+	multiTenancy             bool
+	allowedTenants           map[string]struct{}
+	tenantRoutingKeyTemplate string
+	shardCount               int
+	batchPublisher           BatchPublisher
+	warnRules                map[string]struct{}
+	sampleOut                float64
+	partialAccept            bool
+	batches                  *batcher
 }
 
 // NewIngestService creates a new ingest service
-func NewIngestService(publisher *mq.Publisher, logger *zap.Logger, routingKey string) *IngestService {
-	return &IngestService{
-		publisher:  publisher,
-		logger:     logger,
-		routingKey: routingKey,
+func NewIngestService(publisher Publisher, logger *zap.Logger, opts Options) *IngestService {
+	allowedTenants := make(map[string]struct{}, len(opts.AllowedTenants))
+	for _, tenant := range opts.AllowedTenants {
+		allowedTenants[tenant] = struct{}{}
+	}
+
+	// Names are compared after normalization, so the allow-list is normalized too
+	allowedMeterNames := make(map[string]struct{}, len(opts.AllowedMeterNames))
+	for _, name := range opts.AllowedMeterNames {
+		if opts.LowercaseMeterNames {
+			name = strings.ToLower(name)
+		}
+		allowedMeterNames[name] = struct{}{}
+	}
+
+	// Priorities are looked up by normalized name, so the mapping is normalized too
+	meterPriorities := make(map[string]uint8, len(opts.MeterPriorities))
+	for name, priority := range opts.MeterPriorities {
+		if opts.LowercaseMeterNames {
+			name = strings.ToLower(name)
+		}
+		meterPriorities[name] = priority
+	}
+
+	warnRules := make(map[string]struct{}, len(opts.WarnRules))
+	for _, rule := range opts.WarnRules {
+		warnRules[rule] = struct{}{}
+	}
+
+	fingerprintInputs := make(map[string]struct{}, len(opts.FingerprintInputs))
+	for _, input := range opts.FingerprintInputs {
+		fingerprintInputs[input] = struct{}{}
+	}
+
+	clock := opts.Clock
+	if clock == nil {
+		clock = systemClock{}
+	}
+	receivedAtLayout := opts.ReceivedAtLayout
+	if receivedAtLayout == "" {
+		receivedAtLayout = ReceivedAtMillis
+	}
+
+	s := &IngestService{
+		publisher:                publisher,
+		logger:                   logger,
+		routingKey:               opts.RoutingKey,
+		source:                   opts.Source,
+		selfTestRK:               opts.SelfTestRoutingKey,
+		idFormat:                 opts.RequestIDFormat,
+		deadline:                 opts.PublishDeadline,
+		lowercaseMeterNames:      opts.LowercaseMeterNames,
+		dateLayout:               opts.DateLayout,
+		requireOrderedReadings:   opts.RequireOrderedReadings,
+		maxReadingAge:            opts.MaxReadingAge,
+		dedupeWithinRequest:      opts.DedupeWithinRequest,
+		duplicateNames:           opts.DuplicateNames,
+		fieldLimits:              opts.FieldLimits,
+		allowedMeterNames:        allowedMeterNames,
+		meterNamePattern:         opts.MeterNamePattern,
+		statuses:                 opts.StatusStore,
+		maxPriority:              opts.MaxPriority,
+		meterPriorities:          meterPriorities,
+		meterCounter:             opts.MeterCounter,
+		recent:                   opts.RecentReadings,
+		audit:                    opts.Audit,
+		deadLetter:               opts.DeadLetter,
+		clock:                    clock,
+		receivedAtLayout:         receivedAtLayout,
+		fingerprintInputs:        fingerprintInputs,
+		cloudEvents:              opts.MessageFormat == MessageFormatCloudEvents,
+		multiTenancy:             opts.MultiTenancy,
+		allowedTenants:           allowedTenants,
+		tenantRoutingKeyTemplate: opts.TenantRoutingKeyTemplate,
+		shardCount:               opts.ShardCount,
+		warnRules:                warnRules,
+		sampleOut:                opts.SampleOut,
+		partialAccept:            opts.PartialAccept,
+	}
+	if opts.BatchConfirms {
+		s.batchPublisher, _ = publisher.(BatchPublisher)
+	}
+	if opts.BatchWindow > 0 {
+		s.batches = newBatcher(publisher, opts.Source, opts.BatchWindow, opts.BatchMaxMessages, opts.PublishDeadline)
+	}
+	if s.sampleOut > 0 {
+		logger.Warn("Publish sampling is enabled: valid requests are dropped without publishing, never use this in production",
+			zap.Float64("sample_out", s.sampleOut))
 	}
+	if opts.AsyncWorkers > 0 {
+		s.startWorkers(opts.AsyncWorkers, opts.AsyncQueueSize)
+	}
+	return s
 }
 
-// ProcessReading processes and publishes a meter reading
-func (s *IngestService) ProcessReading(ctx context.Context, req IngestRequest, metadata ClientMetadata) error {
+// resolveTenant validates the tenant and returns it with the routing key to
+// publish on. The tenant is empty when multi-tenancy is disabled.
+func (s *IngestService) resolveTenant(tenantID string) (string, string, error) {
+	if !s.multiTenancy {
+		return "", s.routingKey, nil
+	}
+	if _, ok := s.allowedTenants[tenantID]; !ok || tenantID == "" {
+		return "", "", fmt.Errorf("%w: %q", ErrInvalidTenant, tenantID)
+	}
+	return tenantID, strings.ReplaceAll(s.tenantRoutingKeyTemplate, "{tenant}", tenantID), nil
+}
+
+// TracksStatus reports whether publish outcomes are recorded for lookup
+func (s *IngestService) TracksStatus() bool {
+	return s.statuses != nil
+}
+
+// Status returns the recorded publish outcome of a request
+func (s *IngestService) Status(requestID string) (StatusEntry, bool) {
+	if s.statuses == nil {
+		return StatusEntry{}, false
+	}
+	return s.statuses.Get(requestID)
+}
+
+// recordStatus records the publish outcome of a request when tracking is enabled
+func (s *IngestService) recordStatus(requestID string, status PublishStatus) {
+	if s.statuses != nil {
+		s.statuses.Set(requestID, status)
+	}
+}
+
+// prepared is a request that passed validation, with everything the service
+// derived from it before building the message
+type prepared struct {
+	req        IngestRequest
+	duplicates int
+	tenantID   string
+	routingKey string
+	priority   uint8
+	warnings   []Warning
+	// accepted and rejected are set with partial acceptance: the request
+	// indices of the readings in req and of those dropped as invalid
+	accepted []int
+	rejected []Rejection
+}
+
+// prepare normalizes and validates req and resolves its tenant, routing key
+// and priority. It is shared by ProcessReading and Echo so the two can never
+// disagree about how a request is interpreted.
+func (s *IngestService) prepare(req IngestRequest, metadata ClientMetadata) (prepared, error) {
 	// Validate PM array is not empty
 	if len(req.PM) == 0 {
-		return fmt.Errorf("PM array cannot be empty")
+		return prepared{}, ErrEmptyBatch
 	}
 
-	// Validate each reading has required fields
-	for i, reading := range req.PM {
-		if reading.Date == "" {
-			return fmt.Errorf("PM[%d].date cannot be empty", i)
+	// Normalize before validating so whitespace-only fields are rejected and
+	// the published message carries the canonical form
+	normalizeReadings(req.PM, s.lowercaseMeterNames)
+
+	// Each stage only sees the readings that passed the previous ones; with
+	// partial acceptance, readings failing a stage are dropped from sc
+	sc := newScreening(req.PM)
+	var warnings []Warning
+	if err := s.screen(sc, checkFieldLengths(sc.readings, s.fieldLimits), &warnings); err != nil {
+		return prepared{}, err
+	}
+	if err := s.screen(sc, checkRequiredFields(sc.readings), &warnings); err != nil {
+		return prepared{}, err
+	}
+	if err := s.screen(sc, checkMeterNames(sc.readings, s.allowedMeterNames, s.meterNamePattern), &warnings); err != nil {
+		return prepared{}, err
+	}
+	if err := s.screen(sc, checkIntervals(sc.readings), &warnings); err != nil {
+		return prepared{}, err
+	}
+
+	// Dedupe after normalization so readings differing only in surrounding
+	// whitespace (or name case, when lowercasing) collapse too
+	duplicates := 0
+	if s.dedupeWithinRequest {
+		duplicates = dedupeReadings(sc)
+	}
+	// After exact duplicates collapsed, so those are not reported twice
+	switch s.duplicateNames {
+	case DuplicateNamesReject:
+		if err := s.screen(sc, checkUniqueNames(sc.readings, sc.index), &warnings); err != nil {
+			return prepared{}, err
+		}
+	case DuplicateNamesKeepLast:
+		duplicates += keepLastByName(sc)
+	}
+
+	if s.requireOrderedReadings || s.maxReadingAge > 0 {
+		var findings []*FieldError
+		sc.dates, findings = parseReadingDates(sc.readings, s.dateLayout)
+		if err := s.screen(sc, findings, &warnings); err != nil {
+			return prepared{}, err
 		}
-		if reading.Data == "" {
-			return fmt.Errorf("PM[%d].data cannot be empty", i)
+		if s.requireOrderedReadings {
+			if err := s.screen(sc, checkOrdered(sc.dates, sc.index), &warnings); err != nil {
+				return prepared{}, err
+			}
 		}
-		if reading.Name == "" {
-			return fmt.Errorf("PM[%d].name cannot be empty", i)
+		if s.maxReadingAge > 0 {
+			if err := s.screen(sc, checkMaxAge(sc.dates, s.clock.Now().Add(-s.maxReadingAge)), &warnings); err != nil {
+				return prepared{}, err
+			}
 		}
 	}
+	req.PM = sc.readings
 
-	// Generate request ID and fingerprint
-	requestID := uuid.New().String()
-	clientFingerprint := fingerprint.Generate(metadata.IPAddress, metadata.UserAgent)
+	tenantID, routingKey, err := s.resolveTenant(metadata.TenantID)
+	if err != nil {
+		return prepared{}, err
+	}
+
+	priority, err := resolvePriority(metadata.Priority, req.PM, s.meterPriorities, s.maxPriority)
+	if err != nil {
+		return prepared{}, err
+	}
 
-	// Create message
-	message := IngestMessage{
+	p := prepared{
+		req:        req,
+		duplicates: duplicates,
+		tenantID:   tenantID,
+		routingKey: routingKey,
+		priority:   priority,
+		warnings:   warnings,
+	}
+	if s.partialAccept {
+		p.accepted, p.rejected = sc.index, sc.rejections()
+	}
+	return p, nil
+}
+
+// ProcessReading processes and publishes a meter reading
+func (s *IngestService) ProcessReading(ctx context.Context, req IngestRequest, metadata ClientMetadata) (IngestResult, error) {
+	p, err := s.prepare(req, metadata)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	req, duplicates, tenantID, routingKey, priority, warnings := p.req, p.duplicates, p.tenantID, p.routingKey, p.priority, p.warnings
+	for _, w := range warnings {
+		validationWarnings.WithLabelValues(w.Rule).Inc()
+	}
+	for _, r := range p.rejected {
+		readingsRejected.WithLabelValues(r.Rule).Inc()
+	}
+
+	// Reuse the ID assigned by the HTTP layer so its logs and the message
+	// share it, and derive the fingerprint
+	receivedAt := s.clock.Now().UTC()
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		requestID = requestid.New(s.idFormat)
+	}
+	clientFingerprint := s.fingerprint(metadata)
+	logger := s.requestLogger(ctx, requestID, clientFingerprint, tenantID)
+	ctx = logging.WithLogger(ctx, logger)
+
+	if len(p.rejected) > 0 {
+		logger.Warn("Rejected invalid readings, accepting the rest",
+			zap.Int("readings_rejected", len(p.rejected)),
+			zap.Int("readings_accepted", len(p.accepted)),
+			zap.String("first_rejection", p.rejected[0].Message),
+		)
+	}
+
+	// Skip readings an earlier request already published within the window
+	previouslySeen := 0
+	if s.recent != nil {
+		req.PM, previouslySeen = s.recent.Filter(tenantID, req.PM)
+		if previouslySeen > 0 {
+			logger.Info("Skipped readings published by an earlier request",
+				zap.Int("previously_seen", previouslySeen),
+				zap.Int("remaining", len(req.PM)),
+			)
+		}
+		if len(req.PM) == 0 {
+			s.recordStatus(requestID, StatusPublished)
+			return IngestResult{
+				RequestID:         requestID,
+				ClientFingerprint: clientFingerprint,
+				DuplicatesDropped: duplicates,
+				PreviouslySeen:    previouslySeen,
+				Warnings:          warnings,
+				AcceptedIndices:   p.accepted,
+				Rejected:          p.rejected,
+			}, nil
+		}
+	}
+
+	// Create one message per shard, or a single one without sharding
+	parts := s.splitShards(routingKey, req.PM)
+	messages := make([]outgoing, 0, len(parts))
+	for _, part := range parts {
+		native := IngestMessage{
+			SchemaVersion:     SchemaVersion,
+			Source:            s.source,
+			RequestID:         requestID,
+			ClientFingerprint: clientFingerprint,
+			IPAddress:         metadata.IPAddress,
+			UserAgent:         metadata.UserAgent,
+			TenantID:          tenantID,
+			ReceivedAt:        receivedAt.Format(s.receivedAtLayout),
+			Payload:           IngestRequest{PM: part.readings},
+			Warnings:          warnings,
+		}
+		if part.shard >= 0 {
+			native.Shard = &part.shard
+		}
+		var message interface{} = native
+		if s.cloudEvents {
+			message = cloudEvent(native)
+		}
+		messages = append(messages, outgoing{routingKey: part.routingKey, message: message})
+	}
+
+	s.recordStatus(requestID, StatusPending)
+
+	job := publishJob{
+		requestID:         requestID,
+		clientFingerprint: clientFingerprint,
+		tenantID:          tenantID,
+		messages:          messages,
+		opts:              mq.PublishOptions{Priority: priority},
+		readings:          req.PM,
+		duplicates:        duplicates,
+		receivedAt:        receivedAt,
+		logger:            logger,
+	}
+	result := IngestResult{
 		RequestID:         requestID,
 		ClientFingerprint: clientFingerprint,
-		IPAddress:         metadata.IPAddress,
-		UserAgent:         metadata.UserAgent,
-		ReceivedAt:        time.Now().Format(time.RFC3339),
-		Payload:           req,
+		ReadingsAccepted:  len(req.PM),
+		DuplicatesDropped: duplicates,
+		PreviouslySeen:    previouslySeen,
+		Warnings:          warnings,
+		AcceptedIndices:   p.accepted,
+		Rejected:          p.rejected,
+	}
+
+	if s.sampledOut() {
+		s.recordStatus(requestID, StatusPublished)
+		requestsSampledOut.Inc()
+		logger.Info("Meter reading sampled out, not published",
+			zap.Int("readings_count", len(req.PM)),
+		)
+		return result, nil
 	}
 
-	// Publish to RabbitMQ
-	if err := s.publisher.Publish(ctx, s.routingKey, message); err != nil {
-		s.logger.Error("Failed to publish message",
-			zap.String("request_id", requestID),
+	if s.async != nil {
+		if err := s.async.enqueue(job); err != nil {
+			s.recordStatus(requestID, StatusFailed)
+			logger.Warn("Publish queue full, rejecting request")
+			return IngestResult{}, err
+		}
+		result.Queued = true
+		return result, nil
+	}
+
+	// Publish to RabbitMQ within the publish deadline
+	publishCtx := ctx
+	if s.deadline > 0 {
+		var cancel context.CancelFunc
+		publishCtx, cancel = context.WithTimeout(ctx, s.deadline)
+		defer cancel()
+	}
+
+	if err := s.publishAll(publishCtx, job); err != nil {
+		s.recordStatus(requestID, StatusFailed)
+		if ctx.Err() == nil && errors.Is(publishCtx.Err(), context.DeadlineExceeded) {
+			logger.Error("Publish deadline exceeded",
+				zap.Duration("deadline", s.deadline),
+				zap.Error(err),
+			)
+			return IngestResult{}, fmt.Errorf("%w after %s: %w", ErrPublishDeadline, s.deadline, err)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			logger.Warn("Publish aborted by caller",
+				zap.Error(ctxErr),
+			)
+			return IngestResult{}, fmt.Errorf("publish aborted: %w", ctxErr)
+		}
+		logger.Error("Failed to publish message",
 			zap.Error(err),
 		)
-		return fmt.Errorf("failed to publish message: %w", err)
+		return IngestResult{}, brokerError(err)
 	}
 
-	s.logger.Info("Meter reading ingested successfully",
-		zap.String("request_id", requestID),
-		zap.String("client_fingerprint", clientFingerprint),
-		zap.Int("readings_count", len(req.PM)),
+	s.published(job)
+	return result, nil
+}
+
+// publishAll publishes the messages of job in order. A failure after some
+// of them were published is marked mq.ErrDeliveryUnknown, since retrying the
+// request would duplicate the published ones.
+func (s *IngestService) publishAll(ctx context.Context, job publishJob) error {
+	if s.batches != nil {
+		return s.publishBatched(ctx, job)
+	}
+	if s.batchPublisher != nil && len(job.messages) > 1 {
+		return s.publishConfirmBatch(ctx, job)
+	}
+	for i, m := range job.messages {
+		if err := s.publisher.Publish(ctx, m.routingKey, m.message, job.opts); err != nil {
+			if i > 0 {
+				return fmt.Errorf("%w: %d of %d shard messages were published: %w", mq.ErrDeliveryUnknown, i, len(job.messages), err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// publishConfirmBatch publishes the messages of job with one confirm wait.
+// Messages the batch did not confirm are resent with Publish, which retries
+// and spools them: only the nacked ones after a partial nack, all of them
+// after any other failure, accepting duplicates as Publish's own retries do.
+// As in publishAll, a failure once a message may have been published is
+// marked mq.ErrDeliveryUnknown.
+func (s *IngestService) publishConfirmBatch(ctx context.Context, job publishJob) error {
+	msgs := make([]mq.BatchMessage, len(job.messages))
+	for i, m := range job.messages {
+		body, err := json.Marshal(m.message)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+		msgs[i] = mq.BatchMessage{RoutingKey: m.routingKey, Body: body, Opts: job.opts}
+	}
+
+	batchErr := s.batchPublisher.PublishBatch(ctx, msgs)
+	if batchErr == nil {
+		return nil
+	}
+
+	var nacked *mq.BatchError
+	var resend []int
+	if errors.As(batchErr, &nacked) {
+		resend = nacked.Failed
+	} else {
+		resend = make([]int, len(msgs))
+		for i := range resend {
+			resend[i] = i
+		}
+	}
+	job.logger.Warn("Batch publish failed, resending messages one by one",
+		zap.Ints("resend", resend),
+		zap.Int("batch_size", len(msgs)),
+		zap.Error(batchErr),
 	)
 
+	confirmed := len(msgs) - len(resend)
+	for _, i := range resend {
+		m := job.messages[i]
+		if err := s.publisher.Publish(ctx, m.routingKey, m.message, job.opts); err != nil {
+			if confirmed > 0 || errors.Is(batchErr, mq.ErrDeliveryUnknown) {
+				return fmt.Errorf("%w: %d of %d shard messages were published: %w", mq.ErrDeliveryUnknown, confirmed, len(msgs), err)
+			}
+			return err
+		}
+		confirmed++
+	}
 	return nil
 }
+
+// publishBatched adds every message of job to its batch at once, so shards
+// wait out one window rather than one each, and then waits for the batches.
+// As in publishAll, a failure once another message was published is
+// marked mq.ErrDeliveryUnknown.
+func (s *IngestService) publishBatched(ctx context.Context, job publishJob) error {
+	entries := make([]*batchEntry, len(job.messages))
+	for i, m := range job.messages {
+		entries[i] = s.batches.add(m.routingKey, m.message, job.opts)
+	}
+	var firstErr error
+	published := 0
+	for _, entry := range entries {
+		if err := s.batches.wait(ctx, entry); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		published++
+	}
+	if firstErr != nil && published > 0 {
+		return fmt.Errorf("%w: %d of %d shard messages were published: %w", mq.ErrDeliveryUnknown, published, len(job.messages), firstErr)
+	}
+	return firstErr
+}
+
+// published records a successfully published request in the status store,
+// logs, metrics, meter counts and audit log
+func (s *IngestService) published(job publishJob) {
+	s.recordStatus(job.requestID, StatusPublished)
+
+	job.logger.Info("Meter reading ingested successfully",
+		zap.Int("readings_count", len(job.readings)),
+		zap.Int("duplicates_dropped", job.duplicates),
+	)
+	readingsIngested.WithLabelValues(job.tenantID).Add(float64(len(job.readings)))
+	if s.meterCounter != nil {
+		s.meterCounter.Add(job.readings)
+	}
+	if s.recent != nil {
+		s.recent.Record(job.tenantID, job.readings)
+	}
+
+	if s.audit != nil {
+		s.audit.Record(audit.Event{
+			RequestID:         job.requestID,
+			ClientFingerprint: job.clientFingerprint,
+			TenantID:          job.tenantID,
+			MeterNames:        meterNames(job.readings),
+			ReadingsCount:     len(job.readings),
+			ReceivedAt:        job.receivedAt,
+		})
+	}
+}
+
+// requestLogger returns the logger of the request from ctx, or one carrying
+// requestID when ctx has none, with the client fingerprint and tenant added
+func (s *IngestService) requestLogger(ctx context.Context, requestID, clientFingerprint, tenantID string) *zap.Logger {
+	logger := logging.FromContext(ctx, nil)
+	if logger == nil {
+		logger = s.logger.With(zap.String("request_id", requestID))
+	}
+	return logger.With(
+		zap.String("client_fingerprint", clientFingerprint),
+		zap.String("tenant_id", tenantID),
+	)
+}
+
+// sampledOut reports whether this request is dropped by publish sampling
+func (s *IngestService) sampledOut() bool {
+	return s.sampleOut > 0 && rand.Float64() < s.sampleOut
+}
+
+// fingerprint derives the client fingerprint from the IP, User-Agent and the
+// configured additional inputs
+func (s *IngestService) fingerprint(metadata ClientMetadata) string {
+	in := fingerprint.Input{
+		IPAddress: metadata.IPAddress,
+		UserAgent: metadata.UserAgent,
+	}
+	if _, ok := s.fingerprintInputs[FingerprintKeyID]; ok {
+		in.KeyID = metadata.KeyID
+	}
+	if _, ok := s.fingerprintInputs[FingerprintTenantID]; ok {
+		in.TenantID = metadata.TenantID
+	}
+	if _, ok := s.fingerprintInputs[FingerprintDeviceID]; ok {
+		in.DeviceID = metadata.DeviceID
+	}
+	if _, ok := s.fingerprintInputs[FingerprintAcceptLanguage]; ok {
+		in.AcceptLanguage = metadata.AcceptLanguage
+	}
+	return fingerprint.Generate(in)
+}
+
+// meterNames returns the distinct reading names in order of first appearance
+func meterNames(readings []MeterReading) []string {
+	seen := make(map[string]struct{}, len(readings))
+	names := make([]string, 0, len(readings))
+	for _, r := range readings {
+		if _, ok := seen[r.Name]; ok {
+			continue
+		}
+		seen[r.Name] = struct{}{}
+		names = append(names, r.Name)
+	}
+	return names
+}
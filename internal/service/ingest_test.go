@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/logging"
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/tools/requestid"
+	"go.uber.org/zap"
+)
+
+// publishCall is one call to fakePublisher.Publish
+type publishCall struct {
+	routingKey string
+	message    interface{}
+	opts       mq.PublishOptions
+}
+
+// fakePublisher records published messages. fail, when set, decides the
+// error returned for the n-th call (counting from 0).
+type fakePublisher struct {
+	mu    sync.Mutex
+	calls []publishCall
+	fail  func(n int) error
+}
+
+func (f *fakePublisher) Publish(ctx context.Context, routingKey string, message interface{}, opts mq.PublishOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(f.calls)
+	f.calls = append(f.calls, publishCall{routingKey: routingKey, message: message, opts: opts})
+	if f.fail != nil {
+		return f.fail(n)
+	}
+	return nil
+}
+
+// published returns the calls made so far
+func (f *fakePublisher) published() []publishCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]publishCall(nil), f.calls...)
+}
+
+// fixedClock always returns the same time
+type fixedClock struct{ t time.Time }
+
+func (c fixedClock) Now() time.Time { return c.t }
+
+var testNow = time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+
+// newTestService returns a service publishing to pub with the routing key
+// "meter.reading.ingested", a fixed clock and the default date layout;
+// configure adjusts the options first
+func newTestService(t testing.TB, pub Publisher, configure func(*Options)) *IngestService {
+	t.Helper()
+	opts := Options{
+		RoutingKey: "meter.reading.ingested",
+		Source:     "ingest-test",
+		DateLayout: "02/01/2006 15:04:05",
+		Clock:      fixedClock{testNow},
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+	return NewIngestService(pub, zap.NewNop(), opts)
+}
+
+// readings builds a request from name, date, data triples
+func readings(fields ...string) IngestRequest {
+	var req IngestRequest
+	for i := 0; i+2 < len(fields); i += 3 {
+		req.PM = append(req.PM, MeterReading{Name: fields[i], Date: fields[i+1], Data: fields[i+2]})
+	}
+	return req
+}
+
+// nativeMessage returns the IngestMessage of a publish call
+func nativeMessage(t testing.TB, call publishCall) IngestMessage {
+	t.Helper()
+	msg, ok := call.message.(IngestMessage)
+	if !ok {
+		t.Fatalf("published %T, want IngestMessage", call.message)
+	}
+	return msg
+}
+
+func TestProcessReadingPublishesEnvelope(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) {
+		o.MultiTenancy = true
+		o.AllowedTenants = []string{"acme"}
+		o.TenantRoutingKeyTemplate = "meter.{tenant}.ingested"
+	})
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	req := readings("meter-1", "01/03/2024 12:00:00", "42.5", "meter-2", "01/03/2024 12:15:00", "17")
+	metadata := ClientMetadata{IPAddress: "203.0.113.7", UserAgent: "collector/1.0", TenantID: "acme"}
+
+	result, err := s.ProcessReading(ctx, req, metadata)
+	if err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if result.RequestID != "req-1" || result.ReadingsAccepted != 2 {
+		t.Errorf("result = %+v, want request req-1 with 2 readings", result)
+	}
+
+	calls := pub.published()
+	if len(calls) != 1 {
+		t.Fatalf("published %d messages, want 1", len(calls))
+	}
+	if calls[0].routingKey != "meter.acme.ingested" {
+		t.Errorf("routing key = %q, want the tenant's", calls[0].routingKey)
+	}
+	msg := nativeMessage(t, calls[0])
+	if msg.SchemaVersion != SchemaVersion || msg.Source != "ingest-test" {
+		t.Errorf("schema_version=%q source=%q", msg.SchemaVersion, msg.Source)
+	}
+	if msg.RequestID != "req-1" || msg.TenantID != "acme" {
+		t.Errorf("request_id=%q tenant_id=%q, want req-1 and acme", msg.RequestID, msg.TenantID)
+	}
+	if msg.IPAddress != "203.0.113.7" || msg.UserAgent != "collector/1.0" {
+		t.Errorf("ip_address=%q user_agent=%q", msg.IPAddress, msg.UserAgent)
+	}
+	if msg.ClientFingerprint == "" || msg.ClientFingerprint != result.ClientFingerprint {
+		t.Errorf("client_fingerprint=%q, want the result's %q", msg.ClientFingerprint, result.ClientFingerprint)
+	}
+	if msg.ReceivedAt != "2024-03-01T12:30:45.123Z" {
+		t.Errorf("received_at = %q", msg.ReceivedAt)
+	}
+	if msg.Shard != nil {
+		t.Errorf("shard = %d, want none without sharding", *msg.Shard)
+	}
+	if len(msg.Payload.PM) != 2 || msg.Payload.PM[0] != req.PM[0] || msg.Payload.PM[1] != req.PM[1] {
+		t.Errorf("payload = %+v, want the request's readings", msg.Payload.PM)
+	}
+}
+
+func TestProcessReadingGeneratesMissingRequestID(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.RequestIDFormat = requestid.FormatULID })
+
+	result, err := s.ProcessReading(context.Background(), readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{})
+	if err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if !requestid.Valid(requestid.FormatULID, result.RequestID) {
+		t.Fatalf("request ID %q is not a generated ULID", result.RequestID)
+	}
+	if got := nativeMessage(t, pub.published()[0]).RequestID; got != result.RequestID {
+		t.Errorf("published request_id = %q, want %q", got, result.RequestID)
+	}
+}
+
+func TestProcessReadingPublishFailure(t *testing.T) {
+	pub := &fakePublisher{fail: func(int) error { return &mq.NackError{DeliveryTag: 1} }}
+	statuses := NewStatusStore(time.Minute, 10)
+	s := newTestService(t, pub, func(o *Options) { o.StatusStore = statuses })
+
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	_, err := s.ProcessReading(ctx, readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{})
+	if !errors.Is(err, ErrBrokerUnavailable) {
+		t.Fatalf("ProcessReading = %v, want ErrBrokerUnavailable", err)
+	}
+	var nackErr *mq.NackError
+	if !errors.As(err, &nackErr) {
+		t.Errorf("ProcessReading = %v, want the nack wrapped", err)
+	}
+	if DeliveryUnknown(err) {
+		t.Error("a nacked publish was reported as possibly delivered")
+	}
+	if entry, ok := s.Status("req-1"); !ok || entry.Status != StatusFailed {
+		t.Errorf("status = %+v, %t, want failed", entry, ok)
+	}
+}
+
+func TestProcessReadingRejectsUnknownTenant(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) {
+		o.MultiTenancy = true
+		o.AllowedTenants = []string{"acme"}
+	})
+
+	_, err := s.ProcessReading(context.Background(), readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{TenantID: "other"})
+	if !errors.Is(err, ErrInvalidTenant) {
+		t.Fatalf("ProcessReading = %v, want ErrInvalidTenant", err)
+	}
+	if len(pub.published()) != 0 {
+		t.Error("a request for an unknown tenant was published")
+	}
+}
+
+func TestProcessReadingRejectsEmptyBatch(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, nil)
+
+	_, err := s.ProcessReading(context.Background(), IngestRequest{}, ClientMetadata{})
+	if !errors.Is(err, ErrEmptyBatch) || RejectionReason(err) != RejectEmptyBatch {
+		t.Fatalf("ProcessReading = %v, want ErrEmptyBatch", err)
+	}
+	if len(pub.published()) != 0 {
+		t.Error("an invalid request was published")
+	}
+}
+
+func TestPublishedJSONCarriesSchemaVersion(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, nil)
+
+	if _, err := s.ProcessReading(context.Background(), readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	// The publisher marshals messages with encoding/json
+	body, err := json.Marshal(pub.published()[0].message)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if envelope["schema_version"] != "1.0" {
+		t.Errorf("schema_version = %v, want \"1.0\"", envelope["schema_version"])
+	}
+	if envelope["source"] != "ingest-test" {
+		t.Errorf("source = %v, want the service instance", envelope["source"])
+	}
+}
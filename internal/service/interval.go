@@ -0,0 +1,32 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// intervalPattern matches an ISO 8601 duration: PnW, or any of PnYnMnD
+// followed by any of TnHnMnS, with a decimal fraction allowed on seconds
+var intervalPattern = regexp.MustCompile(`^P(?:\d+W|(?:\d+Y)?(?:\d+M)?(?:\d+D)?(?:T(?:\d+H)?(?:\d+M)?(?:\d+(?:[.,]\d+)?S)?)?)$`)
+
+// validInterval reports whether interval is a positive ISO 8601 duration
+// such as "PT15M" or "P1D". The bare designators "P" and "PT" are rejected,
+// as is a zero duration, which cannot describe a consumption window.
+func validInterval(interval string) bool {
+	return intervalPattern.MatchString(interval) &&
+		!strings.HasSuffix(interval, "T") &&
+		strings.ContainsAny(interval, "123456789")
+}
+
+// checkIntervals ensures every reading with an interval carries a valid one;
+// readings without an interval are point-in-time readings and always pass
+func checkIntervals(readings []MeterReading) []*FieldError {
+	var findings []*FieldError
+	for i, reading := range readings {
+		if reading.Interval != "" && !validInterval(reading.Interval) {
+			findings = append(findings, &FieldError{Index: i, Field: "interval", Reason: fmt.Sprintf("%q is not a positive ISO 8601 duration such as PT15M", reading.Interval), Rule: RejectInvalidInterval})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     bool
+	}{
+		{"PT15M", true},
+		{"PT1H", true},
+		{"P1D", true},
+		{"P2W", true},
+		{"P1Y2M3DT4H5M6S", true},
+		{"PT0.5S", true},
+		{"PT1,5S", true},
+		{"P", false},
+		{"PT", false},
+		{"P1DT", false},
+		{"PT0M", false},
+		{"P0D", false},
+		{"15m", false},
+		{"PT15", false},
+		{"pt15m", false},
+		{"P1W2D", false},
+		{"PT-15M", false},
+		{"PT15M ", false},
+	}
+	for _, tt := range tests {
+		if got := validInterval(tt.interval); got != tt.want {
+			t.Errorf("validInterval(%q) = %t, want %t", tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestProcessReadingInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		wantErr  bool
+	}{
+		{"valid", "PT15M", false},
+		{"absent", "", false},
+		{"malformed", "15 minutes", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, nil)
+			req := IngestRequest{PM: []MeterReading{{Name: "m1", Date: "01/03/2024 12:00:00", Data: "1", Interval: tt.interval}}}
+
+			_, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+			if tt.wantErr {
+				if RejectionReason(err) != RejectInvalidInterval {
+					t.Fatalf("ProcessReading = %v, want an invalid_interval rejection", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessReading: %v", err)
+			}
+			calls := pub.published()
+			if len(calls) != 1 {
+				t.Fatalf("published %d messages, want 1", len(calls))
+			}
+			if got := nativeMessage(t, calls[0]).Payload.PM[0].Interval; got != tt.interval {
+				t.Errorf("published interval %q, want %q", got, tt.interval)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// meterCountBuckets is how many time buckets a MeterCounter window is split into
+const meterCountBuckets = 15
+
+// meterEntry holds the per-bucket counts of one meter name. epochs records
+// which bucket period each slot belongs to, so stale slots read as zero.
+type meterEntry struct {
+	counts [meterCountBuckets]int64
+	epochs [meterCountBuckets]int64
+}
+
+// MeterCounter counts readings per meter name over a sliding window, for an
+// ad-hoc view of which meters are reporting. At most maxNames names are
+// tracked: a new name evicts the one with the lowest count, so a flood of
+// unique names cannot grow memory without bound.
+type MeterCounter struct {
+	bucketWidth time.Duration
+	maxNames    int
+	clock       Clock
+
+	mu      sync.Mutex
+	entries map[string]*meterEntry
+}
+
+// NewMeterCounter creates a counter over window tracking at most maxNames names
+func NewMeterCounter(window time.Duration, maxNames int) *MeterCounter {
+	return &MeterCounter{
+		bucketWidth: max(window/meterCountBuckets, time.Millisecond),
+		maxNames:    maxNames,
+		clock:       systemClock{},
+		entries:     make(map[string]*meterEntry),
+	}
+}
+
+// Add counts readings
+func (m *MeterCounter) Add(readings []MeterReading) {
+	epoch := m.epoch()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range readings {
+		e, ok := m.entries[r.Name]
+		if !ok {
+			if len(m.entries) >= m.maxNames {
+				m.evictLowest(epoch)
+			}
+			e = &meterEntry{}
+			m.entries[r.Name] = e
+		}
+		slot := epoch % meterCountBuckets
+		if e.epochs[slot] != epoch {
+			e.epochs[slot], e.counts[slot] = epoch, 0
+		}
+		e.counts[slot]++
+	}
+}
+
+// Snapshot returns the reading count of every meter seen within the window,
+// and forgets meters that have not reported since
+func (m *MeterCounter) Snapshot() map[string]int64 {
+	epoch := m.epoch()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int64, len(m.entries))
+	for name, e := range m.entries {
+		total := e.total(epoch)
+		if total == 0 {
+			delete(m.entries, name)
+			continue
+		}
+		counts[name] = total
+	}
+	return counts
+}
+
+// evictLowest removes the name with the lowest count; mu must be held
+func (m *MeterCounter) evictLowest(epoch int64) {
+	var lowestName string
+	lowest := int64(-1)
+	for name, e := range m.entries {
+		total := e.total(epoch)
+		if lowest < 0 || total < lowest {
+			lowestName, lowest = name, total
+			if total == 0 {
+				break
+			}
+		}
+	}
+	delete(m.entries, lowestName)
+}
+
+// epoch returns the index of the current bucket period
+func (m *MeterCounter) epoch() int64 {
+	return m.clock.Now().UnixNano() / int64(m.bucketWidth)
+}
+
+// total sums the slots that belong to the window ending at epoch
+func (e *meterEntry) total(epoch int64) int64 {
+	var total int64
+	for i, count := range e.counts {
+		if epoch-e.epochs[i] < meterCountBuckets {
+			total += count
+		}
+	}
+	return total
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Ingest metrics. The tenant label is empty when multi-tenancy is disabled and
+// otherwise bounded by the tenant allow-list.
+var readingsIngested = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingest_readings_total",
+	Help: "Number of meter readings successfully published.",
+}, []string{"tenant"})
+
+// validationWarnings counts findings downgraded to warnings, by Reject* rule
+var validationWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingest_validation_warnings_total",
+	Help: "Number of validation findings accepted as warnings, by rule.",
+}, []string{"rule"})
+
+// readingsRejected counts readings dropped by partial acceptance, by Reject* rule
+var readingsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ingest_readings_rejected_total",
+	Help: "Number of invalid readings dropped from partially accepted requests, by rule.",
+}, []string{"rule"})
+
+// requestsSampledOut counts valid requests dropped by publish sampling
+var requestsSampledOut = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ingest_sampled_out_total",
+	Help: "Number of valid ingest requests dropped instead of published by publish sampling.",
+})
+
+// Batching metrics, only updated when BatchWindow is set
+var (
+	batchesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ingest_batches_total",
+		Help: "Number of batch messages published, by what triggered the flush (size, window, shutdown).",
+	}, []string{"trigger"})
+	batchMessages = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ingest_batch_messages",
+		Help:    "Number of request messages combined into each batch message.",
+		Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+	})
+)
+
+// Async ingest metrics, only updated with INGEST_MODE=async
+var (
+	asyncQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ingest_async_queue_depth",
+		Help: "Number of accepted requests waiting to be published.",
+	})
+	asyncPublishes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ingest_async_publishes_total",
+		Help: "Number of queued requests published by the async workers, by outcome.",
+	}, []string{"outcome"})
+)
@@ -0,0 +1,23 @@
+package service
+
+import "strings"
+
+// normalizeReadings rewrites readings into their canonical form in place.
+// The transformations are, in order:
+//
+//  1. leading and trailing whitespace is trimmed from date, data, name and interval
+//  2. if lowercaseNames is set, name is lowercased (Unicode-aware)
+//
+// No other changes are made; in particular inner whitespace is preserved.
+func normalizeReadings(readings []MeterReading, lowercaseNames bool) {
+	for i := range readings {
+		r := &readings[i]
+		r.Date = strings.TrimSpace(r.Date)
+		r.Data = strings.TrimSpace(r.Data)
+		r.Name = strings.TrimSpace(r.Name)
+		r.Interval = strings.TrimSpace(r.Interval)
+		if lowercaseNames {
+			r.Name = strings.ToLower(r.Name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNormalizeReadings(t *testing.T) {
+	tests := []struct {
+		name      string
+		lowercase bool
+		in        MeterReading
+		want      MeterReading
+	}{
+		{
+			name: "trims every field",
+			in:   MeterReading{Name: " Meter-1 ", Date: "\t01/03/2024 12:00:00\n", Data: " 42.5", Interval: "15m "},
+			want: MeterReading{Name: "Meter-1", Date: "01/03/2024 12:00:00", Data: "42.5", Interval: "15m"},
+		},
+		{
+			name:      "lowercases names when enabled",
+			lowercase: true,
+			in:        MeterReading{Name: " ÜBER Meter ", Date: "d", Data: "1"},
+			want:      MeterReading{Name: "über meter", Date: "d", Data: "1"},
+		},
+		{
+			name: "keeps inner whitespace and case",
+			in:   MeterReading{Name: "Meter  A", Date: "d", Data: "1"},
+			want: MeterReading{Name: "Meter  A", Date: "d", Data: "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readings := []MeterReading{tt.in}
+			normalizeReadings(readings, tt.lowercase)
+			if readings[0] != tt.want {
+				t.Errorf("normalized %+v, want %+v", readings[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessReadingNormalizesBeforePublish(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.LowercaseMeterNames = true })
+
+	req := readings(" Meter-1 ", " 01/03/2024 12:00:00 ", " 42.5 ")
+	if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	got := nativeMessage(t, pub.published()[0]).Payload.PM[0]
+	want := MeterReading{Name: "meter-1", Date: "01/03/2024 12:00:00", Data: "42.5"}
+	if got != want {
+		t.Errorf("published %+v, want %+v", got, want)
+	}
+}
+
+func TestProcessReadingRejectsWhitespaceOnlyFields(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, nil)
+
+	_, err := s.ProcessReading(context.Background(), readings("   ", "01/03/2024 12:00:00", "1"), ClientMetadata{})
+	if err == nil {
+		t.Fatal("a whitespace-only name was accepted")
+	}
+	if len(pub.published()) != 0 {
+		t.Error("an invalid reading was published")
+	}
+}
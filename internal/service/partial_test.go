@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPartialAcceptMixedBatch(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) {
+		o.PartialAccept = true
+		o.AllowedMeterNames = []string{"m1", "m2", "m3"}
+	})
+
+	req := readings(
+		"m1", "01/03/2024 12:00:00", "1",
+		"m2", " ", "2", // missing date
+		"m9", "01/03/2024 12:00:00", "3", // unknown meter
+		"m3", "01/03/2024 12:00:00", "4",
+	)
+	result, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+	if err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if !reflect.DeepEqual(result.AcceptedIndices, []int{0, 3}) || result.ReadingsAccepted != 2 {
+		t.Errorf("accepted %v (%d readings), want indices [0 3]", result.AcceptedIndices, result.ReadingsAccepted)
+	}
+	var rejected []string
+	for _, r := range result.Rejected {
+		if r.Message == "" {
+			t.Errorf("rejection %+v has no message", r)
+		}
+		rejected = append(rejected, r.Rule)
+	}
+	if len(result.Rejected) != 2 || result.Rejected[0].Index != 1 || result.Rejected[1].Index != 2 ||
+		!reflect.DeepEqual(rejected, []string{RejectMissingDate, RejectUnknownMeter}) {
+		t.Errorf("rejected %+v, want index 1 missing_date and index 2 unknown_meter", result.Rejected)
+	}
+
+	calls := pub.published()
+	if len(calls) != 1 {
+		t.Fatalf("published %d messages, want 1", len(calls))
+	}
+	pm := nativeMessage(t, calls[0]).Payload.PM
+	if len(pm) != 2 || pm[0].Name != "m1" || pm[1].Name != "m3" {
+		t.Errorf("published readings %+v, want only m1 and m3", pm)
+	}
+}
+
+func TestPartialAcceptRejectsWhenNothingValid(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.PartialAccept = true })
+
+	_, err := s.ProcessReading(context.Background(), readings("m1", " ", "1", " ", "01/03/2024 12:00:00", "2"), ClientMetadata{})
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("ProcessReading = %v, want a validation error", err)
+	}
+	if n := len(pub.published()); n != 0 {
+		t.Errorf("published %d messages, want none", n)
+	}
+}
+
+func TestAllOrNothingWithoutPartialAccept(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, nil)
+
+	_, err := s.ProcessReading(context.Background(), readings("m1", "01/03/2024 12:00:00", "1", "m2", " ", "2"), ClientMetadata{})
+	if RejectionReason(err) != RejectMissingDate {
+		t.Fatalf("ProcessReading = %v, want a missing_date rejection", err)
+	}
+	if n := len(pub.published()); n != 0 {
+		t.Errorf("published %d messages, want none", n)
+	}
+}
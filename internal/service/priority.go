@@ -0,0 +1,28 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// resolvePriority returns the publish priority of a request: the X-Priority
+// header when present, otherwise the highest priority mapped to any of its
+// meter names, otherwise 0. Priorities above maxPriority are rejected.
+func resolvePriority(header string, readings []MeterReading, meterPriorities map[string]uint8, maxPriority uint8) (uint8, error) {
+	if header != "" {
+		priority, err := strconv.ParseUint(header, 10, 8)
+		if err != nil {
+			return 0, fmt.Errorf("%w: must be an integer between 0 and 255", ErrInvalidPriority)
+		}
+		if priority > uint64(maxPriority) {
+			return 0, fmt.Errorf("%w: %d exceeds the maximum priority %d", ErrInvalidPriority, priority, maxPriority)
+		}
+		return uint8(priority), nil
+	}
+
+	var priority uint8
+	for _, r := range readings {
+		priority = max(priority, meterPriorities[r.Name])
+	}
+	return priority, nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPublishPriority(t *testing.T) {
+	mapping := map[string]uint8{"tamper-1": 9, "alarm-1": 5}
+	tests := []struct {
+		name    string
+		header  string
+		meters  []string
+		want    uint8
+		wantErr bool
+	}{
+		{"default is 0", "", []string{"meter-1"}, 0, false},
+		{"header", "3", []string{"meter-1"}, 3, false},
+		{"header overrides the mapping", "1", []string{"tamper-1"}, 1, false},
+		{"header at the maximum", "9", []string{"meter-1"}, 9, false},
+		{"header above the maximum", "10", []string{"meter-1"}, 0, true},
+		{"header out of byte range", "256", []string{"meter-1"}, 0, true},
+		{"malformed header", "high", []string{"meter-1"}, 0, true},
+		{"mapped meter", "", []string{"meter-1", "alarm-1"}, 5, false},
+		{"highest mapped meter wins", "", []string{"alarm-1", "tamper-1", "meter-1"}, 9, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req IngestRequest
+			for _, name := range tt.meters {
+				req.PM = append(req.PM, MeterReading{Name: name, Date: "01/03/2024 12:00:00", Data: "1"})
+			}
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) {
+				o.MaxPriority = 9
+				o.MeterPriorities = mapping
+			})
+
+			_, err := s.ProcessReading(context.Background(), req, ClientMetadata{Priority: tt.header})
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPriority) || RejectionReason(err) != RejectInvalidPriority {
+					t.Fatalf("ProcessReading = %v, want ErrInvalidPriority", err)
+				}
+				if len(pub.published()) != 0 {
+					t.Error("a request with an invalid priority was published")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessReading: %v", err)
+			}
+			if got := pub.published()[0].opts.Priority; got != tt.want {
+				t.Errorf("priority = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeterPrioritiesFollowNameNormalization(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) {
+		o.LowercaseMeterNames = true
+		o.MaxPriority = 9
+		o.MeterPriorities = map[string]uint8{"Tamper-1": 9}
+	})
+	if _, err := s.ProcessReading(context.Background(), readings(" TAMPER-1 ", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if got := pub.published()[0].opts.Priority; got != 9 {
+		t.Errorf("priority = %d, want the mapped 9", got)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// readingKey identifies a reading across requests
+type readingKey struct {
+	tenantID string
+	name     string
+	date     string
+}
+
+type recentRecord struct {
+	key       readingKey
+	expiresAt time.Time
+}
+
+// RecentReadings is a bounded in-memory set of the (tenant, name, date) of
+// recently published readings, used to skip readings resent in a later
+// request. Entries expire ttl after they were recorded, and the oldest
+// entries are evicted once maxEntries is reached. Nothing is persisted or
+// shared between instances, so deduplication is best effort.
+type RecentReadings struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	records map[readingKey]*list.Element
+	order   *list.List // oldest first; every record shares the same ttl
+}
+
+// NewRecentReadings creates a recent-readings cache
+func NewRecentReadings(ttl time.Duration, maxEntries int) *RecentReadings {
+	return &RecentReadings{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		records:    make(map[readingKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Filter returns the readings not seen within the window, in their original
+// order, and how many were skipped. It does not record anything: readings
+// are recorded once published, so a failed publish can be retried.
+func (r *RecentReadings) Filter(tenantID string, readings []MeterReading) ([]MeterReading, int) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evict(now)
+	unseen := make([]MeterReading, 0, len(readings))
+	for _, reading := range readings {
+		if _, ok := r.records[readingKey{tenantID, reading.Name, reading.Date}]; ok {
+			continue
+		}
+		unseen = append(unseen, reading)
+	}
+	return unseen, len(readings) - len(unseen)
+}
+
+// Record adds published readings, keeping the original expiry of readings
+// already known
+func (r *RecentReadings) Record(tenantID string, readings []MeterReading) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.evict(now)
+	for _, reading := range readings {
+		key := readingKey{tenantID, reading.Name, reading.Date}
+		if _, ok := r.records[key]; ok {
+			continue
+		}
+		for r.maxEntries > 0 && r.order.Len() >= r.maxEntries {
+			r.remove(r.order.Front())
+		}
+		r.records[key] = r.order.PushBack(&recentRecord{key: key, expiresAt: now.Add(r.ttl)})
+	}
+}
+
+// evict drops expired records from the front of the list
+func (r *RecentReadings) evict(now time.Time) {
+	for el := r.order.Front(); el != nil && !now.Before(el.Value.(*recentRecord).expiresAt); el = r.order.Front() {
+		r.remove(el)
+	}
+}
+
+func (r *RecentReadings) remove(el *list.Element) {
+	delete(r.records, el.Value.(*recentRecord).key)
+	r.order.Remove(el)
+}
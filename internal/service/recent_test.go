@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecentReadingsFilter(t *testing.T) {
+	r := NewRecentReadings(time.Minute, 100)
+	r.Record("", readings("m1", "01/03/2024 12:00:00", "1").PM)
+
+	tests := []struct {
+		name     string
+		tenantID string
+		req      IngestRequest
+		wantSeen int
+	}{
+		{"hit", "", readings("m1", "01/03/2024 12:00:00", "9"), 1},
+		{"miss on date", "", readings("m1", "01/03/2024 12:15:00", "1"), 0},
+		{"miss on name", "", readings("m2", "01/03/2024 12:00:00", "1"), 0},
+		{"miss on tenant", "acme", readings("m1", "01/03/2024 12:00:00", "1"), 0},
+		{"mixed", "", readings("m1", "01/03/2024 12:00:00", "1", "m2", "01/03/2024 12:00:00", "1"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unseen, seen := r.Filter(tt.tenantID, tt.req.PM)
+			if seen != tt.wantSeen || len(unseen) != len(tt.req.PM)-tt.wantSeen {
+				t.Errorf("Filter = %d unseen, %d seen, want %d seen", len(unseen), seen, tt.wantSeen)
+			}
+		})
+	}
+}
+
+func TestRecentReadingsExpiry(t *testing.T) {
+	r := NewRecentReadings(20*time.Millisecond, 100)
+	pm := readings("m1", "01/03/2024 12:00:00", "1").PM
+	r.Record("", pm)
+	if _, seen := r.Filter("", pm); seen != 1 {
+		t.Fatal("a recorded reading was not seen within its window")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, seen := r.Filter("", pm); seen != 0 {
+		t.Error("a reading was still seen after its window")
+	}
+}
+
+func TestRecentReadingsEvictsOldest(t *testing.T) {
+	r := NewRecentReadings(time.Minute, 2)
+	r.Record("", readings("a", "01/03/2024 12:00:00", "1", "b", "01/03/2024 12:00:00", "1").PM)
+	r.Record("", readings("c", "01/03/2024 12:00:00", "1").PM) // evicts a
+	if _, seen := r.Filter("", readings("a", "01/03/2024 12:00:00", "1").PM); seen != 0 {
+		t.Error("the oldest reading was not evicted at capacity")
+	}
+	if _, seen := r.Filter("", readings("c", "01/03/2024 12:00:00", "1").PM); seen != 1 {
+		t.Error("a recent reading was evicted")
+	}
+}
+
+func TestProcessReadingSkipsPreviouslySeen(t *testing.T) {
+	pub := &fakePublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.RecentReadings = NewRecentReadings(time.Minute, 100) })
+
+	first := readings("m1", "01/03/2024 12:00:00", "1", "m2", "01/03/2024 12:00:00", "2")
+	if _, err := s.ProcessReading(context.Background(), first, ClientMetadata{}); err != nil {
+		t.Fatalf("first ProcessReading: %v", err)
+	}
+	// The collector resends m1 along with a new reading
+	second := readings("m1", "01/03/2024 12:00:00", "1", "m3", "01/03/2024 12:00:00", "3")
+	result, err := s.ProcessReading(context.Background(), second, ClientMetadata{})
+	if err != nil {
+		t.Fatalf("second ProcessReading: %v", err)
+	}
+	if result.PreviouslySeen != 1 || result.ReadingsAccepted != 1 {
+		t.Errorf("PreviouslySeen=%d ReadingsAccepted=%d, want 1 and 1", result.PreviouslySeen, result.ReadingsAccepted)
+	}
+	calls := pub.published()
+	if len(calls) != 2 {
+		t.Fatalf("published %d messages, want 2", len(calls))
+	}
+	if pm := nativeMessage(t, calls[1]).Payload.PM; len(pm) != 1 || pm[0].Name != "m3" {
+		t.Errorf("second message readings %+v, want only m3", pm)
+	}
+
+	// A request of only seen readings publishes nothing but succeeds
+	result, err = s.ProcessReading(context.Background(), first, ClientMetadata{})
+	if err != nil || result.PreviouslySeen != 2 {
+		t.Fatalf("resent request = %+v, %v, want 2 previously seen", result, err)
+	}
+	if n := len(pub.published()); n != 2 {
+		t.Errorf("published %d messages after a fully seen request, want 2", n)
+	}
+}
+
+func TestProcessReadingRecordsOnlyPublished(t *testing.T) {
+	pub := &fakePublisher{fail: func(n int) error {
+		if n == 0 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}}
+	s := newTestService(t, pub, func(o *Options) { o.RecentReadings = NewRecentReadings(time.Minute, 100) })
+
+	req := readings("m1", "01/03/2024 12:00:00", "1")
+	if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err == nil {
+		t.Fatal("ProcessReading succeeded despite the failed publish")
+	}
+	// The retry of a failed request must not be skipped
+	result, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+	if err != nil || result.PreviouslySeen != 0 || result.ReadingsAccepted != 1 {
+		t.Errorf("retry = %+v, %v, want the reading published", result, err)
+	}
+}
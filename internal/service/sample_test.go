@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSampleOut(t *testing.T) {
+	const requests = 2000
+	tests := []struct {
+		name      string
+		sampleOut float64
+		min, max  int // bounds on published requests
+	}{
+		{"disabled", 0, requests, requests},
+		{"everything", 1, 0, 0},
+		// Binomial with sd ~22, so ±150 never fails by chance
+		{"half", 0.5, requests/2 - 150, requests/2 + 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) { o.SampleOut = tt.sampleOut })
+			before := testutil.ToFloat64(requestsSampledOut)
+
+			for i := 0; i < requests; i++ {
+				if _, err := s.ProcessReading(context.Background(), readings("m1", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err != nil {
+					t.Fatalf("ProcessReading: %v", err)
+				}
+			}
+			published := len(pub.published())
+			if published < tt.min || published > tt.max {
+				t.Errorf("published %d of %d requests, want %d to %d", published, requests, tt.min, tt.max)
+			}
+			if got := int(testutil.ToFloat64(requestsSampledOut) - before); got != requests-published {
+				t.Errorf("ingest_sampled_out_total rose by %d, want %d", got, requests-published)
+			}
+		})
+	}
+}
+
+func TestSampleOutStillValidates(t *testing.T) {
+	s := newTestService(t, &fakePublisher{}, func(o *Options) { o.SampleOut = 1 })
+	before := testutil.ToFloat64(requestsSampledOut)
+
+	if _, err := s.ProcessReading(context.Background(), readings(" ", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err == nil {
+		t.Fatal("an invalid request was accepted while sampling")
+	}
+	if got := testutil.ToFloat64(requestsSampledOut) - before; got != 0 {
+		t.Errorf("ingest_sampled_out_total rose by %v for an invalid request", got)
+	}
+}
+
+func BenchmarkProcessReadingSampledOut(b *testing.B) {
+	req := readings("m1", "01/03/2024 12:00:00", "1", "m2", "01/03/2024 12:00:00", "2")
+	for _, sampleOut := range []float64{0, 1} {
+		name := "published"
+		if sampleOut == 1 {
+			name = "sampled_out"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := newTestService(b, &fakePublisher{}, func(o *Options) { o.SampleOut = sampleOut })
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import "time"
+
+// Rejection is a reading dropped from a partially accepted request
+type Rejection struct {
+	Index   int    `json:"index"` // position in the request's PM
+	Rule    string `json:"rule"`
+	Message string `json:"message"` // e.g. `PM[2].date cannot be empty`
+}
+
+// screening holds the readings of a request still being validated, with the
+// request index of each, so findings name the reading the client sent even
+// after duplicates or rejected readings were removed
+type screening struct {
+	readings []MeterReading
+	index    []int
+	dates    []time.Time   // parsed dates of readings, once the date checks run
+	rejected []*FieldError // partial acceptance only, in the order found
+}
+
+func newScreening(readings []MeterReading) *screening {
+	index := make([]int, len(readings))
+	for i := range index {
+		index[i] = i
+	}
+	return &screening{readings: readings, index: index}
+}
+
+// retain keeps the readings for which keep, given the request index,
+// returns true, in order, and returns how many were removed. The readings,
+// and their dates once parsed, are compacted in place.
+func (sc *screening) retain(keep func(index int, r MeterReading) bool) int {
+	n := 0
+	for i, r := range sc.readings {
+		if keep(sc.index[i], r) {
+			sc.readings[n], sc.index[n] = r, sc.index[i]
+			if sc.dates != nil {
+				sc.dates[n] = sc.dates[i]
+			}
+			n++
+		}
+	}
+	removed := len(sc.readings) - n
+	sc.readings, sc.index = sc.readings[:n], sc.index[:n]
+	if sc.dates != nil {
+		sc.dates = sc.dates[:n]
+	}
+	return removed
+}
+
+// screen applies the findings of one validation stage, indexed into
+// sc.readings. Without partial acceptance it behaves like triage. With it,
+// each reading with a rejecting finding is dropped and its first such
+// finding recorded, and the request is only rejected once no reading is
+// left. Warnings are kept for the readings that remain.
+func (s *IngestService) screen(sc *screening, findings []*FieldError, warnings *[]Warning) error {
+	for _, f := range findings {
+		f.Index = sc.index[f.Index]
+	}
+	if !s.partialAccept {
+		return s.triage(findings, warnings)
+	}
+
+	dropped := make(map[int]struct{})
+	for _, f := range findings {
+		if _, ok := s.warnRules[f.Rule]; ok {
+			continue
+		}
+		if _, ok := dropped[f.Index]; !ok {
+			dropped[f.Index] = struct{}{}
+			sc.rejected = append(sc.rejected, f)
+		}
+	}
+	for _, f := range findings {
+		_, warn := s.warnRules[f.Rule]
+		if _, ok := dropped[f.Index]; warn && !ok {
+			*warnings = append(*warnings, Warning{Rule: f.Rule, Message: f.detail()})
+		}
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+	sc.retain(func(index int, _ MeterReading) bool {
+		_, ok := dropped[index]
+		return !ok
+	})
+	if len(sc.readings) == 0 {
+		return sc.rejected[0]
+	}
+	return nil
+}
+
+// rejections converts the readings dropped by partial acceptance
+func (sc *screening) rejections() []Rejection {
+	out := make([]Rejection, 0, len(sc.rejected))
+	for _, f := range sc.rejected {
+		out = append(out, Rejection{Index: f.Index, Rule: f.Rule, Message: f.detail()})
+	}
+	return out
+}
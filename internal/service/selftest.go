@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+	"github.com/septivank/energy-metering-ingest-api/tools/requestid"
+)
+
+// SelfTestResult describes a completed self-test publish
+type SelfTestResult struct {
+	RequestID  string
+	RoutingKey string
+	Latency    time.Duration
+}
+
+// SelfTest publishes a synthetic message marked "selftest":true to the
+// self-test routing key and waits for the broker to confirm it. The message
+// is never spooled, so success means the broker really accepted it.
+func (s *IngestService) SelfTest(ctx context.Context) (SelfTestResult, error) {
+	requestID := requestid.New(s.idFormat)
+	receivedAt := s.clock.Now().UTC()
+	message := IngestMessage{
+		SchemaVersion: SchemaVersion,
+		Source:        s.source,
+		RequestID:     requestID,
+		ReceivedAt:    receivedAt.Format(s.receivedAtLayout),
+		Payload: IngestRequest{PM: []MeterReading{{
+			Date: receivedAt.Format(s.dateLayout),
+			Data: "[0]",
+			Name: "selftest",
+		}}},
+		SelfTest: true,
+	}
+
+	start := time.Now()
+	if err := s.publisher.Publish(ctx, s.selfTestRK, message, mq.PublishOptions{NoSpool: true}); err != nil {
+		return SelfTestResult{}, err
+	}
+	return SelfTestResult{
+		RequestID:  requestID,
+		RoutingKey: s.selfTestRK,
+		Latency:    time.Since(start),
+	}, nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// shardOf maps a meter name to one of count shards. It uses FNV-1a, so the
+// same name lands on the same shard on every instance and across restarts.
+func shardOf(name string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(count))
+}
+
+// shardPart is the share of a request's readings bound for one routing key
+type shardPart struct {
+	shard      int // -1 when sharding is off
+	routingKey string
+	readings   []MeterReading
+}
+
+// splitShards groups readings by the shard of their meter name, in order of
+// each shard's first reading and keeping the reading order within a shard.
+// The shard is appended to routingKey as ".shard.<n>". Without sharding it
+// returns a single part holding every reading.
+func (s *IngestService) splitShards(routingKey string, readings []MeterReading) []shardPart {
+	if s.shardCount <= 1 {
+		return []shardPart{{shard: -1, routingKey: routingKey, readings: readings}}
+	}
+
+	var parts []shardPart
+	index := make(map[int]int, s.shardCount) // shard -> position in parts
+	for _, r := range readings {
+		shard := shardOf(r.Name, s.shardCount)
+		i, ok := index[shard]
+		if !ok {
+			i = len(parts)
+			index[shard] = i
+			parts = append(parts, shardPart{shard: shard, routingKey: routingKey + ".shard." + strconv.Itoa(shard)})
+		}
+		parts[i].readings = append(parts[i].readings, r)
+	}
+	return parts
+}
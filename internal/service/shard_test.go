@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/septivank/energy-metering-ingest-api/internal/mq"
+)
+
+// fakeBatchPublisher is a fakePublisher that also publishes batches.
+// batchErr, when set, decides the error of every PublishBatch call.
+type fakeBatchPublisher struct {
+	fakePublisher
+	batchMu  sync.Mutex
+	batches  [][]mq.BatchMessage
+	batchErr func(msgs []mq.BatchMessage) error
+}
+
+func (f *fakeBatchPublisher) PublishBatch(ctx context.Context, msgs []mq.BatchMessage) error {
+	f.batchMu.Lock()
+	defer f.batchMu.Unlock()
+	f.batches = append(f.batches, msgs)
+	if f.batchErr != nil {
+		return f.batchErr(msgs)
+	}
+	return nil
+}
+
+func (f *fakeBatchPublisher) publishedBatches() [][]mq.BatchMessage {
+	f.batchMu.Lock()
+	defer f.batchMu.Unlock()
+	return append([][]mq.BatchMessage(nil), f.batches...)
+}
+
+// shardedRequest returns a request with one reading for each of n meters,
+// and how many distinct shards of count they span
+func shardedRequest(n, count int) (IngestRequest, int) {
+	var req IngestRequest
+	shards := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("meter-%d", i)
+		req.PM = append(req.PM, MeterReading{Name: name, Date: "01/03/2024 12:00:00", Data: "1"})
+		shards[shardOf(name, count)] = true
+	}
+	return req, len(shards)
+}
+
+func TestBatchConfirmsPublishShardsAsOneBatch(t *testing.T) {
+	pub := &fakeBatchPublisher{}
+	s := newTestService(t, pub, func(o *Options) {
+		o.ShardCount = 4
+		o.BatchConfirms = true
+	})
+
+	req, shards := shardedRequest(20, 4)
+	if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	batches := pub.publishedBatches()
+	if len(batches) != 1 || len(batches[0]) != shards {
+		t.Fatalf("published batches %v, want one batch of %d shard messages", batches, shards)
+	}
+	for _, m := range batches[0] {
+		var msg IngestMessage
+		if err := json.Unmarshal(m.Body, &msg); err != nil {
+			t.Fatalf("batch body: %v", err)
+		}
+		if msg.Shard == nil || m.RoutingKey != fmt.Sprintf("meter.reading.ingested.shard.%d", *msg.Shard) {
+			t.Errorf("routing key %q for shard %v", m.RoutingKey, msg.Shard)
+		}
+	}
+	if len(pub.published()) != 0 {
+		t.Errorf("%d messages were also published one by one", len(pub.published()))
+	}
+}
+
+func TestBatchConfirmsSingleMessageUsesPublish(t *testing.T) {
+	pub := &fakeBatchPublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.BatchConfirms = true })
+
+	if _, err := s.ProcessReading(context.Background(), readings("m", "01/03/2024 12:00:00", "1"), ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if len(pub.publishedBatches()) != 0 || len(pub.published()) != 1 {
+		t.Errorf("a single message went through a batch, want Publish")
+	}
+}
+
+func TestSyncConfirmsPublishShardsOneByOne(t *testing.T) {
+	pub := &fakeBatchPublisher{}
+	s := newTestService(t, pub, func(o *Options) { o.ShardCount = 4 })
+
+	req, shards := shardedRequest(20, 4)
+	if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+	if len(pub.publishedBatches()) != 0 || len(pub.published()) != shards {
+		t.Errorf("published %d batches and %d messages, want %d messages", len(pub.publishedBatches()), len(pub.published()), shards)
+	}
+}
+
+func TestBatchConfirmsResendFailedMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		batchErr   error
+		failResend bool
+		wantResend func(shards int) int
+		wantErr    bool
+		unknown    bool
+	}{
+		{
+			name:       "partial nack resends the nacked messages",
+			batchErr:   &mq.BatchError{Failed: []int{1}},
+			wantResend: func(int) int { return 1 },
+		},
+		{
+			name:       "other failure resends every message",
+			batchErr:   fmt.Errorf("%w: confirm timeout", mq.ErrDeliveryUnknown),
+			wantResend: func(shards int) int { return shards },
+		},
+		{
+			name:       "failed resend after a partial nack",
+			batchErr:   &mq.BatchError{Failed: []int{1}},
+			failResend: true,
+			wantResend: func(int) int { return 1 },
+			wantErr:    true,
+			unknown:    true,
+		},
+		{
+			name:       "failed resend after nothing was published",
+			batchErr:   mq.ErrNotConnected,
+			failResend: true,
+			wantResend: func(int) int { return 1 },
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakeBatchPublisher{batchErr: func([]mq.BatchMessage) error { return tt.batchErr }}
+			if tt.failResend {
+				pub.fail = func(int) error { return &mq.NackError{DeliveryTag: 1} }
+			}
+			s := newTestService(t, pub, func(o *Options) {
+				o.ShardCount = 4
+				o.BatchConfirms = true
+			})
+
+			req, shards := shardedRequest(20, 4)
+			_, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProcessReading = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrBrokerUnavailable) || DeliveryUnknown(err) != tt.unknown {
+					t.Errorf("ProcessReading = %v, want broker unavailable with delivery unknown %t", err, tt.unknown)
+				}
+			}
+			if got, want := len(pub.published()), tt.wantResend(shards); got != want {
+				t.Errorf("resent %d messages, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestShardOfIsDeterministic(t *testing.T) {
+	for _, name := range []string{"meter-1", "meter-2", "substation-a/feeder-7", ""} {
+		first := shardOf(name, 8)
+		for i := 0; i < 10; i++ {
+			if got := shardOf(name, 8); got != first {
+				t.Fatalf("shardOf(%q) = %d then %d", name, first, got)
+			}
+		}
+		if first < 0 || first >= 8 {
+			t.Errorf("shardOf(%q, 8) = %d, out of range", name, first)
+		}
+	}
+	// Pinned so a change of hash, which would remap every meter, is noticed
+	if got := shardOf("meter-1", 8); got != 4 {
+		t.Errorf("shardOf(meter-1, 8) = %d, want 4", got)
+	}
+}
+
+func TestShardOfIsUniform(t *testing.T) {
+	const count, meters = 8, 8000
+	counts := make([]int, count)
+	for i := 0; i < meters; i++ {
+		counts[shardOf(fmt.Sprintf("meter-%d", i), count)]++
+	}
+	// Each shard should get its share within 10%
+	want := meters / count
+	for shard, n := range counts {
+		if n < want*9/10 || n > want*11/10 {
+			t.Errorf("shard %d got %d of %d meters, want about %d", shard, n, meters, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// PublishStatus is the outcome of publishing an ingest request
+type PublishStatus string
+
+const (
+	StatusPending   PublishStatus = "pending"
+	StatusPublished PublishStatus = "published"
+	StatusFailed    PublishStatus = "failed"
+)
+
+// StatusEntry is the recorded status of one request
+type StatusEntry struct {
+	Status    PublishStatus
+	UpdatedAt time.Time
+}
+
+type statusRecord struct {
+	requestID string
+	entry     StatusEntry
+	expiresAt time.Time
+}
+
+// StatusStore is a bounded in-memory map of request ID to publish status.
+// Entries expire ttl after the request was first recorded, and the oldest
+// entries are evicted once maxEntries is reached. Nothing is persisted.
+type StatusStore struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	records map[string]*list.Element
+	order   *list.List // oldest first; every record shares the same ttl
+}
+
+// NewStatusStore creates a status store
+func NewStatusStore(ttl time.Duration, maxEntries int) *StatusStore {
+	return &StatusStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		records:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Set records the status of a request, keeping its original expiry when it
+// is already known
+func (s *StatusStore) Set(requestID string, status PublishStatus) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.records[requestID]; ok {
+		el.Value.(*statusRecord).entry = StatusEntry{Status: status, UpdatedAt: now}
+		return
+	}
+
+	s.evict(now)
+	for s.maxEntries > 0 && s.order.Len() >= s.maxEntries {
+		s.remove(s.order.Front())
+	}
+	s.records[requestID] = s.order.PushBack(&statusRecord{
+		requestID: requestID,
+		entry:     StatusEntry{Status: status, UpdatedAt: now},
+		expiresAt: now.Add(s.ttl),
+	})
+}
+
+// Get returns the status of a request while it is within the window
+func (s *StatusStore) Get(requestID string) (StatusEntry, bool) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(now)
+	el, ok := s.records[requestID]
+	if !ok {
+		return StatusEntry{}, false
+	}
+	return el.Value.(*statusRecord).entry, true
+}
+
+// evict drops expired records from the front of the list
+func (s *StatusStore) evict(now time.Time) {
+	for el := s.order.Front(); el != nil && !now.Before(el.Value.(*statusRecord).expiresAt); el = s.order.Front() {
+		s.remove(el)
+	}
+}
+
+func (s *StatusStore) remove(el *list.Element) {
+	delete(s.records, el.Value.(*statusRecord).requestID)
+	s.order.Remove(el)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDuplicateNamePolicies(t *testing.T) {
+	const d1, d2 = "01/03/2024 12:00:00", "01/03/2024 12:15:00"
+	// m1 appears three times: index 1 is an exact duplicate of index 0, and
+	// index 3 is a later reading of the same meter
+	repeated := readings("m1", d1, "1", "m1", d1, "1", "m2", d1, "2", "m1", d2, "3")
+	// The last m1 reading is invalid, so partial acceptance drops it first
+	lastInvalid := readings("m1", d1, "1", "m2", d1, "2", "m1", " ", "3")
+
+	tests := []struct {
+		name         string
+		policy       string
+		partial      bool
+		dedupe       bool
+		req          IngestRequest
+		wantReason   string // rejection of the whole request, if any
+		wantData     []string
+		wantDropped  int
+		wantAccepted []int // AcceptedIndices, with partial acceptance
+		wantRejected []int // indices of Rejected, with partial acceptance
+	}{
+		{name: "reject", policy: DuplicateNamesReject, req: repeated, wantReason: RejectDuplicateName},
+		{name: "reject after dedupe", policy: DuplicateNamesReject, dedupe: true, req: repeated, wantReason: RejectDuplicateName},
+		{
+			name: "reject with partial", policy: DuplicateNamesReject, partial: true, req: repeated,
+			wantData: []string{"1", "2"}, wantAccepted: []int{0, 2}, wantRejected: []int{1, 3},
+		},
+		{
+			name: "reject with partial after dedupe", policy: DuplicateNamesReject, partial: true, dedupe: true, req: repeated,
+			wantData: []string{"1", "2"}, wantDropped: 1, wantAccepted: []int{0, 2}, wantRejected: []int{3},
+		},
+		{
+			name: "reject with partial and invalid repeat", policy: DuplicateNamesReject, partial: true, req: lastInvalid,
+			wantData: []string{"1", "2"}, wantAccepted: []int{0, 1}, wantRejected: []int{2},
+		},
+		{name: "keep_last", policy: DuplicateNamesKeepLast, req: repeated, wantData: []string{"2", "3"}, wantDropped: 2},
+		{name: "keep_last after dedupe", policy: DuplicateNamesKeepLast, dedupe: true, req: repeated, wantData: []string{"2", "3"}, wantDropped: 2},
+		{
+			name: "keep_last with partial", policy: DuplicateNamesKeepLast, partial: true, req: repeated,
+			wantData: []string{"2", "3"}, wantDropped: 2, wantAccepted: []int{2, 3},
+		},
+		{
+			name: "keep_last with partial keeps the last valid reading", policy: DuplicateNamesKeepLast, partial: true, req: lastInvalid,
+			wantData: []string{"1", "2"}, wantAccepted: []int{0, 1}, wantRejected: []int{2},
+		},
+		{name: "keep_last with invalid repeat", policy: DuplicateNamesKeepLast, req: lastInvalid, wantReason: RejectMissingDate},
+		{name: "no policy", req: repeated, wantData: []string{"1", "1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) {
+				o.DuplicateNames = tt.policy
+				o.PartialAccept = tt.partial
+				o.DedupeWithinRequest = tt.dedupe
+			})
+			req := IngestRequest{PM: append([]MeterReading(nil), tt.req.PM...)}
+
+			result, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+			if tt.wantReason != "" {
+				if RejectionReason(err) != tt.wantReason {
+					t.Fatalf("ProcessReading = %v, want a %s rejection", err, tt.wantReason)
+				}
+				if n := len(pub.published()); n != 0 {
+					t.Errorf("published %d messages, want none", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessReading: %v", err)
+			}
+
+			pm := nativeMessage(t, pub.published()[0]).Payload.PM
+			var data []string
+			for _, r := range pm {
+				data = append(data, r.Data)
+			}
+			if !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("published data %v, want %v", data, tt.wantData)
+			}
+			if result.DuplicatesDropped != tt.wantDropped {
+				t.Errorf("DuplicatesDropped = %d, want %d", result.DuplicatesDropped, tt.wantDropped)
+			}
+			if !tt.partial {
+				return
+			}
+			if !reflect.DeepEqual(result.AcceptedIndices, tt.wantAccepted) {
+				t.Errorf("AcceptedIndices = %v, want %v", result.AcceptedIndices, tt.wantAccepted)
+			}
+			var rejected []int
+			for _, r := range result.Rejected {
+				rejected = append(rejected, r.Index)
+			}
+			if !reflect.DeepEqual(rejected, tt.wantRejected) {
+				t.Errorf("rejected indices %v, want %v (%+v)", rejected, tt.wantRejected, result.Rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultDateLayout is the reading date format sent by collectors, e.g. "19/12/2025 15:27:53"
+const DefaultDateLayout = "02/01/2006 15:04:05"
+
+// The checks below return every violation rather than the first, so a rule
+// downgraded to a warning cannot hide a later violation of another rule.
+
+// parseReadingDates parses every reading date with layout. A date that does
+// not parse is left zero, and the checks on dates skip it.
+func parseReadingDates(readings []MeterReading, layout string) ([]time.Time, []*FieldError) {
+	dates := make([]time.Time, len(readings))
+	var findings []*FieldError
+	for i, reading := range readings {
+		t, err := time.Parse(layout, reading.Date)
+		if err != nil {
+			findings = append(findings, &FieldError{Index: i, Field: "date", Reason: fmt.Sprintf("%q does not match layout %q", reading.Date, layout), Rule: RejectInvalidDate})
+			continue
+		}
+		dates[i] = t
+	}
+	return dates, findings
+}
+
+// checkOrdered ensures dates are non-decreasing; equal timestamps are allowed.
+// index maps each date to its reading's index in the request, to name the
+// earlier reading.
+func checkOrdered(dates []time.Time, index []int) []*FieldError {
+	var findings []*FieldError
+	prev := -1
+	for i, date := range dates {
+		if date.IsZero() {
+			continue
+		}
+		if prev >= 0 && date.Before(dates[prev]) {
+			findings = append(findings, &FieldError{Index: i, Field: "date", Reason: fmt.Sprintf("is earlier than PM[%d].date: readings must be in ascending time order", index[prev]), Rule: RejectUnordered})
+			continue
+		}
+		prev = i
+	}
+	return findings
+}
+
+// checkMaxAge ensures no date is earlier than cutoff
+func checkMaxAge(dates []time.Time, cutoff time.Time) []*FieldError {
+	var findings []*FieldError
+	for i, date := range dates {
+		if !date.IsZero() && date.Before(cutoff) {
+			findings = append(findings, &FieldError{Index: i, Field: "date", Reason: "is older than the maximum reading age", Rule: RejectTooOld})
+		}
+	}
+	return findings
+}
+
+// FieldLimits caps the length in bytes of each reading field; zero means unlimited
+type FieldLimits struct {
+	Name int
+	Date int
+	Data int
+}
+
+// checkFieldLengths ensures no reading field exceeds its limit. It runs before
+// any other per-field check so oversized values are never parsed or matched.
+func checkFieldLengths(readings []MeterReading, limits FieldLimits) []*FieldError {
+	var findings []*FieldError
+	for i, reading := range readings {
+		for _, field := range []struct {
+			name  string
+			value string
+			limit int
+		}{
+			{"name", reading.Name, limits.Name},
+			{"date", reading.Date, limits.Date},
+			{"data", reading.Data, limits.Data},
+		} {
+			if field.limit > 0 && len(field.value) > field.limit {
+				findings = append(findings, &FieldError{Index: i, Field: field.name, Reason: fmt.Sprintf("is %d bytes, exceeding the limit of %d", len(field.value), field.limit), Rule: RejectTooLarge})
+			}
+		}
+	}
+	return findings
+}
+
+// checkRequiredFields ensures every reading has a date, data and name; only
+// the first missing field of a reading is reported
+func checkRequiredFields(readings []MeterReading) []*FieldError {
+	var findings []*FieldError
+	for i, reading := range readings {
+		switch {
+		case reading.Date == "":
+			findings = append(findings, &FieldError{Index: i, Field: "date", Reason: "cannot be empty", Rule: RejectMissingDate})
+		case reading.Data == "":
+			findings = append(findings, &FieldError{Index: i, Field: "data", Reason: "cannot be empty", Rule: RejectMissingData})
+		case reading.Name == "":
+			findings = append(findings, &FieldError{Index: i, Field: "name", Reason: "cannot be empty", Rule: RejectMissingName})
+		}
+	}
+	return findings
+}
+
+// checkMeterNames ensures every reading name is in allowed or, when pattern is
+// set, fully matches it. Either check is skipped when unset.
+func checkMeterNames(readings []MeterReading, allowed map[string]struct{}, pattern *regexp.Regexp) []*FieldError {
+	var findings []*FieldError
+	for i, reading := range readings {
+		if len(allowed) > 0 {
+			if _, ok := allowed[reading.Name]; !ok {
+				findings = append(findings, &FieldError{Index: i, Field: "name", Reason: fmt.Sprintf("%q is not an allowed meter name", reading.Name), Rule: RejectUnknownMeter})
+			}
+		}
+		if pattern != nil && !pattern.MatchString(reading.Name) {
+			findings = append(findings, &FieldError{Index: i, Field: "name", Reason: fmt.Sprintf("%q does not match the meter name pattern", reading.Name), Rule: RejectInvalidName})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequireOrderedReadings(t *testing.T) {
+	tests := []struct {
+		name      string
+		dates     []string
+		wantIndex int // -1 when the batch is accepted
+	}{
+		{"ordered", []string{"01/03/2024 12:00:00", "01/03/2024 12:15:00", "01/03/2024 12:30:00"}, -1},
+		{"duplicate timestamps", []string{"01/03/2024 12:00:00", "01/03/2024 12:00:00", "01/03/2024 12:15:00"}, -1},
+		{"unordered", []string{"01/03/2024 12:00:00", "01/03/2024 12:30:00", "01/03/2024 12:15:00"}, 2},
+		{"unordered across days", []string{"02/03/2024 00:00:00", "01/03/2024 23:59:59"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req IngestRequest
+			for i, date := range tt.dates {
+				req.PM = append(req.PM, MeterReading{Name: string(rune('a' + i)), Date: date, Data: "1"})
+			}
+			s := newTestService(t, &fakePublisher{}, func(o *Options) { o.RequireOrderedReadings = true })
+
+			_, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+			if tt.wantIndex < 0 {
+				if err != nil {
+					t.Fatalf("ProcessReading: %v", err)
+				}
+				return
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("ProcessReading = %v, want a *FieldError", err)
+			}
+			if fieldErr.Index != tt.wantIndex || fieldErr.Rule != RejectUnordered {
+				t.Errorf("rejected PM[%d] for %s, want PM[%d] for %s", fieldErr.Index, fieldErr.Rule, tt.wantIndex, RejectUnordered)
+			}
+		})
+	}
+}
+
+func TestUnorderedReadingsAcceptedWhenDisabled(t *testing.T) {
+	s := newTestService(t, &fakePublisher{}, nil)
+	req := readings("a", "01/03/2024 12:30:00", "1", "b", "01/03/2024 12:00:00", "1")
+	if _, err := s.ProcessReading(context.Background(), req, ClientMetadata{}); err != nil {
+		t.Fatalf("ProcessReading: %v", err)
+	}
+}
+
+func TestMeterNameAllowList(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []string
+		pattern   string
+		meters    []string
+		wantIndex int // -1 when the batch is accepted
+		wantRule  string
+	}{
+		{name: "allowed", allowed: []string{"meter-1", "meter-2"}, meters: []string{"meter-1", "meter-2"}, wantIndex: -1},
+		{name: "disallowed", allowed: []string{"meter-1"}, meters: []string{"meter-1", "meter-9"}, wantIndex: 1, wantRule: RejectUnknownMeter},
+		{name: "empty list passes through", meters: []string{"anything", "else"}, wantIndex: -1},
+		{name: "pattern match", pattern: `meter-\d+`, meters: []string{"meter-1", "meter-22"}, wantIndex: -1},
+		{name: "pattern must match fully", pattern: `meter-\d+`, meters: []string{"meter-1", "xmeter-2"}, wantIndex: 1, wantRule: RejectInvalidName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req IngestRequest
+			for _, name := range tt.meters {
+				req.PM = append(req.PM, MeterReading{Name: name, Date: "01/03/2024 12:00:00", Data: "1"})
+			}
+			s := newTestService(t, &fakePublisher{}, func(o *Options) {
+				o.AllowedMeterNames = tt.allowed
+				if tt.pattern != "" {
+					o.MeterNamePattern = regexp.MustCompile(`^(?:` + tt.pattern + `)$`)
+				}
+			})
+
+			_, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+			if tt.wantIndex < 0 {
+				if err != nil {
+					t.Fatalf("ProcessReading: %v", err)
+				}
+				return
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("ProcessReading = %v, want a *FieldError", err)
+			}
+			if fieldErr.Index != tt.wantIndex || fieldErr.Rule != tt.wantRule || !strings.Contains(fieldErr.Error(), tt.meters[tt.wantIndex]) {
+				t.Errorf("error = %v (rule %s), want PM[%d] named for %s", fieldErr, fieldErr.Rule, tt.wantIndex, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestFieldLengthLimits(t *testing.T) {
+	limits := FieldLimits{Name: 8, Date: 19, Data: 4}
+	valid := MeterReading{Name: "meter-01", Date: "01/03/2024 12:00:00", Data: "1234"}
+
+	tests := []struct {
+		name      string
+		edit      func(*MeterReading)
+		wantField string // empty when the reading is accepted
+	}{
+		{"all at the limit", func(*MeterReading) {}, ""},
+		{"name one over", func(r *MeterReading) { r.Name += "x" }, "name"},
+		{"date one over", func(r *MeterReading) { r.Date += "0" }, "date"},
+		{"data one over", func(r *MeterReading) { r.Data += "5" }, "data"},
+		// Limits count bytes, so a multi-byte character can tip a value over
+		{"multi-byte name", func(r *MeterReading) { r.Name = "meter-0é" }, "name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reading := valid
+			tt.edit(&reading)
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) { o.FieldLimits = limits })
+
+			_, err := s.ProcessReading(context.Background(), IngestRequest{PM: []MeterReading{valid, reading}}, ClientMetadata{})
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("ProcessReading: %v", err)
+				}
+				return
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("ProcessReading = %v, want a *FieldError", err)
+			}
+			if fieldErr.Index != 1 || fieldErr.Field != tt.wantField || fieldErr.Rule != RejectTooLarge {
+				t.Errorf("error = %v, want PM[1].%s rejected as too large", fieldErr, tt.wantField)
+			}
+			if len(pub.published()) != 0 {
+				t.Error("a message was built and published despite the oversized field")
+			}
+		})
+	}
+}
+
+func TestFieldLengthCheckedBeforeOtherRules(t *testing.T) {
+	s := newTestService(t, &fakePublisher{}, func(o *Options) {
+		o.FieldLimits = FieldLimits{Date: 19}
+		o.RequireOrderedReadings = true
+	})
+	_, err := s.ProcessReading(context.Background(), readings("m", strings.Repeat("9", 1<<20), "1"), ClientMetadata{})
+	var fieldErr *FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Rule != RejectTooLarge {
+		t.Fatalf("ProcessReading = %v, want the oversized date rejected before it is parsed", err)
+	}
+}
+
+func TestMaxReadingAge(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		date   string
+		reject bool
+	}{
+		{"newer than the cutoff", time.Hour, "01/03/2024 11:31:00", false},
+		{"exactly at the cutoff", time.Hour, "01/03/2024 11:30:45", false},
+		{"one second past the cutoff", time.Hour, "01/03/2024 11:30:44", true},
+		{"months old", time.Hour, "01/12/2023 12:00:00", true},
+		{"disabled accepts anything", 0, "01/01/2000 00:00:00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &fakePublisher{}, func(o *Options) {
+				o.Clock = fixedClock{now}
+				o.MaxReadingAge = tt.maxAge
+			})
+
+			req := readings("a", "01/03/2024 12:00:00", "1", "b", tt.date, "1")
+			_, err := s.ProcessReading(context.Background(), req, ClientMetadata{})
+			if !tt.reject {
+				if err != nil {
+					t.Fatalf("ProcessReading: %v", err)
+				}
+				return
+			}
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Index != 1 || fieldErr.Rule != RejectTooOld {
+				t.Errorf("ProcessReading = %v, want PM[1] rejected as too old", err)
+			}
+		})
+	}
+}
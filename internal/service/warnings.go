@@ -0,0 +1,42 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warning is a validation finding whose rule was downgraded by
+// Options.WarnRules: the reading is accepted and published regardless
+type Warning struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"` // e.g. `PM[2].name "x" is not an allowed meter name`
+}
+
+// triage returns the first finding whose rule rejects the request. When every
+// finding is downgraded, it appends them to warnings and returns nil.
+func (s *IngestService) triage(findings []*FieldError, warnings *[]Warning) error {
+	for _, f := range findings {
+		if _, ok := s.warnRules[f.Rule]; !ok {
+			return f
+		}
+	}
+	for _, f := range findings {
+		*warnings = append(*warnings, Warning{Rule: f.Rule, Message: f.detail()})
+	}
+	return nil
+}
+
+// joinWarnings renders warnings as one string, for formats whose attributes
+// must be scalar
+func joinWarnings(warnings []Warning) string {
+	messages := make([]string, len(warnings))
+	for i, w := range warnings {
+		messages[i] = w.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// detail describes the finding without the ErrValidation prefix
+func (e *FieldError) detail() string {
+	return fmt.Sprintf("PM[%d].%s %s", e.Index, e.Field, e.Reason)
+}
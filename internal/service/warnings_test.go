@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWarnRules(t *testing.T) {
+	tests := []struct {
+		name         string
+		warnRules    []string
+		req          IngestRequest
+		wantRule     string // rule of the rejection, or "" when accepted
+		wantWarnings []string
+	}{
+		{
+			name:         "unknown meter warns",
+			warnRules:    []string{RejectUnknownMeter},
+			req:          readings("m9", "01/03/2024 12:00:00", "1"),
+			wantWarnings: []string{RejectUnknownMeter},
+		},
+		{
+			name:     "unknown meter rejects",
+			req:      readings("m9", "01/03/2024 12:00:00", "1"),
+			wantRule: RejectUnknownMeter,
+		},
+		{
+			name:         "old and unordered both warn",
+			warnRules:    []string{RejectUnordered, RejectTooOld},
+			req:          readings("m1", "01/03/2024 12:00:00", "1", "m1", "01/01/2024 12:00:00", "2"),
+			wantWarnings: []string{RejectUnordered, RejectTooOld},
+		},
+		{
+			name:      "rejecting rule wins over a warning",
+			warnRules: []string{RejectUnknownMeter},
+			req:       readings("m1", "01/01/2024 12:00:00", "1", "m9", "01/03/2024 12:00:00", "2"),
+			wantRule:  RejectTooOld,
+		},
+		{
+			name:      "shape rules always reject",
+			warnRules: []string{RejectUnknownMeter},
+			req:       readings("m9", " ", "1"),
+			wantRule:  RejectMissingDate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := newTestService(t, pub, func(o *Options) {
+				o.WarnRules = tt.warnRules
+				o.AllowedMeterNames = []string{"m1"}
+				o.RequireOrderedReadings = true
+				o.MaxReadingAge = 7 * 24 * time.Hour
+			})
+
+			result, err := s.ProcessReading(context.Background(), tt.req, ClientMetadata{})
+			if tt.wantRule != "" {
+				if !errors.Is(err, ErrValidation) || RejectionReason(err) != tt.wantRule {
+					t.Fatalf("ProcessReading = %v, want a %s rejection", err, tt.wantRule)
+				}
+				if n := len(pub.published()); n != 0 {
+					t.Errorf("published %d messages for a rejected request", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessReading: %v", err)
+			}
+			if !sameRules(result.Warnings, tt.wantWarnings) {
+				t.Errorf("result warnings %+v, want rules %v", result.Warnings, tt.wantWarnings)
+			}
+			calls := pub.published()
+			if len(calls) != 1 {
+				t.Fatalf("published %d messages, want 1", len(calls))
+			}
+			if msg := nativeMessage(t, calls[0]); !sameRules(msg.Warnings, tt.wantWarnings) {
+				t.Errorf("message warnings %+v, want rules %v", msg.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+// sameRules reports whether warnings carry exactly rules, in order
+func sameRules(warnings []Warning, rules []string) bool {
+	if len(warnings) != len(rules) {
+		return false
+	}
+	for i, w := range warnings {
+		if w.Rule != rules[i] || w.Message == "" {
+			return false
+		}
+	}
+	return true
+}
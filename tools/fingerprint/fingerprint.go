@@ -4,11 +4,50 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
-// Generate creates a unique client fingerprint based on IP and User-Agent
-func Generate(ipAddress, userAgent string) string {
-	data := fmt.Sprintf("%s%s", ipAddress, userAgent)
+// Anonymous is returned for a client without any identifying attribute,
+// instead of a hash that every such client would share
+const Anonymous = "anonymous"
+
+// Input holds the client attributes a fingerprint is derived from. IP and
+// User-Agent are always used; the other fields are mixed in only when
+// non-empty, so callers choose what distinguishes one client from another.
+type Input struct {
+	IPAddress      string
+	UserAgent      string
+	KeyID          string
+	TenantID       string
+	DeviceID       string
+	AcceptLanguage string
+}
+
+// Generate creates a client fingerprint from in. The User-Agent is trimmed
+// and its inner whitespace collapsed first, so trivial variations map to one
+// fingerprint. With only IP and a normalized User-Agent set, the result equals
+// the fingerprint of earlier versions. It returns Anonymous when every input
+// is empty.
+func Generate(in Input) string {
+	in.UserAgent = strings.Join(strings.Fields(in.UserAgent), " ")
+	if in == (Input{}) {
+		return Anonymous
+	}
+
+	data := fmt.Sprintf("%s%s", in.IPAddress, in.UserAgent)
+
+	// Optional fields are labelled so values cannot shift between fields
+	for _, field := range []struct{ name, value string }{
+		{"key_id", in.KeyID},
+		{"tenant_id", in.TenantID},
+		{"device_id", in.DeviceID},
+		{"accept_language", in.AcceptLanguage},
+	} {
+		if field.value != "" {
+			data += "\x00" + field.name + "=" + field.value
+		}
+	}
+
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
@@ -0,0 +1,73 @@
+package fingerprint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestGenerateMatchesIPAndUserAgentHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("203.0.113.7collector/1.0"))
+	want := hex.EncodeToString(sum[:])
+	if got := Generate(Input{IPAddress: "203.0.113.7", UserAgent: "collector/1.0"}); got != want {
+		t.Errorf("Generate = %s, want the IP and User-Agent hash %s", got, want)
+	}
+}
+
+func TestGenerateSharedNAT(t *testing.T) {
+	// Two collectors of the same model behind one NAT address
+	nat := Input{IPAddress: "198.51.100.1", UserAgent: "collector/1.0"}
+
+	tests := []struct {
+		name    string
+		a, b    func(*Input)
+		collide bool
+	}{
+		{"IP and User-Agent only", func(*Input) {}, func(*Input) {}, true},
+		{"distinct device IDs", func(in *Input) { in.DeviceID = "dev-1" }, func(in *Input) { in.DeviceID = "dev-2" }, false},
+		{"distinct key IDs", func(in *Input) { in.KeyID = "key-1" }, func(in *Input) { in.KeyID = "key-2" }, false},
+		{"distinct tenants", func(in *Input) { in.TenantID = "acme" }, func(in *Input) { in.TenantID = "globex" }, false},
+		{"distinct languages", func(in *Input) { in.AcceptLanguage = "en" }, func(in *Input) { in.AcceptLanguage = "de" }, false},
+		{"same device ID", func(in *Input) { in.DeviceID = "dev-1" }, func(in *Input) { in.DeviceID = "dev-1" }, true},
+		// Labelled fields keep a value from matching when moved to another field
+		{"same value in different fields", func(in *Input) { in.DeviceID = "x" }, func(in *Input) { in.KeyID = "x" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := nat, nat
+			tt.a(&a)
+			tt.b(&b)
+			if got := Generate(a) == Generate(b); got != tt.collide {
+				t.Errorf("fingerprints collide = %t, want %t", got, tt.collide)
+			}
+		})
+	}
+}
+
+func TestGenerateAnonymous(t *testing.T) {
+	for _, in := range []Input{{}, {UserAgent: "   "}, {UserAgent: "\t\n"}} {
+		if got := Generate(in); got != Anonymous {
+			t.Errorf("Generate(%+v) = %q, want %q", in, got, Anonymous)
+		}
+	}
+	if got := Generate(Input{IPAddress: "203.0.113.7"}); got == Anonymous {
+		t.Errorf("Generate with an IP = %q, want a hash", got)
+	}
+}
+
+func TestGenerateNormalizesUserAgent(t *testing.T) {
+	want := Generate(Input{IPAddress: "203.0.113.7", UserAgent: "collector/1.0 (linux)"})
+	for _, ua := range []string{
+		"  collector/1.0 (linux)",
+		"collector/1.0 (linux)\n",
+		"collector/1.0   (linux)",
+		"collector/1.0\t(linux)",
+	} {
+		if got := Generate(Input{IPAddress: "203.0.113.7", UserAgent: ua}); got != want {
+			t.Errorf("Generate with User-Agent %q = %s, want %s", ua, got, want)
+		}
+	}
+	if got := Generate(Input{IPAddress: "203.0.113.7", UserAgent: "collector/1.1 (linux)"}); got == want {
+		t.Error("distinct User-Agents share a fingerprint")
+	}
+}
@@ -0,0 +1,94 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Request ID formats selectable with REQUEST_ID_FORMAT
+const (
+	FormatUUID = "uuid" // random UUIDv4, e.g. 550e8400-e29b-41d4-a716-446655440000
+	FormatULID = "ulid" // time-sortable ULID, e.g. 01JA2X3Y4Z5A6B7C8D9E0FGHJK
+)
+
+// crockford is the ULID alphabet: Crockford's base32, without I, L, O and U
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidLen is the length of a ULID string: 128 bits in 5-bit characters
+const ulidLen = 26
+
+// New returns a new request ID in format; any format other than FormatULID
+// yields a UUID
+func New(format string) string {
+	if format == FormatULID {
+		return newULID(time.Now())
+	}
+	return uuid.New().String()
+}
+
+// Valid reports whether id is a well-formed request ID in format. UUIDs
+// must be in the canonical hyphenated form that New produces, so an accepted
+// client ID looks exactly like a generated one.
+func Valid(format, id string) bool {
+	if format == FormatULID {
+		return validULID(id)
+	}
+	if len(id) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(id)
+	return err == nil
+}
+
+// newULID encodes the millisecond timestamp of t in the first 48 bits and 80
+// random bits after it, so IDs sort lexically by creation time. IDs created
+// within the same millisecond are ordered randomly.
+func newULID(t time.Time) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixMilli())<<16)
+	// crypto/rand never fails on supported platforms
+	_, _ = rand.Read(b[6:])
+
+	var out [ulidLen]byte
+	// 130 bits of characters hold 128 bits of data: the first character
+	// carries only the top 3 bits
+	out[0] = crockford[b[0]>>5]
+	bits, acc := 5, uint(b[0]&0x1f)
+	i := 1
+	for _, c := range b[1:] {
+		acc = acc<<8 | uint(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[i] = crockford[(acc>>bits)&0x1f]
+			i++
+		}
+	}
+	return string(out[:])
+}
+
+// validULID reports whether id is 26 upper-case Crockford base32 characters
+// whose first one does not overflow 128 bits
+func validULID(id string) bool {
+	if len(id) != ulidLen || id[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !isCrockford(id[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isCrockford(c byte) bool {
+	for i := 0; i < len(crockford); i++ {
+		if crockford[i] == c {
+			return true
+		}
+	}
+	return false
+}